@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/samzong/prom-etl-db/internal/config"
+	"github.com/samzong/prom-etl-db/internal/database"
+	"github.com/samzong/prom-etl-db/internal/models"
+	"github.com/samzong/prom-etl-db/internal/querydef"
+)
+
+// runApply applies the YAML query definition files to query_configs
+func runApply(args []string) {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	dir := fs.String("dir", "", "directory of query definition files (default: QUERY_CONFIG_DIR)")
+	prune := fs.Bool("prune", false, "delete the queries that no file defines")
+	dryRun := fs.Bool("dry-run", false, "only print the plan")
+	_ = fs.Parse(args)
+
+	cfg, db := openQueryDB()
+	defer db.Close()
+
+	if *dir == "" {
+		*dir = cfg.QueryFiles.Dir
+	}
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "apply requires --dir or QUERY_CONFIG_DIR")
+		os.Exit(2)
+	}
+
+	plan, err := planQueryFiles(db, *dir, *prune)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	printPlan(plan)
+
+	if *dryRun || len(plan.Changes) == 0 {
+		return
+	}
+
+	if err := querydef.ApplyQueryPlan(db.GetConn(), plan); err != nil {
+		fatalf("%v", err)
+	}
+	fmt.Printf("Applied %d changes\n", len(plan.Changes))
+}
+
+// syncQueryFiles applies the query definition files on startup, with the prune setting of cfg
+func syncQueryFiles(cfg *models.Config, db *database.DB, log *slog.Logger) error {
+	if cfg.QueryFiles.Dir == "" {
+		return fmt.Errorf("query sync on startup requires QUERY_CONFIG_DIR")
+	}
+
+	plan, err := planQueryFiles(db, cfg.QueryFiles.Dir, cfg.QueryFiles.Prune)
+	if err != nil {
+		return err
+	}
+
+	if err := querydef.ApplyQueryPlan(db.GetConn(), plan); err != nil {
+		return err
+	}
+
+	for _, change := range plan.Changes {
+		log.Info("Query definition applied", "action", change.Action, "query_id", change.ID, "fields", change.Fields)
+	}
+	if len(plan.Unmanaged) > 0 {
+		log.Warn("Queries not defined in any file were kept", "queries", plan.Unmanaged)
+	}
+	log.Info("Query definitions synced",
+		"dir", cfg.QueryFiles.Dir,
+		"changes", len(plan.Changes),
+		"unchanged", plan.Unchanged)
+	return nil
+}
+
+// planQueryFiles loads the query definitions of dir and compares them with query_configs
+func planQueryFiles(db *database.DB, dir string, prune bool) (querydef.QueryPlan, error) {
+	desired, err := querydef.LoadQueriesFromDir(dir)
+	if err != nil {
+		return querydef.QueryPlan{}, err
+	}
+
+	current, invalid, err := config.LoadAllQueriesFromDB(db.GetConn())
+	if err != nil {
+		return querydef.QueryPlan{}, err
+	}
+	// Rows that cannot be parsed are planned as empty queries, so a file defining
+	// them rewrites every field and --prune deletes them
+	for _, id := range sortedKeys(invalid) {
+		current = append(current, models.QueryConfig{ID: id})
+	}
+
+	return querydef.PlanQueries(desired, current, prune), nil
+}
+
+// printPlan prints the changes of a plan, one per line
+func printPlan(plan querydef.QueryPlan) {
+	var created, updated, deleted int
+	for _, change := range plan.Changes {
+		switch change.Action {
+		case querydef.ActionCreate:
+			created++
+			fmt.Printf("+ create %s\n", change.ID)
+		case querydef.ActionUpdate:
+			updated++
+			fmt.Printf("~ update %s: %s\n", change.ID, strings.Join(change.Fields, ", "))
+		case querydef.ActionDelete:
+			deleted++
+			fmt.Printf("- delete %s\n", change.ID)
+		}
+	}
+
+	if len(plan.Unmanaged) > 0 {
+		fmt.Printf("Not defined in any file, kept without --prune: %s\n", strings.Join(plan.Unmanaged, ", "))
+	}
+	fmt.Printf("Plan: %d to create, %d to update, %d to delete, %d unchanged\n",
+		created, updated, deleted, plan.Unchanged)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/backfill"
+	"github.com/samzong/prom-etl-db/internal/config"
+)
+
+// backfillDateLayout is the date-only format accepted by --start and --end
+const backfillDateLayout = "2006-01-02"
+
+// runBackfill executes a query for every fire time of its schedule in a past window
+func runBackfill(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	queryID := fs.String("query-id", "", "ID of the query to backfill (required)")
+	start := fs.String("start", "", "first day (YYYY-MM-DD) or instant (RFC3339) of the window (required)")
+	end := fs.String("end", "", "last day (YYYY-MM-DD, inclusive) or instant (RFC3339) of the window (required)")
+	schedule := fs.String("schedule", "", "cron expression overriding the query's schedule")
+	concurrency := fs.Int("concurrency", 2, "number of fire times executed in parallel")
+	retries := fs.Int("retries", -1, "retries per fire time; negative uses the query's retry_count")
+	resume := fs.Bool("resume", true, "skip fire times that already have a successful execution")
+	dryRun := fs.Bool("dry-run", false, "list the fire times without executing them")
+	progress := fs.Duration("progress-interval", 10*time.Second, "how often progress is logged")
+	_ = fs.Parse(args)
+
+	if *queryID == "" || *start == "" || *end == "" {
+		fmt.Fprintln(os.Stderr, "backfill requires --query-id, --start and --end")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	startTime, err := parseBackfillTime(*start, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --start: %v\n", err)
+		os.Exit(2)
+	}
+	endTime, err := parseBackfillTime(*end, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --end: %v\n", err)
+		os.Exit(2)
+	}
+
+	cfg, log, db := bootstrap()
+	defer closeDB(db, log)
+
+	query, err := config.LoadQueryFromDB(db.GetConn(), *queryID)
+	if err != nil {
+		log.Error("Failed to load query", "query_id", *queryID, "error", err)
+		os.Exit(1)
+	}
+	if *retries >= 0 {
+		query.RetryCount = *retries
+	}
+
+	promClients := newPrometheusClients(cfg, db, log)
+	defer closePrometheusClients(promClients, log)
+
+	metricSink := newSink(cfg, db, log)
+	defer closeSink(metricSink, log)
+
+	exec := newExecutor(cfg, promClients, db, metricSink, nil, log)
+
+	// Stop feeding new fire times on interrupt; running ones are cancelled
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	result, err := backfill.NewBackfiller(exec, db, log).Run(ctx, *query, backfill.Options{
+		Start:            startTime,
+		End:              endTime,
+		Schedule:         *schedule,
+		Concurrency:      *concurrency,
+		Resume:           *resume,
+		DryRun:           *dryRun,
+		ProgressInterval: *progress,
+	})
+	if err != nil {
+		log.Error("Backfill failed", "query_id", query.ID, "error", err)
+		os.Exit(1)
+	}
+
+	log.Info("Backfill completed",
+		"query_id", query.ID,
+		"total", result.Total,
+		"already_completed", result.Skipped,
+		"succeeded", result.Succeeded,
+		"failed", result.Failed,
+		"duration", result.Duration.Round(time.Second).String())
+
+	if result.Failed > 0 {
+		failedTimes := make([]string, len(result.FailedTimes))
+		for i, t := range result.FailedTimes {
+			failedTimes[i] = t.Format(time.RFC3339)
+		}
+		log.Error("Some fire times failed; rerun the same command to retry them",
+			"query_id", query.ID,
+			"failed_times", failedTimes)
+		os.Exit(1)
+	}
+}
+
+// parseBackfillTime parses a date or RFC3339 instant in local time.
+// A date used as the end of the window covers the whole day.
+func parseBackfillTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.ParseInLocation(backfillDateLayout, value, time.Local); err == nil {
+		if endOfDay {
+			return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+		}
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD or RFC3339, got %q", value)
+	}
+	return t, nil
+}
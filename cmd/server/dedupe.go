@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// runDedupe removes duplicated metrics_data rows written before uk_series_timestamp existed
+func runDedupe(args []string) {
+	fs := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	queryID := fs.String("query-id", "", "only deduplicate this query (default: all queries)")
+	window := fs.Duration("window", time.Hour, "sample time range searched for duplicates per statement")
+	dryRun := fs.Bool("dry-run", false, "only report how many rows would be removed")
+	_ = fs.Parse(args)
+
+	_, log, db := bootstrap()
+	defer closeDB(db, log)
+
+	if *window <= 0 {
+		fmt.Fprintln(os.Stderr, "--window must be positive")
+		os.Exit(2)
+	}
+
+	oldest, newest, ok, err := db.DuplicateTimeRange(*queryID)
+	if err != nil {
+		log.Error("Failed to get sample time range", "error", err)
+		os.Exit(1)
+	}
+	if !ok {
+		log.Info("No samples to deduplicate", "query_id", *queryID)
+		return
+	}
+
+	// Walk the samples once, window by window, keeping the most recently written
+	// row of every sample
+	var samples, redundant, deleted int64
+	for from := oldest.Truncate(*window); !from.After(newest); from = from.Add(*window) {
+		groups, err := db.FindDuplicateGroups(*queryID, from, from.Add(*window))
+		if err != nil {
+			log.Error("Failed to find duplicates", "from", from.Format(time.RFC3339), "error", err)
+			os.Exit(1)
+		}
+		if len(groups) == 0 {
+			continue
+		}
+
+		for _, group := range groups {
+			samples++
+			redundant += group.Count - 1
+			if *dryRun {
+				continue
+			}
+
+			n, err := db.DeleteDuplicates(group)
+			if err != nil {
+				log.Error("Failed to delete duplicates", "query_id", group.QueryID, "error", err)
+				os.Exit(1)
+			}
+			deleted += n
+		}
+
+		log.Info("Dedupe progress",
+			"up_to", from.Add(*window).Format(time.RFC3339),
+			"newest", newest.Format(time.RFC3339),
+			"samples", samples,
+			"deleted_rows", deleted)
+	}
+
+	log.Info("Dedupe completed",
+		"query_id", *queryID,
+		"dry_run", *dryRun,
+		"samples", samples,
+		"redundant_rows", redundant,
+		"deleted_rows", deleted)
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
@@ -14,8 +17,13 @@ import (
 	"github.com/samzong/prom-etl-db/internal/database"
 	"github.com/samzong/prom-etl-db/internal/executor"
 	"github.com/samzong/prom-etl-db/internal/logger"
+	"github.com/samzong/prom-etl-db/internal/maintenance"
+	"github.com/samzong/prom-etl-db/internal/metrics"
 	"github.com/samzong/prom-etl-db/internal/models"
 	"github.com/samzong/prom-etl-db/internal/prometheus"
+	"github.com/samzong/prom-etl-db/internal/scheduler"
+	"github.com/samzong/prom-etl-db/internal/server"
+	"github.com/samzong/prom-etl-db/internal/sink"
 )
 
 // Version information (set by build flags)
@@ -26,9 +34,115 @@ var (
 )
 
 func main() {
-	// Print version information
-	fmt.Printf("prom-etl-db %s (built: %s, go: %s)\n", version, buildTime, goVersion)
+	// Print version information; stdout is left to command output such as query --output json
+	fmt.Fprintf(os.Stderr, "prom-etl-db %s (built: %s, go: %s)\n", version, buildTime, goVersion)
 
+	// Dispatch subcommand; running without one starts the service
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		runServe()
+	case "backfill":
+		runBackfill(args)
+	case "dedupe":
+		runDedupe(args)
+	case "maintain":
+		runMaintain(args)
+	case "partition":
+		runPartition(args)
+	case "migrate":
+		runMigrate(args)
+	case "query":
+		runQuery(args)
+	case "apply":
+		runApply(args)
+	case "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", command)
+		usage()
+		os.Exit(2)
+	}
+}
+
+// usage prints the available subcommands
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: prom-etl-db [command] [flags]
+
+Commands:
+  serve       Run scheduled queries as a long-running service (default)
+  backfill    Execute a query for every scheduled fire time in a past date range
+  dedupe      Remove duplicated metrics_data rows left by older versions
+  maintain    Apply the retention policies once
+  partition   Convert metrics_data to a partitioned table
+  migrate     Apply, revert or list database schema migrations
+  query       List, show, add, update, delete, enable or disable queries
+  apply       Apply YAML query definition files to query_configs
+  help        Show this help
+
+Run "prom-etl-db <command> -h" for command flags.
+`)
+}
+
+// runServe runs the long-running service
+func runServe() {
+	cfg, log, db := bootstrap()
+	defer closeDB(db, log)
+
+	// Bring the schema up to date before query_configs is read
+	if cfg.App.AutoMigrate {
+		if err := migrateUp(db, 0, log); err != nil {
+			log.Error("Failed to migrate database", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Apply the query definition files before query_configs is read
+	if cfg.QueryFiles.SyncOnStartup {
+		if err := syncQueryFiles(cfg, db, log); err != nil {
+			log.Error("Failed to sync query definitions", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Reload configuration with queries from database
+	cfg, err := config.LoadConfigWithDB(db.GetConn())
+	if err != nil {
+		log.Error("Failed to load configuration from database", "error", err)
+		os.Exit(1)
+	}
+
+	// Print configuration (mask sensitive data)
+	printConfig(cfg)
+
+	promClients := newPrometheusClients(cfg, db, log)
+	defer closePrometheusClients(promClients, log)
+
+	// Create self-instrumentation metrics
+	var appMetrics *metrics.Metrics
+	if cfg.App.MetricsEnabled {
+		appMetrics = metrics.NewMetrics(db.GetConn(), cfg.MySQL.Database)
+	}
+
+	metricSink := newSink(cfg, db, log)
+	defer closeSink(metricSink, log)
+
+	exec := newExecutor(cfg, promClients, db, metricSink, appMetrics, log)
+
+	// Run as long-running service
+	serviceCtx := context.Background()
+	if err := runService(serviceCtx, exec, db, promClients, appMetrics, cfg, log); err != nil {
+		log.Error("Service execution failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// bootstrap loads the configuration, creates the logger and connects to MySQL, exiting on failure
+func bootstrap() (*models.Config, *slog.Logger, *database.DB) {
 	// Load configuration (without queries)
 	cfg, err := config.LoadConfig()
 	if err != nil {
@@ -44,52 +158,88 @@ func main() {
 		"go_version", goVersion)
 
 	// Create database connection
+	db, err := connectDB(cfg)
+	if err != nil {
+		log.Error("Failed to connect to database", "error", err)
+		os.Exit(1)
+	}
+
+	return cfg, log, db
+}
+
+// connectDB connects to the configured MySQL database
+func connectDB(cfg *models.Config) (*database.DB, error) {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=True&loc=Local",
 		cfg.MySQL.Username, cfg.MySQL.Password, cfg.MySQL.Host, cfg.MySQL.Port,
 		cfg.MySQL.Database, cfg.MySQL.Charset)
 
-	db, err := database.NewDB(dsn)
-	if err != nil {
-		log.Error("Failed to connect to database", "error", err)
-		os.Exit(1)
+	return database.NewDB(dsn)
+}
+
+// closeDB closes the database connection
+func closeDB(db *database.DB, log *slog.Logger) {
+	if err := db.Close(); err != nil {
+		log.Error("Failed to close database", "error", err)
 	}
-	defer func() {
-		if err := db.Close(); err != nil {
-			log.Error("Failed to close database", "error", err)
-		}
-	}()
+}
 
-	// Reload configuration with queries from database
-	cfg, err = config.LoadConfigWithDB(db.GetConn())
+// newPrometheusClients creates the default Prometheus client and the clients of the
+// datasources table, exiting if the default client cannot be created
+func newPrometheusClients(cfg *models.Config, db *database.DB, log *slog.Logger) *prometheus.Registry {
+	// Create Prometheus client with authentication and TLS settings
+	promClient, err := prometheus.NewClientWithConfig(cfg.Prometheus, log)
 	if err != nil {
-		log.Error("Failed to load configuration from database", "error", err)
+		log.Error("Failed to create Prometheus client", "error", err)
 		os.Exit(1)
 	}
 
-	// Print configuration (mask sensitive data)
-	printConfig(cfg)
+	registry := prometheus.NewRegistry(promClient, func() ([]models.Datasource, error) {
+		return config.LoadDatasourcesFromDB(db.GetConn())
+	}, log)
+
+	// Queries of broken datasources fail on their own; the others keep running
+	if err := registry.Reload(); err != nil {
+		log.Error("Failed to load datasources", "error", err)
+	}
+
+	return registry
+}
 
-	// Parse timeout duration
-	timeoutDuration, err := time.ParseDuration(cfg.Prometheus.Timeout)
+// closePrometheusClients closes the Prometheus clients
+func closePrometheusClients(promClients *prometheus.Registry, log *slog.Logger) {
+	if err := promClients.Close(); err != nil {
+		log.Error("Failed to close Prometheus clients", "error", err)
+	}
+}
+
+// newSink creates the configured metric sinks, exiting on failure
+func newSink(cfg *models.Config, db *database.DB, log *slog.Logger) sink.Sink {
+	s, err := sink.New(cfg.Sink, db, log)
 	if err != nil {
-		log.Error("Failed to parse Prometheus timeout", "timeout", cfg.Prometheus.Timeout, "error", err)
+		log.Error("Failed to create sink", "sinks", cfg.Sink.Types, "error", err)
 		os.Exit(1)
 	}
+	return s
+}
+
+// closeSink closes the metric sinks
+func closeSink(s sink.Sink, log *slog.Logger) {
+	if err := s.Close(); err != nil {
+		log.Error("Failed to close sink", "sink", s.Name(), "error", err)
+	}
+}
 
-	// Create Prometheus client with logger
-	promClient, err := prometheus.NewClientWithLogger(cfg.Prometheus.URL, timeoutDuration, log)
+// newExecutor creates the query executor and tests its connections, exiting on failure
+func newExecutor(cfg *models.Config, promClients *prometheus.Registry, db *database.DB, s sink.Sink, appMetrics *metrics.Metrics, log *slog.Logger) *executor.Executor {
+	// Parse default query timeout
+	defaultQueryTimeout, err := time.ParseDuration(cfg.App.DefaultQueryTimeout)
 	if err != nil {
-		log.Error("Failed to create Prometheus client", "error", err)
+		log.Error("Failed to parse default query timeout", "timeout", cfg.App.DefaultQueryTimeout, "error", err)
 		os.Exit(1)
 	}
-	defer func() {
-		if err := promClient.Close(); err != nil {
-			log.Error("Failed to close Prometheus client", "error", err)
-		}
-	}()
 
 	// Create executor
-	exec := executor.NewExecutor(promClient, db, log)
+	exec := executor.NewExecutor(promClients, db, s, appMetrics, defaultQueryTimeout, log)
 
 	// Test connections
 	testCtx, testCancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -100,108 +250,187 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Run as long-running service
-	serviceCtx := context.Background()
-	if err := runService(serviceCtx, exec, cfg, log); err != nil {
-		log.Error("Service execution failed", "error", err)
-		os.Exit(1)
+	return exec
+}
+
+// promoteLabels adds the metrics_data columns of the labels promoted by queries.
+// Failures are logged; the labels stay available in the labels column.
+func promoteLabels(db *database.DB, queries []models.QueryConfig, log *slog.Logger) {
+	var labels []string
+	for _, query := range queries {
+		labels = append(labels, query.PromotedLabels...)
+	}
+
+	added, err := db.EnsureLabelColumns(labels)
+	for _, column := range added {
+		log.Info("Promoted label column added", "column", column)
+	}
+	if err != nil {
+		log.Error("Failed to promote labels", "error", err)
 	}
 }
 
+// newMaintenanceRunner creates the runner applying the retention policies of all queries, disabled ones included
+func newMaintenanceRunner(cfg *models.Config, db *database.DB, log *slog.Logger) (*maintenance.Runner, error) {
+	runner, err := maintenance.NewRunner(db, func() ([]models.QueryConfig, error) {
+		queries, invalid, err := config.LoadAllQueriesFromDB(db.GetConn())
+		for id, err := range invalid {
+			log.Error("Skipping retention of invalid query", "query_id", id, "error", err)
+		}
+		return queries, err
+	}, cfg.Maintenance, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create maintenance runner: %w", err)
+	}
+	return runner, nil
+}
+
 // runService runs the application as a long-running service with scheduled queries
-func runService(ctx context.Context, exec *executor.Executor, cfg *models.Config, log *slog.Logger) error {
+func runService(ctx context.Context, exec *executor.Executor, db *database.DB, promClients *prometheus.Registry, appMetrics *metrics.Metrics, cfg *models.Config, log *slog.Logger) error {
 	log.Info("Starting service mode with scheduled queries", "queries_count", len(cfg.Queries))
 
-	// Create cron scheduler with second precision
-	c := cron.New(cron.WithSeconds())
+	// Create the maintenance runner first, so an invalid schedule fails before anything starts
+	var maintenanceRunner *maintenance.Runner
+	var maintenanceSchedule cron.Schedule
+	var err error
+	if cfg.Maintenance.Schedule != "" {
+		maintenanceSchedule, err = scheduler.ParseSchedule(cfg.Maintenance.Schedule)
+		if err != nil {
+			return fmt.Errorf("invalid maintenance schedule: %w", err)
+		}
+		maintenanceRunner, err = newMaintenanceRunner(cfg, db, log)
+		if err != nil {
+			return err
+		}
 
-	// Schedule all queries
-	for _, query := range cfg.Queries {
-		if !query.Enabled {
-			log.Info("Skipping disabled query", "query_id", query.ID)
-			continue
+		// Partitions are otherwise only created by maintenance runs
+		if err := maintenanceRunner.EnsurePartitions(); err != nil {
+			log.Error("Failed to create partitions", "error", err)
 		}
+	} else if cfg.Maintenance.PartitionInterval != "" {
+		return fmt.Errorf("partition management requires MAINTENANCE_SCHEDULE")
+	}
 
-		// Capture query in closure
-		q := query
-		_, err := c.AddFunc(q.Schedule, func() {
-			queryCtx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-			defer cancel()
-
-			log.Info("Executing scheduled query",
-				"query_id", q.ID,
-				"name", q.Name,
-				"schedule", q.Schedule)
-
-			if err := exec.ExecuteQuery(queryCtx, &q); err != nil {
-				log.Error("Scheduled query execution failed",
-					"query_id", q.ID,
-					"error", err)
-			} else {
-				log.Info("Scheduled query executed successfully", "query_id", q.ID)
-			}
-		})
+	// Create worker pool bounding concurrent query executions
+	pool, err := executor.NewPool(exec, cfg.App.WorkerPool, cfg.App.WorkerQueue, cfg.App.OverlapPolicy, appMetrics, log)
+	if err != nil {
+		return fmt.Errorf("failed to create worker pool: %w", err)
+	}
+	pool.Start()
 
-		if err != nil {
-			return fmt.Errorf("failed to schedule query %s: %w", query.ID, err)
+	// Create scheduler that keeps cron entries in sync with query_configs;
+	// datasources are reloaded first so queries never see a stale datasource list
+	sched := scheduler.NewScheduler(pool, func() ([]models.QueryConfig, map[string]error, error) {
+		if err := promClients.Reload(); err != nil {
+			log.Error("Failed to reload datasources", "error", err)
 		}
+		queries, invalid, err := config.LoadQueriesFromDB(db.GetConn())
+		if err == nil {
+			promoteLabels(db, queries, log)
+		}
+		return queries, invalid, err
+	}, log)
 
-		log.Info("Query scheduled successfully",
-			"query_id", query.ID,
-			"name", query.Name,
-			"schedule", query.Schedule)
+	// Schedule all queries; queries that fail to load or schedule are reported and the others run
+	result, err := sched.Reload()
+	if err != nil {
+		return fmt.Errorf("failed to schedule queries: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		log.Error("Some queries could not be scheduled", "errors", result.Errors)
 	}
 
-	// Start the cron scheduler
-	c.Start()
-	log.Info("Cron scheduler started")
+	// Start HTTP server for health checks and inspection
+	httpServer := server.NewServer(cfg.App.HTTPPort, db, promClients, sched, log)
+	if err := httpServer.Start(); err != nil {
+		return fmt.Errorf("failed to start HTTP server: %w", err)
+	}
 
-	// Run initial execution of all queries
-	log.Info("Running initial query execution")
-	for _, query := range cfg.Queries {
-		if !query.Enabled {
-			log.Info("Skipping disabled query", "query_id", query.ID)
-			continue
+	// Start metrics server for self-instrumentation
+	var metricsServer *http.Server
+	if appMetrics != nil {
+		metricsServer, err = appMetrics.StartServer(cfg.App.MetricsPort, log)
+		if err != nil {
+			return fmt.Errorf("failed to start metrics server: %w", err)
 		}
+	}
 
-		log.Info("Executing query",
-			"query_id", query.ID,
-			"name", query.Name,
-			"query", query.Query)
+	// Start the cron scheduler
+	sched.Start()
+
+	// Periodically reload query configurations; background loops stop on shutdown
+	loopCtx, stopLoops := context.WithCancel(ctx)
+	defer stopLoops()
+	if reloadInterval, _ := time.ParseDuration(cfg.App.ReloadInterval); reloadInterval > 0 {
+		go sched.RunReloadLoop(loopCtx, reloadInterval)
+		log.Info("Query configuration reload enabled", "interval", reloadInterval)
+	}
 
-		// Create a timeout context for each query
-		queryCtx, queryCancel := context.WithTimeout(context.Background(), 60*time.Second)
+	// Apply retention policies on the maintenance schedule
+	if maintenanceRunner != nil {
+		go maintenanceRunner.RunLoop(loopCtx, maintenanceSchedule)
+		log.Info("Maintenance enabled", "schedule", cfg.Maintenance.Schedule)
+	}
 
-		if err := exec.ExecuteQuery(queryCtx, &query); err != nil {
-			log.Error("Query execution failed",
+	// Run initial execution of all queries on the worker pool; submitting waits for
+	// room in the queue, so queries outnumbering the workers and queue are not dropped
+	log.Info("Running initial query execution")
+	startTime := time.Now()
+	go func() {
+		for _, query := range sched.Queries() {
+			log.Info("Submitting query",
 				"query_id", query.ID,
-				"error", err)
-			log.Warn("Initial query execution failed", "error", err)
-		} else {
-			log.Info("Query executed successfully", "query_id", query.ID)
-		}
+				"name", query.Name,
+				"query", query.Query)
 
-		queryCancel()
-	}
+			if !pool.SubmitWait(loopCtx, query, startTime) {
+				log.Warn("Initial query execution skipped", "query_id", query.ID)
+			}
+		}
+	}()
 
-	// Setup signal handling for graceful shutdown
+	// Setup signal handling for reload and graceful shutdown
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	// Wait for shutdown signal
-	sig := <-sigChan
+	// Wait for shutdown signal, reloading on SIGHUP
+	var sig os.Signal
+	for sig = range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		log.Info("Received reload signal", "signal", sig)
+		sched.ReloadAndLog("signal")
+	}
 	log.Info("Received shutdown signal", "signal", sig)
+	stopLoops()
 
 	// Graceful shutdown
 	log.Info("Shutting down cron scheduler...")
-	shutdownCtx := c.Stop()
+	shutdownCtx := sched.Stop()
+
+	<-shutdownCtx.Done()
+
+	// Wait for running executions to complete (with timeout)
+	log.Info("Waiting for running queries to complete...")
+	poolCtx, poolCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer poolCancel()
+	if err := pool.Stop(poolCtx); err != nil {
+		log.Warn("Timeout waiting for queries to complete, cancelled remaining executions", "error", err)
+	} else {
+		log.Info("All running queries completed")
+	}
 
-	// Wait for running jobs to complete (with timeout)
-	select {
-	case <-shutdownCtx.Done():
-		log.Info("All scheduled jobs completed")
-	case <-time.After(30 * time.Second):
-		log.Warn("Timeout waiting for jobs to complete")
+	// Stop HTTP server
+	httpCtx, httpCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer httpCancel()
+	if err := httpServer.Shutdown(httpCtx); err != nil {
+		log.Error("Failed to shutdown HTTP server", "error", err)
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(httpCtx); err != nil {
+			log.Error("Failed to shutdown metrics server", "error", err)
+		}
 	}
 
 	log.Info("Service shutdown completed")
@@ -213,17 +442,45 @@ func printConfig(cfg *models.Config) {
 	fmt.Println("=== Configuration ===")
 	fmt.Printf("Prometheus URL: %s\n", cfg.Prometheus.URL)
 	fmt.Printf("Prometheus Timeout: %s\n", cfg.Prometheus.Timeout)
+	fmt.Printf("Prometheus Auth: %s\n", cfg.Prometheus.Auth.Type)
+	// Only the header names, their values may be secrets
+	fmt.Printf("Prometheus Headers: %s\n", strings.Join(sortedKeys(cfg.Prometheus.Headers), ", "))
+	fmt.Printf("Prometheus TLS Skip Verify: %t\n", cfg.Prometheus.TLS.InsecureSkipVerify)
 	fmt.Printf("MySQL Host: %s:%d\n", cfg.MySQL.Host, cfg.MySQL.Port)
 	fmt.Printf("MySQL Database: %s\n", cfg.MySQL.Database)
 	fmt.Printf("MySQL Username: %s\n", cfg.MySQL.Username)
 	fmt.Printf("MySQL Password: %s\n", maskPassword(cfg.MySQL.Password))
+	fmt.Printf("Sinks: %s\n", strings.Join(cfg.Sink.Types, ", "))
 	fmt.Printf("Log Level: %s\n", cfg.App.LogLevel)
 	fmt.Printf("HTTP Port: %d\n", cfg.App.HTTPPort)
 	fmt.Printf("Worker Pool: %d\n", cfg.App.WorkerPool)
+	fmt.Printf("Worker Queue: %d\n", cfg.App.WorkerQueue)
+	fmt.Printf("Overlap Policy: %s\n", cfg.App.OverlapPolicy)
+	fmt.Printf("Metrics Enabled: %t\n", cfg.App.MetricsEnabled)
+	fmt.Printf("Metrics Port: %d\n", cfg.App.MetricsPort)
+	fmt.Printf("Reload Interval: %s\n", cfg.App.ReloadInterval)
+	fmt.Printf("Default Query Timeout: %s\n", cfg.App.DefaultQueryTimeout)
+	fmt.Printf("Auto Migrate: %t\n", cfg.App.AutoMigrate)
+	fmt.Printf("Maintenance Schedule: %s\n", cfg.Maintenance.Schedule)
+	fmt.Printf("Default Retention: %s\n", cfg.Maintenance.DefaultRetention)
+	fmt.Printf("Partition Interval: %s\n", cfg.Maintenance.PartitionInterval)
+	fmt.Printf("Partition Retention: %s\n", cfg.Maintenance.PartitionRetention)
+	fmt.Printf("Query Config Dir: %s\n", cfg.QueryFiles.Dir)
+	fmt.Printf("Query Sync On Startup: %t\n", cfg.QueryFiles.SyncOnStartup)
 	fmt.Printf("Queries Count: %d\n", len(cfg.Queries))
 	fmt.Println("=====================")
 }
 
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // maskPassword masks the password for logging
 func maskPassword(password string) string {
 	if len(password) <= 2 {
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/samzong/prom-etl-db/internal/config"
+)
+
+// runMaintain applies the retention policies once, like a scheduled maintenance run
+func runMaintain(args []string) {
+	fs := flag.NewFlagSet("maintain", flag.ExitOnError)
+	queryID := fs.String("query-id", "", "only maintain this query (default: all queries)")
+	_ = fs.Parse(args)
+
+	cfg, log, db := bootstrap()
+	defer closeDB(db, log)
+
+	runner, err := newMaintenanceRunner(cfg, db, log)
+	if err != nil {
+		log.Error("Failed to create maintenance runner", "error", err)
+		os.Exit(1)
+	}
+
+	// Stop between batches on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *queryID == "" {
+		if err := runner.Run(ctx); err != nil {
+			log.Error("Maintenance failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	query, err := config.LoadQueryFromDB(db.GetConn(), *queryID)
+	if err != nil {
+		log.Error("Failed to load query", "query_id", *queryID, "error", err)
+		os.Exit(1)
+	}
+
+	policy, err := runner.Policy(*query)
+	if err != nil {
+		log.Error("Invalid retention", "query_id", query.ID, "error", err)
+		os.Exit(1)
+	}
+
+	if _, err := runner.RunQuery(ctx, query.ID, policy); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/samzong/prom-etl-db/internal/database"
+)
+
+// runMigrate applies, reverts or lists the embedded schema migrations
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		migrateUsage()
+		os.Exit(2)
+	}
+	action, args := args[0], args[1:]
+
+	switch action {
+	case "up":
+		fs := flag.NewFlagSet("migrate up", flag.ExitOnError)
+		to := fs.Int("to", 0, "only apply migrations up to this version (default: all)")
+		_ = fs.Parse(args)
+
+		_, log, db := bootstrap()
+		defer closeDB(db, log)
+
+		if err := migrateUp(db, *to, log); err != nil {
+			log.Error("Migration failed", "error", err)
+			os.Exit(1)
+		}
+
+	case "down":
+		fs := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		steps := fs.Int("steps", 1, "number of migrations to revert")
+		_ = fs.Parse(args)
+		if *steps <= 0 {
+			fmt.Fprintln(os.Stderr, "--steps must be positive")
+			os.Exit(2)
+		}
+
+		_, log, db := bootstrap()
+		defer closeDB(db, log)
+
+		reverted, err := db.MigrateDown(context.Background(), *steps)
+		for _, migration := range reverted {
+			log.Info("Migration reverted", "migration", migration.String())
+		}
+		if err != nil {
+			log.Error("Migration failed", "error", err)
+			os.Exit(1)
+		}
+		if len(reverted) == 0 {
+			log.Info("No applied migrations to revert")
+		}
+
+	case "status":
+		_, log, db := bootstrap()
+		defer closeDB(db, log)
+
+		statuses, err := db.MigrationStatuses(context.Background())
+		if err != nil {
+			log.Error("Failed to get migration status", "error", err)
+			os.Exit(1)
+		}
+		printMigrationStatuses(statuses)
+
+	case "baseline":
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: prom-etl-db migrate baseline VERSION")
+			os.Exit(2)
+		}
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid version %q\n", args[0])
+			os.Exit(2)
+		}
+
+		_, log, db := bootstrap()
+		defer closeDB(db, log)
+
+		recorded, err := db.BaselineMigrations(context.Background(), version)
+		if err != nil {
+			log.Error("Baseline failed", "error", err)
+			os.Exit(1)
+		}
+		log.Info("Migrations recorded as applied", "version", version, "recorded", len(recorded))
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown migrate command: %s\n\n", action)
+		migrateUsage()
+		os.Exit(2)
+	}
+}
+
+// migrateUsage prints the migrate subcommands
+func migrateUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: prom-etl-db migrate <command> [flags]
+
+Commands:
+  up          Apply pending migrations
+  down        Revert the latest migrations
+  status      List migrations and when they were applied
+  baseline    Record migrations up to VERSION as applied without running them
+`)
+}
+
+// migrateUp applies the pending migrations up to target, all of them when it is 0
+func migrateUp(db *database.DB, target int, log *slog.Logger) error {
+	applied, err := db.MigrateUp(context.Background(), target)
+	for _, migration := range applied {
+		log.Info("Migration applied", "migration", migration.String())
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		log.Info("Database schema is up to date")
+	}
+	return nil
+}
+
+// printMigrationStatuses prints the migrations as a table
+func printMigrationStatuses(statuses []database.MigrationStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+	w.Flush()
+}
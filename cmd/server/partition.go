@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/samzong/prom-etl-db/internal/maintenance"
+)
+
+// runPartition converts metrics_data to a table partitioned by PARTITION_INTERVAL
+func runPartition(args []string) {
+	fs := flag.NewFlagSet("partition", flag.ExitOnError)
+	batchSize := fs.Int("batch-size", 10000, "rows copied per statement")
+	dryRun := fs.Bool("dry-run", false, "only report the partitions that would be created")
+	_ = fs.Parse(args)
+
+	cfg, log, db := bootstrap()
+	defer closeDB(db, log)
+
+	if cfg.Maintenance.PartitionInterval == "" {
+		fmt.Fprintln(os.Stderr, "partition requires PARTITION_INTERVAL (day or month)")
+		os.Exit(2)
+	}
+	if *batchSize <= 0 {
+		fmt.Fprintln(os.Stderr, "--batch-size must be positive")
+		os.Exit(2)
+	}
+
+	manager, err := maintenance.NewPartitionManager(db, cfg.Maintenance, log)
+	if err != nil {
+		log.Error("Failed to create partition manager", "error", err)
+		os.Exit(1)
+	}
+
+	// Stop between batches on interrupt; rerunning the command continues the copy
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := manager.Convert(ctx, *batchSize, *dryRun); err != nil {
+		log.Error("Partition conversion failed", "error", err)
+		os.Exit(1)
+	}
+}
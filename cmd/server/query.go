@@ -0,0 +1,466 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/samzong/prom-etl-db/internal/config"
+	"github.com/samzong/prom-etl-db/internal/database"
+	"github.com/samzong/prom-etl-db/internal/models"
+	"github.com/samzong/prom-etl-db/internal/querydef"
+)
+
+// Output formats of the query subcommands
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// runQuery manages the query configurations stored in query_configs
+func runQuery(args []string) {
+	if len(args) == 0 {
+		queryUsage()
+		os.Exit(2)
+	}
+	action, args := args[0], args[1:]
+
+	switch action {
+	case "list":
+		queryList(args)
+	case "get":
+		queryGet(args)
+	case "add":
+		querySave(args, false)
+	case "update":
+		querySave(args, true)
+	case "delete":
+		queryDelete(args)
+	case "enable":
+		queryToggle(args, true)
+	case "disable":
+		queryToggle(args, false)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown query command: %s\n\n", action)
+		queryUsage()
+		os.Exit(2)
+	}
+}
+
+// queryUsage prints the query subcommands
+func queryUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: prom-etl-db query <command> [ID] [flags]
+
+Commands:
+  list        List all queries, disabled ones included
+  get         Show a query
+  add         Add a query
+  update      Change fields of a query; unset flags keep their value
+  delete      Delete a query; its collected data is kept
+  enable      Enable a query
+  disable     Disable a query
+
+The service picks up changes on its next reload (CONFIG_RELOAD_INTERVAL or SIGHUP).
+Run "prom-etl-db query <command> -h" for command flags.
+`)
+}
+
+// queryList prints all query configurations
+func queryList(args []string) {
+	fs := flag.NewFlagSet("query list", flag.ExitOnError)
+	output := outputFlag(fs)
+	_ = fs.Parse(args)
+	checkOutput(*output)
+
+	_, db := openQueryDB()
+	defer db.Close()
+
+	queries, invalid, err := config.LoadAllQueriesFromDB(db.GetConn())
+	if err != nil {
+		fatalf("%v", err)
+	}
+	for _, id := range sortedKeys(invalid) {
+		fmt.Fprintf(os.Stderr, "Skipping invalid query %s: %v\n", id, invalid[id])
+	}
+	if queries == nil {
+		queries = []models.QueryConfig{}
+	}
+
+	if *output == outputJSON {
+		printJSON(queries)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tENABLED\tSCHEDULE\tDATASOURCE\tTIME RANGE\tUPDATED AT")
+	for _, query := range queries {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\t%s\t%s\n",
+			query.ID,
+			query.Name,
+			query.Enabled,
+			query.Schedule,
+			valueOrDash(query.Datasource),
+			timeRangeType(query.TimeRange),
+			query.UpdatedAt.Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+}
+
+// queryGet prints one query configuration
+func queryGet(args []string) {
+	fs := flag.NewFlagSet("query get", flag.ExitOnError)
+	output := outputFlag(fs)
+	id := parseWithID(fs, args)
+	checkOutput(*output)
+
+	_, db := openQueryDB()
+	defer db.Close()
+
+	query, err := config.LoadQueryFromDB(db.GetConn(), id)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	if *output == outputJSON {
+		printJSON(query)
+		return
+	}
+	printQuery(query)
+}
+
+// queryFlags holds the flags setting the fields of a query
+type queryFlags struct {
+	file           string
+	name           string
+	description    string
+	query          string
+	datasource     string
+	schedule       string
+	timeout        string
+	enabled        bool
+	retryCount     int
+	retryInterval  string
+	timeRangeType  string
+	time           string
+	start          string
+	end            string
+	step           string
+	promotedLabels string
+}
+
+// register defines the query flags on fs, with the defaults of a new query
+func (f *queryFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.file, "file", "", "read the query from a JSON file (- for stdin); flags override its fields")
+	fs.StringVar(&f.name, "name", "", "display name")
+	fs.StringVar(&f.description, "description", "", "description")
+	fs.StringVar(&f.query, "query", "", "PromQL expression")
+	fs.StringVar(&f.datasource, "datasource", "", "datasource name, comma separated names or * (default: PROMETHEUS_URL)")
+	fs.StringVar(&f.schedule, "schedule", "", "cron expression with seconds, e.g. \"0 0 1 * * *\"")
+	fs.StringVar(&f.timeout, "timeout", "30s", "query timeout")
+	fs.BoolVar(&f.enabled, "enabled", true, "whether the query is scheduled")
+	fs.IntVar(&f.retryCount, "retry-count", 3, "retries of a failed query")
+	fs.StringVar(&f.retryInterval, "retry-interval", "10s", "wait between retries")
+	fs.StringVar(&f.timeRangeType, "time-range-type", "", "instant or range; empty queries at the evaluation time")
+	fs.StringVar(&f.time, "time", "", "evaluation time of instant queries, e.g. yesterday_end")
+	fs.StringVar(&f.start, "start", "", "start of range queries, e.g. yesterday")
+	fs.StringVar(&f.end, "end", "", "end of range queries, e.g. yesterday_end")
+	fs.StringVar(&f.step, "step", "", "step of range queries, e.g. 1h")
+	fs.StringVar(&f.promotedLabels, "promoted-labels", "", "comma separated labels stored as indexed columns")
+}
+
+// apply sets the fields of query whose flags were given on the command line
+func (f *queryFlags) apply(fs *flag.FlagSet, query *models.QueryConfig) {
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "name":
+			query.Name = f.name
+		case "description":
+			query.Description = f.description
+		case "query":
+			query.Query = f.query
+		case "datasource":
+			query.Datasource = f.datasource
+		case "schedule":
+			query.Schedule = f.schedule
+		case "timeout":
+			query.Timeout = f.timeout
+		case "enabled":
+			query.Enabled = f.enabled
+		case "retry-count":
+			query.RetryCount = f.retryCount
+		case "retry-interval":
+			query.RetryInterval = f.retryInterval
+		case "promoted-labels":
+			query.PromotedLabels = config.SplitList(f.promotedLabels)
+		case "time-range-type", "time", "start", "end", "step":
+			if query.TimeRange == nil {
+				query.TimeRange = &models.TimeRangeConfig{}
+			} else {
+				timeRange := *query.TimeRange
+				query.TimeRange = &timeRange
+			}
+			switch fl.Name {
+			case "time-range-type":
+				query.TimeRange.Type = f.timeRangeType
+			case "time":
+				query.TimeRange.Time = f.time
+			case "start":
+				query.TimeRange.Start = f.start
+			case "end":
+				query.TimeRange.End = f.end
+			case "step":
+				query.TimeRange.Step = f.step
+			}
+		}
+	})
+
+	// An empty type removes the time range
+	if query.TimeRange != nil && query.TimeRange.Type == "" {
+		query.TimeRange = nil
+	}
+}
+
+// querySave adds a query, or updates an existing one
+func querySave(args []string, update bool) {
+	name := "query add"
+	if update {
+		name = "query update"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var flags queryFlags
+	flags.register(fs)
+	id := parseWithID(fs, args)
+
+	_, db := openQueryDB()
+	defer db.Close()
+
+	existing, err := config.LoadQueryFromDB(db.GetConn(), id)
+	if err != nil && !errors.Is(err, config.ErrQueryNotFound) {
+		fatalf("%v", err)
+	}
+	if update && existing == nil {
+		fatalf("%v", err)
+	}
+	if !update && existing != nil {
+		fatalf("query %s already exists; use query update", id)
+	}
+
+	// New queries start from the flag defaults, updates from the stored query
+	query := models.QueryConfig{
+		ID:            id,
+		Timeout:       flags.timeout,
+		Enabled:       flags.enabled,
+		RetryCount:    flags.retryCount,
+		RetryInterval: flags.retryInterval,
+	}
+	if update {
+		query = *existing
+	}
+
+	if flags.file != "" {
+		if err := readQueryFile(flags.file, &query); err != nil {
+			fatalf("%v", err)
+		}
+		if query.ID != id {
+			fatalf("file is for query %s, not %s", query.ID, id)
+		}
+	}
+	flags.apply(fs, &query)
+
+	if err := querydef.ValidateQuery(query); err != nil {
+		fatalf("invalid query %s: %v", id, err)
+	}
+	if err := config.SaveQueryToDB(db.GetConn(), query); err != nil {
+		fatalf("%v", err)
+	}
+
+	if update {
+		fmt.Printf("Query %s updated\n", id)
+	} else {
+		fmt.Printf("Query %s added\n", id)
+	}
+}
+
+// queryDelete deletes a query configuration
+func queryDelete(args []string) {
+	fs := flag.NewFlagSet("query delete", flag.ExitOnError)
+	id := parseWithID(fs, args)
+
+	_, db := openQueryDB()
+	defer db.Close()
+
+	if err := config.DeleteQueryFromDB(db.GetConn(), id); err != nil {
+		fatalf("%v", err)
+	}
+	fmt.Printf("Query %s deleted\n", id)
+}
+
+// queryToggle enables or disables a query configuration
+func queryToggle(args []string, enabled bool) {
+	name, state := "query disable", "disabled"
+	if enabled {
+		name, state = "query enable", "enabled"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	id := parseWithID(fs, args)
+
+	_, db := openQueryDB()
+	defer db.Close()
+
+	// An update that changes nothing may report no affected row, so check the state first
+	query, err := config.LoadQueryFromDB(db.GetConn(), id)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if query.Enabled == enabled {
+		fmt.Printf("Query %s is already %s\n", id, state)
+		return
+	}
+
+	if err := config.ToggleQueryEnabled(db.GetConn(), id, enabled); err != nil {
+		fatalf("%v", err)
+	}
+	fmt.Printf("Query %s %s\n", id, state)
+}
+
+// openQueryDB loads the configuration and connects to MySQL. Unlike bootstrap it
+// does not log, so command output stays readable and parseable.
+func openQueryDB() (*models.Config, *database.DB) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fatalf("failed to load configuration: %v", err)
+	}
+
+	db, err := connectDB(cfg)
+	if err != nil {
+		fatalf("failed to connect to database: %v", err)
+	}
+	return cfg, db
+}
+
+// parseWithID parses the flags of a command taking a query ID, which may come
+// before or after the flags, and exits when it is missing
+func parseWithID(fs *flag.FlagSet, args []string) string {
+	var id string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		id, args = args[0], args[1:]
+	}
+	_ = fs.Parse(args)
+
+	if id == "" {
+		id = fs.Arg(0)
+	} else if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected argument: %s\n", fs.Arg(0))
+		os.Exit(2)
+	}
+	if id == "" {
+		fmt.Fprintf(os.Stderr, "Usage: prom-etl-db %s ID [flags]\n", fs.Name())
+		os.Exit(2)
+	}
+	return id
+}
+
+// readQueryFile decodes a JSON query configuration from path, or stdin for -, into query
+func readQueryFile(path string, query *models.QueryConfig) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open query file: %w", err)
+		}
+		defer file.Close()
+		r = file
+	}
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(query); err != nil {
+		return fmt.Errorf("failed to parse query file: %w", err)
+	}
+	return nil
+}
+
+// printQuery prints the fields of a query, one per line
+func printQuery(query *models.QueryConfig) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%s\n", query.ID)
+	fmt.Fprintf(w, "Name:\t%s\n", query.Name)
+	fmt.Fprintf(w, "Description:\t%s\n", valueOrDash(query.Description))
+	fmt.Fprintf(w, "Query:\t%s\n", query.Query)
+	fmt.Fprintf(w, "Datasource:\t%s\n", valueOrDash(query.Datasource))
+	fmt.Fprintf(w, "Schedule:\t%s\n", query.Schedule)
+	fmt.Fprintf(w, "Timeout:\t%s\n", valueOrDash(query.Timeout))
+	fmt.Fprintf(w, "Enabled:\t%t\n", query.Enabled)
+	fmt.Fprintf(w, "Retries:\t%d every %s\n", query.RetryCount, valueOrDash(query.RetryInterval))
+	if query.TimeRange != nil {
+		fmt.Fprintf(w, "Time Range:\t%s\n", query.TimeRange.Type)
+		if query.TimeRange.Type == "range" {
+			fmt.Fprintf(w, "  Start / End / Step:\t%s / %s / %s\n", query.TimeRange.Start, query.TimeRange.End, query.TimeRange.Step)
+		} else {
+			fmt.Fprintf(w, "  Time:\t%s\n", valueOrDash(query.TimeRange.Time))
+		}
+	} else {
+		fmt.Fprintf(w, "Time Range:\t-\n")
+	}
+	fmt.Fprintf(w, "Relabel Rules:\t%d\n", len(query.RelabelConfigs))
+	fmt.Fprintf(w, "Promoted Labels:\t%s\n", valueOrDash(strings.Join(query.PromotedLabels, ", ")))
+	if query.Retention != nil {
+		fmt.Fprintf(w, "Retention:\traw %s, downsample %s, rollup %s\n",
+			valueOrDash(query.Retention.Raw),
+			valueOrDash(strings.Join(query.Retention.Downsample, ", ")),
+			valueOrDash(query.Retention.Rollup))
+	} else {
+		fmt.Fprintf(w, "Retention:\t-\n")
+	}
+	fmt.Fprintf(w, "Updated At:\t%s\n", query.UpdatedAt.Format("2006-01-02 15:04:05"))
+	w.Flush()
+}
+
+// outputFlag defines the --output flag
+func outputFlag(fs *flag.FlagSet) *string {
+	return fs.String("output", outputTable, "output format: table or json")
+}
+
+// checkOutput exits when output is not a known format
+func checkOutput(output string) {
+	if output != outputTable && output != outputJSON {
+		fmt.Fprintf(os.Stderr, "--output must be %s or %s\n", outputTable, outputJSON)
+		os.Exit(2)
+	}
+}
+
+// printJSON prints v as indented JSON
+func printJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		fatalf("failed to encode output: %v", err)
+	}
+}
+
+// timeRangeType returns the time range type of a query, or - for none
+func timeRangeType(timeRange *models.TimeRangeConfig) string {
+	if timeRange == nil {
+		return "-"
+	}
+	return timeRange.Type
+}
+
+// valueOrDash returns value, or - when it is empty
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// fatalf prints an error and exits
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,224 @@
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/samzong/prom-etl-db/internal/database"
+	"github.com/samzong/prom-etl-db/internal/executor"
+	"github.com/samzong/prom-etl-db/internal/logger"
+	"github.com/samzong/prom-etl-db/internal/models"
+	"github.com/samzong/prom-etl-db/internal/scheduler"
+)
+
+// maxFireTimes bounds a single backfill to catch schedules that are too fine for the window
+const maxFireTimes = 100000
+
+// Options controls a backfill run
+type Options struct {
+	// Start and End bound the fire times, both inclusive
+	Start time.Time
+	End   time.Time
+	// Schedule overrides the query's cron expression when set
+	Schedule string
+	// Concurrency is the number of fire times executed in parallel
+	Concurrency int
+	// Resume skips fire times that already have a successful execution
+	Resume bool
+	// DryRun only lists the fire times that would be executed
+	DryRun bool
+	// ProgressInterval is how often progress is logged
+	ProgressInterval time.Duration
+}
+
+// Result summarizes a backfill run
+type Result struct {
+	Total       int           `json:"total"`
+	Skipped     int           `json:"skipped"`
+	Succeeded   int           `json:"succeeded"`
+	Failed      int           `json:"failed"`
+	FailedTimes []time.Time   `json:"failed_times,omitempty"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// Backfiller executes a query for every fire time of its schedule in a past window
+type Backfiller struct {
+	exec   *executor.Executor
+	db     *database.DB
+	logger *slog.Logger
+}
+
+// NewBackfiller creates a new backfiller
+func NewBackfiller(exec *executor.Executor, db *database.DB, baseLogger *slog.Logger) *Backfiller {
+	return &Backfiller{
+		exec:   exec,
+		db:     db,
+		logger: logger.WithComponent(baseLogger, "backfill"),
+	}
+}
+
+// FireTimes returns every activation of schedule between start and end, inclusive
+func FireTimes(schedule cron.Schedule, start, end time.Time) ([]time.Time, error) {
+	var times []time.Time
+	for next := schedule.Next(start.Add(-time.Nanosecond)); !next.IsZero() && !next.After(end); next = schedule.Next(next) {
+		if len(times) == maxFireTimes {
+			return nil, fmt.Errorf("schedule fires more than %d times in the requested window", maxFireTimes)
+		}
+		times = append(times, next)
+	}
+	return times, nil
+}
+
+// Run executes query at every fire time in the window, oldest first.
+// Failed fire times are reported in the result and do not stop the run;
+// running the same backfill again with Resume retries only what did not succeed.
+func (b *Backfiller) Run(ctx context.Context, query models.QueryConfig, opts Options) (*Result, error) {
+	if !opts.Start.Before(opts.End) {
+		return nil, fmt.Errorf("start (%s) must be before end (%s)", opts.Start.Format(time.RFC3339), opts.End.Format(time.RFC3339))
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = 10 * time.Second
+	}
+
+	spec := query.Schedule
+	if opts.Schedule != "" {
+		spec = opts.Schedule
+	}
+	schedule, err := scheduler.ParseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	fireTimes, err := FireTimes(schedule, opts.Start, opts.End)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Total: len(fireTimes)}
+	queryLogger := logger.WithQueryID(b.logger, query.ID)
+
+	if opts.Resume && len(fireTimes) > 0 {
+		succeeded, err := b.db.GetSucceededEvaluationTimes(query.ID, fireTimes[0], fireTimes[len(fireTimes)-1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to load completed fire times: %w", err)
+		}
+		pending := fireTimes[:0]
+		for _, t := range fireTimes {
+			if succeeded[t.UnixMilli()] {
+				result.Skipped++
+				continue
+			}
+			pending = append(pending, t)
+		}
+		fireTimes = pending
+	}
+
+	queryLogger.Info("Starting backfill",
+		"schedule", spec,
+		"start", opts.Start.Format(time.RFC3339),
+		"end", opts.End.Format(time.RFC3339),
+		"fire_times", result.Total,
+		"already_completed", result.Skipped,
+		"pending", len(fireTimes),
+		"concurrency", opts.Concurrency)
+
+	if opts.DryRun {
+		for _, t := range fireTimes {
+			queryLogger.Info("Would execute", "evaluation_time", t.Format(time.RFC3339))
+		}
+		return result, nil
+	}
+
+	startTime := time.Now()
+	var succeeded, failed atomic.Int64
+	var mu sync.Mutex
+
+	work := make(chan time.Time)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range work {
+				q := query
+				if err := b.exec.ExecuteQueryWithRetry(ctx, &q, t); err != nil {
+					failed.Add(1)
+					mu.Lock()
+					result.FailedTimes = append(result.FailedTimes, t)
+					mu.Unlock()
+					logger.WithError(queryLogger, err).Warn("Backfill fire time failed", "evaluation_time", t.Format(time.RFC3339))
+					continue
+				}
+				succeeded.Add(1)
+			}
+		}()
+	}
+
+	// Report progress until all workers are done
+	progressDone := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(opts.ProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-progressDone:
+				return
+			case <-ticker.C:
+				b.logProgress(queryLogger, len(fireTimes), int(succeeded.Load()), int(failed.Load()), time.Since(startTime))
+			}
+		}
+	}()
+
+feed:
+	for _, t := range fireTimes {
+		select {
+		case <-ctx.Done():
+			break feed
+		case work <- t:
+		}
+	}
+	close(work)
+	wg.Wait()
+	close(progressDone)
+
+	result.Succeeded = int(succeeded.Load())
+	result.Failed = int(failed.Load())
+	result.Duration = time.Since(startTime)
+	sort.Slice(result.FailedTimes, func(i, j int) bool { return result.FailedTimes[i].Before(result.FailedTimes[j]) })
+
+	b.logProgress(queryLogger, len(fireTimes), result.Succeeded, result.Failed, result.Duration)
+
+	if err := ctx.Err(); err != nil {
+		return result, fmt.Errorf("backfill interrupted: %w", err)
+	}
+	return result, nil
+}
+
+// logProgress logs completed, failed and remaining fire times with an ETA
+func (b *Backfiller) logProgress(log *slog.Logger, total, succeeded, failed int, elapsed time.Duration) {
+	done := succeeded + failed
+	attrs := []any{
+		"done", done,
+		"total", total,
+		"succeeded", succeeded,
+		"failed", failed,
+		"elapsed", elapsed.Round(time.Second).String(),
+	}
+	if total > 0 {
+		attrs = append(attrs, "percent", fmt.Sprintf("%.1f", float64(done)*100/float64(total)))
+	}
+	if done > 0 && done < total {
+		eta := time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+		attrs = append(attrs, "eta", eta.Round(time.Second).String())
+	}
+	log.Info("Backfill progress", attrs...)
+}
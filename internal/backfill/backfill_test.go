@@ -0,0 +1,89 @@
+package backfill
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/scheduler"
+)
+
+func TestFireTimes(t *testing.T) {
+	day := func(d, hour int) time.Time {
+		return time.Date(2024, 3, d, hour, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		schedule   string
+		start, end time.Time
+		want       []time.Time
+	}{
+		{
+			name:     "start and end are inclusive",
+			schedule: "0 0 1 * * *",
+			start:    day(1, 1),
+			end:      day(3, 1),
+			want:     []time.Time{day(1, 1), day(2, 1), day(3, 1)},
+		},
+		{
+			name:     "activations outside the window are left out",
+			schedule: "0 0 1 * * *",
+			start:    day(1, 2),
+			end:      day(3, 0),
+			want:     []time.Time{day(2, 1)},
+		},
+		{
+			name:     "sub-second start",
+			schedule: "0 0 1 * * *",
+			start:    day(1, 1).Add(time.Millisecond),
+			end:      day(2, 1).Add(-time.Millisecond),
+			want:     nil,
+		},
+		{
+			name:     "hourly",
+			schedule: "0 0 * * * *",
+			start:    day(1, 22),
+			end:      day(2, 1),
+			want:     []time.Time{day(1, 22), day(1, 23), day(2, 0), day(2, 1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := scheduler.ParseSchedule(tt.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := FireTimes(schedule, tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("FireTimes: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("fire time %d = %s, want %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFireTimesLimit(t *testing.T) {
+	schedule, err := scheduler.ParseSchedule("* * * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// maxFireTimes activations fit, one more does not
+	end := start.Add((maxFireTimes - 1) * time.Second)
+	times, err := FireTimes(schedule, start, end)
+	if err != nil || len(times) != maxFireTimes {
+		t.Errorf("got %d fire times and %v, want %d", len(times), err, maxFireTimes)
+	}
+	if _, err := FireTimes(schedule, start, end.Add(time.Second)); err == nil {
+		t.Errorf("window of %d activations accepted", maxFireTimes+1)
+	}
+}
@@ -6,8 +6,12 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/samzong/prom-etl-db/internal/database"
+	"github.com/samzong/prom-etl-db/internal/maintenance"
 	"github.com/samzong/prom-etl-db/internal/models"
+	"github.com/samzong/prom-etl-db/internal/relabel"
 )
 
 // LoadConfig loads configuration from environment variables only (no queries)
@@ -34,8 +38,8 @@ func LoadConfigWithDB(db *sql.DB) (*models.Config, error) {
 		return nil, fmt.Errorf("failed to load config from env: %w", err)
 	}
 
-	// Load queries from database
-	queries, err := LoadQueriesFromDB(db)
+	// Load queries from database; broken rows are skipped here and reported by the scheduler
+	queries, _, err := LoadQueriesFromDB(db)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load queries from database: %w", err)
 	}
@@ -54,6 +58,23 @@ func loadFromEnv(config *models.Config) error {
 	// Prometheus configuration
 	config.Prometheus.URL = getEnvOrDefault("PROMETHEUS_URL", "http://localhost:9090")
 	config.Prometheus.Timeout = getEnvOrDefault("PROMETHEUS_TIMEOUT", "30s")
+	config.Prometheus.Auth.Type = getEnvOrDefault("PROMETHEUS_AUTH_TYPE", "none")
+	config.Prometheus.Auth.Username = os.Getenv("PROMETHEUS_USERNAME")
+	config.Prometheus.Auth.Password = os.Getenv("PROMETHEUS_PASSWORD")
+	config.Prometheus.Auth.PasswordFile = os.Getenv("PROMETHEUS_PASSWORD_FILE")
+	config.Prometheus.Auth.Token = os.Getenv("PROMETHEUS_TOKEN")
+	config.Prometheus.Auth.TokenFile = os.Getenv("PROMETHEUS_TOKEN_FILE")
+	config.Prometheus.TLS.CAFile = os.Getenv("PROMETHEUS_TLS_CA_FILE")
+	config.Prometheus.TLS.CertFile = os.Getenv("PROMETHEUS_TLS_CERT_FILE")
+	config.Prometheus.TLS.KeyFile = os.Getenv("PROMETHEUS_TLS_KEY_FILE")
+	config.Prometheus.TLS.ServerName = os.Getenv("PROMETHEUS_TLS_SERVER_NAME")
+	config.Prometheus.TLS.InsecureSkipVerify = getEnvBoolOrDefault("PROMETHEUS_TLS_SKIP_VERIFY", false)
+
+	headers, err := ParseHeaders(os.Getenv("PROMETHEUS_HEADERS"))
+	if err != nil {
+		return fmt.Errorf("invalid PROMETHEUS_HEADERS: %w", err)
+	}
+	config.Prometheus.Headers = headers
 
 	// MySQL configuration
 	config.MySQL.Host = getEnvOrDefault("MYSQL_HOST", "localhost")
@@ -63,41 +84,70 @@ func loadFromEnv(config *models.Config) error {
 	config.MySQL.Password = getEnvOrDefault("MYSQL_PASSWORD", "password")
 	config.MySQL.Charset = getEnvOrDefault("MYSQL_CHARSET", "utf8mb4")
 
+	// Sink configuration
+	config.Sink.Types = SplitList(getEnvOrDefault("SINKS", "mysql"))
+	config.Sink.Postgres.DSN = os.Getenv("POSTGRES_DSN")
+	config.Sink.Postgres.Timescale = getEnvBoolOrDefault("POSTGRES_TIMESCALE", false)
+	config.Sink.Postgres.ChunkInterval = getEnvOrDefault("POSTGRES_CHUNK_INTERVAL", "1 day")
+	config.Sink.ClickHouse.Addr = SplitList(getEnvOrDefault("CLICKHOUSE_ADDR", "localhost:9000"))
+	config.Sink.ClickHouse.Database = getEnvOrDefault("CLICKHOUSE_DATABASE", "default")
+	config.Sink.ClickHouse.Username = getEnvOrDefault("CLICKHOUSE_USERNAME", "default")
+	config.Sink.ClickHouse.Password = os.Getenv("CLICKHOUSE_PASSWORD")
+	config.Sink.ClickHouse.BatchSize = getEnvIntOrDefault("CLICKHOUSE_BATCH_SIZE", 100000)
+	config.Sink.File.Dir = getEnvOrDefault("FILE_SINK_DIR", "./data/export")
+	config.Sink.File.Format = getEnvOrDefault("FILE_SINK_FORMAT", "parquet")
+	config.Sink.File.Labels = getEnvOrDefault("FILE_SINK_LABELS", "map")
+	config.Sink.S3.Endpoint = os.Getenv("S3_ENDPOINT")
+	config.Sink.S3.Bucket = os.Getenv("S3_BUCKET")
+	config.Sink.S3.Prefix = os.Getenv("S3_PREFIX")
+	config.Sink.S3.Region = os.Getenv("S3_REGION")
+	config.Sink.S3.AccessKey = os.Getenv("S3_ACCESS_KEY")
+	config.Sink.S3.SecretKey = os.Getenv("S3_SECRET_KEY")
+	config.Sink.S3.UseSSL = getEnvBoolOrDefault("S3_USE_SSL", true)
+	config.Sink.S3.PathStyle = getEnvBoolOrDefault("S3_PATH_STYLE", false)
+	config.Sink.S3.Format = getEnvOrDefault("S3_FORMAT", "parquet")
+	config.Sink.S3.Labels = getEnvOrDefault("S3_LABELS", "map")
+	config.Sink.S3.PartSize = int64(getEnvIntOrDefault("S3_PART_SIZE_MB", 16)) << 20
+	config.Sink.S3.MaxRetries = getEnvIntOrDefault("S3_MAX_RETRIES", 3)
+	config.Sink.S3.MaxRowsPerFile = getEnvIntOrDefault("S3_MAX_ROWS_PER_FILE", 0)
+
 	// App configuration
 	config.App.LogLevel = getEnvOrDefault("LOG_LEVEL", "info")
 	config.App.HTTPPort = getEnvIntOrDefault("HTTP_PORT", 8080)
 	config.App.WorkerPool = getEnvIntOrDefault("WORKER_POOL_SIZE", 10)
+	config.App.WorkerQueue = getEnvIntOrDefault("WORKER_QUEUE_SIZE", 100)
+	config.App.OverlapPolicy = getEnvOrDefault("QUERY_OVERLAP_POLICY", "skip")
+	config.App.MetricsEnabled = getEnvBoolOrDefault("METRICS_ENABLED", true)
+	config.App.MetricsPort = getEnvIntOrDefault("METRICS_PORT", 9090)
+	config.App.ReloadInterval = getEnvOrDefault("CONFIG_RELOAD_INTERVAL", "60s")
+	config.App.DefaultQueryTimeout = getEnvOrDefault("DEFAULT_QUERY_TIMEOUT", "60s")
+	config.App.AutoMigrate = getEnvBoolOrDefault("AUTO_MIGRATE", false)
+
+	// Maintenance configuration
+	config.Maintenance.Schedule = getEnvOrDefault("MAINTENANCE_SCHEDULE", "")
+	config.Maintenance.DefaultRetention = getEnvOrDefault("DEFAULT_RETENTION", "")
+	config.Maintenance.BatchSize = getEnvIntOrDefault("MAINTENANCE_BATCH_SIZE", 5000)
+	config.Maintenance.PartitionInterval = getEnvOrDefault("PARTITION_INTERVAL", "")
+	config.Maintenance.PartitionRetention = getEnvOrDefault("PARTITION_RETENTION", "")
+	config.Maintenance.PartitionPrecreate = getEnvIntOrDefault("PARTITION_PRECREATE", 3)
+
+	// Query definition files
+	config.QueryFiles.Dir = getEnvOrDefault("QUERY_CONFIG_DIR", "")
+	config.QueryFiles.SyncOnStartup = getEnvBoolOrDefault("QUERY_SYNC_ON_STARTUP", false)
+	config.QueryFiles.Prune = getEnvBoolOrDefault("QUERY_SYNC_PRUNE", false)
 
 	return nil
 }
 
-// loadQueriesFromFile loads queries configuration from YAML file (unused)
-// func loadQueriesFromFile(config *models.Config, configFile string) error {
-//	viper.SetConfigFile(configFile)
-//	viper.SetConfigType("yaml")
-//
-//	if err := viper.ReadInConfig(); err != nil {
-//		return fmt.Errorf("failed to read config file: %w", err)
-//	}
-//
-//	var fileConfig struct {
-//		Queries []models.QueryConfig `yaml:"queries"`
-//	}
-//
-//	if err := viper.Unmarshal(&fileConfig); err != nil {
-//		return fmt.Errorf("failed to unmarshal config: %w", err)
-//	}
-//
-//	config.Queries = fileConfig.Queries
-//	return nil
-// }
-
 // validateConfig validates the configuration
 func validateConfig(config *models.Config) error {
 	if config.Prometheus.URL == "" {
 		return fmt.Errorf("prometheus URL is required")
 	}
 
+	// Authentication and TLS settings are checked when the client is created,
+	// for the default endpoint and the datasources alike
+
 	if config.MySQL.Host == "" {
 		return fmt.Errorf("mysql host is required")
 	}
@@ -110,19 +160,164 @@ func validateConfig(config *models.Config) error {
 		return fmt.Errorf("mysql username is required")
 	}
 
-	// Validate queries
-	for i, query := range config.Queries {
-		if query.ID == "" {
-			return fmt.Errorf("query[%d]: ID is required", i)
+	if err := validateSinks(config.Sink); err != nil {
+		return err
+	}
+
+	if config.App.WorkerPool <= 0 {
+		return fmt.Errorf("worker pool size must be positive")
+	}
+
+	if config.App.WorkerQueue < 0 {
+		return fmt.Errorf("worker queue size must not be negative")
+	}
+
+	if config.App.OverlapPolicy != "skip" && config.App.OverlapPolicy != "queue" {
+		return fmt.Errorf("overlap policy must be skip or queue, got %q", config.App.OverlapPolicy)
+	}
+
+	if config.App.ReloadInterval != "" {
+		if _, err := time.ParseDuration(config.App.ReloadInterval); err != nil {
+			return fmt.Errorf("invalid reload interval %q: %w", config.App.ReloadInterval, err)
+		}
+	}
+
+	if timeout, err := time.ParseDuration(config.App.DefaultQueryTimeout); err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid default query timeout %q", config.App.DefaultQueryTimeout)
+	}
+
+	if config.App.MetricsEnabled && config.App.MetricsPort == config.App.HTTPPort {
+		return fmt.Errorf("metrics port must differ from HTTP port (%d)", config.App.HTTPPort)
+	}
+
+	if _, err := maintenance.ParseRetention(config.Maintenance.DefaultRetention); err != nil {
+		return fmt.Errorf("invalid default retention: %w", err)
+	}
+
+	if config.Maintenance.BatchSize <= 0 {
+		return fmt.Errorf("maintenance batch size must be positive")
+	}
+
+	if config.QueryFiles.SyncOnStartup && config.QueryFiles.Dir == "" {
+		return fmt.Errorf("query sync on startup requires QUERY_CONFIG_DIR")
+	}
+
+	// Queries are validated one by one as they are loaded and scheduled,
+	// so a broken query never keeps the others from running
+
+	return nil
+}
+
+// ValidateQueryOptions checks the relabeling rules, promoted labels and retention of a query
+func ValidateQueryOptions(query models.QueryConfig) error {
+	if _, err := relabel.Compile(query.RelabelConfigs); err != nil {
+		return err
+	}
+	for _, label := range query.PromotedLabels {
+		if _, err := database.LabelColumn(label); err != nil {
+			return err
+		}
+	}
+	if _, err := maintenance.ParsePolicy(query.Retention, 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateSinks checks the sink types and their required settings
+func validateSinks(cfg models.SinkConfig) error {
+	if len(cfg.Types) == 0 {
+		return fmt.Errorf("at least one sink is required")
+	}
+
+	seen := make(map[string]bool)
+	for _, sinkType := range cfg.Types {
+		if seen[sinkType] {
+			return fmt.Errorf("duplicate sink: %s", sinkType)
 		}
-		if query.Query == "" {
-			return fmt.Errorf("query[%d]: query is required", i)
+		seen[sinkType] = true
+
+		switch sinkType {
+		case "mysql":
+		case "postgres":
+			if cfg.Postgres.DSN == "" {
+				return fmt.Errorf("postgres sink requires POSTGRES_DSN")
+			}
+		case "clickhouse":
+			if len(cfg.ClickHouse.Addr) == 0 {
+				return fmt.Errorf("clickhouse sink requires CLICKHOUSE_ADDR")
+			}
+			if cfg.ClickHouse.BatchSize <= 0 {
+				return fmt.Errorf("clickhouse batch size must be positive")
+			}
+		case "file":
+			if cfg.File.Dir == "" {
+				return fmt.Errorf("file sink requires FILE_SINK_DIR")
+			}
+			if err := validateExport("file", cfg.File.Format, cfg.File.Labels); err != nil {
+				return err
+			}
+		case "s3":
+			if cfg.S3.Endpoint == "" || cfg.S3.Bucket == "" {
+				return fmt.Errorf("s3 sink requires S3_ENDPOINT and S3_BUCKET")
+			}
+			if err := validateExport("s3", cfg.S3.Format, cfg.S3.Labels); err != nil {
+				return err
+			}
+			// S3 rejects multipart parts smaller than 5 MiB
+			if cfg.S3.PartSize < 5<<20 {
+				return fmt.Errorf("s3 part size must be at least 5 MB")
+			}
+			if cfg.S3.MaxRetries < 0 || cfg.S3.MaxRowsPerFile < 0 {
+				return fmt.Errorf("s3 max retries and max rows per file must not be negative")
+			}
+		default:
+			return fmt.Errorf("unknown sink %q", sinkType)
 		}
 	}
+	return nil
+}
 
+// validateExport checks the format and label layout of a file exporting sink
+func validateExport(sinkType, format, labels string) error {
+	if format != "parquet" && format != "csv" {
+		return fmt.Errorf("%s sink format must be parquet or csv, got %q", sinkType, format)
+	}
+	if labels != "map" && labels != "flatten" {
+		return fmt.Errorf("%s sink labels must be map or flatten, got %q", sinkType, labels)
+	}
 	return nil
 }
 
+// SplitList splits a comma separated list, dropping empty items
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ParseHeaders parses comma separated Name=value pairs, e.g. "X-Scope-OrgID=team-a"
+func ParseHeaders(value string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, headerValue, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("expected Name=value, got %q", pair)
+		}
+		headers[name] = strings.TrimSpace(headerValue)
+	}
+	return headers, nil
+}
+
 // getEnvOrDefault returns environment variable value or default
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -141,6 +336,16 @@ func getEnvIntOrDefault(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvBoolOrDefault returns environment variable as bool or default
+func getEnvBoolOrDefault(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 // GetMySQLDSN returns MySQL DSN string
 func GetMySQLDSN(config *models.MySQLConfig) string {
 	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=true&loc=Local",
@@ -169,19 +374,3 @@ func GetDefaultQueries() []models.QueryConfig {
 		},
 	}
 }
-
-// PrintConfig prints configuration (without sensitive data)
-func PrintConfig(config *models.Config) {
-	fmt.Printf("=== Configuration ===\n")
-	fmt.Printf("Prometheus URL: %s\n", config.Prometheus.URL)
-	fmt.Printf("Prometheus Timeout: %s\n", config.Prometheus.Timeout)
-	fmt.Printf("MySQL Host: %s:%d\n", config.MySQL.Host, config.MySQL.Port)
-	fmt.Printf("MySQL Database: %s\n", config.MySQL.Database)
-	fmt.Printf("MySQL Username: %s\n", config.MySQL.Username)
-	fmt.Printf("MySQL Password: %s\n", strings.Repeat("*", len(config.MySQL.Password)))
-	fmt.Printf("Log Level: %s\n", config.App.LogLevel)
-	fmt.Printf("HTTP Port: %d\n", config.App.HTTPPort)
-	fmt.Printf("Worker Pool: %d\n", config.App.WorkerPool)
-	fmt.Printf("Queries Count: %d\n", len(config.Queries))
-	fmt.Printf("=====================\n")
-}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{value: "", want: map[string]string{}},
+		{value: "X-Scope-OrgID=team-a", want: map[string]string{"X-Scope-OrgID": "team-a"}},
+		{value: " A = 1 , B=2,", want: map[string]string{"A": "1", "B": "2"}},
+		{value: "A=", want: map[string]string{"A": ""}},
+		// Only the first = separates name and value
+		{value: "A=b=c", want: map[string]string{"A": "b=c"}},
+		{value: "A", wantErr: true},
+		{value: "=value", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseHeaders(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseHeaders(%q) = %v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseHeaders(%q): %v", tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseHeaders(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
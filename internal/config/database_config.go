@@ -2,97 +2,210 @@ package config
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 
+	"github.com/samzong/prom-etl-db/internal/database"
+	"github.com/samzong/prom-etl-db/internal/maintenance"
 	"github.com/samzong/prom-etl-db/internal/models"
+	"github.com/samzong/prom-etl-db/internal/relabel"
 )
 
-// LoadQueriesFromDB loads query configurations from the database
-func LoadQueriesFromDB(db *sql.DB) ([]models.QueryConfig, error) {
-	query := `
-		SELECT 
-			query_id, name, description, query, schedule, timeout, 
+// queryConfigColumns lists the query_configs columns read by scanQueryConfig
+const queryConfigColumns = `
+			query_id, name, description, query, datasource, schedule, timeout, 
 			enabled, retry_count, retry_interval,
-			time_range_type, time_range_time, time_range_start, time_range_end, time_range_step
+			time_range_type, time_range_time, time_range_start, time_range_end, time_range_step,
+			relabel_configs, promoted_labels, retention, updated_at`
+
+// ErrQueryNotFound is returned when no query configuration has the requested query_id
+var ErrQueryNotFound = errors.New("no configuration found with query_id")
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Execer is implemented by *sql.DB and *sql.Tx, so queries can be changed inside a transaction
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// LoadQueriesFromDB loads the enabled query configurations from the database.
+// Rows that cannot be parsed are skipped and returned in invalid by query_id.
+func LoadQueriesFromDB(db *sql.DB) (queries []models.QueryConfig, invalid map[string]error, err error) {
+	return loadQueries(db, true)
+}
+
+// LoadAllQueriesFromDB loads all query configurations, including disabled ones.
+// Rows that cannot be parsed are skipped and returned in invalid by query_id.
+func LoadAllQueriesFromDB(db *sql.DB) (queries []models.QueryConfig, invalid map[string]error, err error) {
+	return loadQueries(db, false)
+}
+
+// loadQueries loads the query configurations, optionally only the enabled ones.
+// A broken row only affects its own query, so it never blocks loading the others.
+func loadQueries(db *sql.DB, enabledOnly bool) ([]models.QueryConfig, map[string]error, error) {
+	query := `
+		SELECT ` + queryConfigColumns + `
 		FROM query_configs 
-		WHERE enabled = 1 
+		WHERE enabled = 1 OR ? = 0
 		ORDER BY created_at
 	`
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, enabledOnly)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query configurations: %w", err)
+		return nil, nil, fmt.Errorf("failed to query configurations: %w", err)
 	}
 	defer rows.Close()
 
 	var configs []models.QueryConfig
+	invalid := make(map[string]error)
 	for rows.Next() {
-		var config models.QueryConfig
-		var retryInterval string
-		var timeRangeType sql.NullString
-		var timeRangeTime sql.NullString
-		var timeRangeStart sql.NullString
-		var timeRangeEnd sql.NullString
-		var timeRangeStep sql.NullString
-
-		err := rows.Scan(
-			&config.ID,
-			&config.Name,
-			&config.Description,
-			&config.Query,
-			&config.Schedule,
-			&config.Timeout,
-			&config.Enabled,
-			&config.RetryCount,
-			&retryInterval,
-			&timeRangeType,
-			&timeRangeTime,
-			&timeRangeStart,
-			&timeRangeEnd,
-			&timeRangeStep,
-		)
+		config, err := scanQueryConfig(rows)
+		if config == nil {
+			return nil, nil, fmt.Errorf("failed to scan configuration row: %w", err)
+		}
+		if err == nil {
+			err = ValidateQueryOptions(*config)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan configuration row: %w", err)
+			invalid[config.ID] = err
+			continue
 		}
+		configs = append(configs, *config)
+	}
 
-		// Set retry interval as string
-		config.RetryInterval = retryInterval
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
 
-		// Build TimeRange configuration if any time range fields are set
-		if timeRangeType.Valid && timeRangeType.String != "" {
-			timeRange := &models.TimeRangeConfig{
-				Type: timeRangeType.String,
-			}
+	return configs, invalid, nil
+}
 
-			if timeRangeTime.Valid {
-				timeRange.Time = timeRangeTime.String
-			}
-			if timeRangeStart.Valid {
-				timeRange.Start = timeRangeStart.String
-			}
-			if timeRangeEnd.Valid {
-				timeRange.End = timeRangeEnd.String
-			}
-			if timeRangeStep.Valid {
-				timeRange.Step = timeRangeStep.String
-			}
+// LoadQueryFromDB loads a single query configuration, whether enabled or not
+func LoadQueryFromDB(db *sql.DB, queryID string) (*models.QueryConfig, error) {
+	query := `
+		SELECT ` + queryConfigColumns + `
+		FROM query_configs 
+		WHERE query_id = ?
+	`
 
-			config.TimeRange = timeRange
+	config, err := scanQueryConfig(db.QueryRow(query, queryID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", ErrQueryNotFound, queryID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+// scanQueryConfig scans a row selected with queryConfigColumns. When the query_id of
+// the row is read but another column cannot be scanned or parsed, the configuration is
+// returned with the error so the caller knows which query is broken.
+func scanQueryConfig(row rowScanner) (*models.QueryConfig, error) {
+	var config models.QueryConfig
+	var description, timeout, retryInterval sql.NullString
+	var enabled sql.NullBool
+	var retryCount sql.NullInt64
+	var datasource sql.NullString
+	var timeRangeType sql.NullString
+	var timeRangeTime sql.NullString
+	var timeRangeStart sql.NullString
+	var timeRangeEnd sql.NullString
+	var timeRangeStep sql.NullString
+	var relabelConfigs, promotedLabels, retention []byte
+
+	err := row.Scan(
+		&config.ID,
+		&config.Name,
+		&description,
+		&config.Query,
+		&datasource,
+		&config.Schedule,
+		&timeout,
+		&enabled,
+		&retryCount,
+		&retryInterval,
+		&timeRangeType,
+		&timeRangeTime,
+		&timeRangeStart,
+		&timeRangeEnd,
+		&timeRangeStep,
+		&relabelConfigs,
+		&promotedLabels,
+		&retention,
+		&config.UpdatedAt,
+	)
+	if err != nil {
+		// Columns are scanned in order, so query_id is set unless the row itself failed
+		if config.ID == "" {
+			return nil, err
+		}
+		return &config, fmt.Errorf("failed to scan query %s: %w", config.ID, err)
+	}
+
+	// NULL columns read as empty values, which the executor replaces with its defaults
+	config.Description = description.String
+	config.Timeout = timeout.String
+	config.Enabled = enabled.Bool
+	config.RetryCount = int(retryCount.Int64)
+	config.RetryInterval = retryInterval.String
+	config.Datasource = datasource.String
+
+	// Build TimeRange configuration if any time range fields are set
+	if timeRangeType.Valid && timeRangeType.String != "" {
+		timeRange := &models.TimeRangeConfig{
+			Type: timeRangeType.String,
+		}
+
+		if timeRangeTime.Valid {
+			timeRange.Time = timeRangeTime.String
+		}
+		if timeRangeStart.Valid {
+			timeRange.Start = timeRangeStart.String
+		}
+		if timeRangeEnd.Valid {
+			timeRange.End = timeRangeEnd.String
+		}
+		if timeRangeStep.Valid {
+			timeRange.Step = timeRangeStep.String
 		}
 
-		configs = append(configs, config)
+		config.TimeRange = timeRange
+		if timeRange.Type != "instant" && timeRange.Type != "range" {
+			return &config, fmt.Errorf("invalid time_range_type of query %s: %q", config.ID, timeRange.Type)
+		}
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	if len(relabelConfigs) > 0 {
+		if err := json.Unmarshal(relabelConfigs, &config.RelabelConfigs); err != nil {
+			return &config, fmt.Errorf("invalid relabel_configs of query %s: %w", config.ID, err)
+		}
+	}
+
+	if len(promotedLabels) > 0 {
+		if err := json.Unmarshal(promotedLabels, &config.PromotedLabels); err != nil {
+			return &config, fmt.Errorf("invalid promoted_labels of query %s: %w", config.ID, err)
+		}
+	}
+
+	if len(retention) > 0 {
+		if err := json.Unmarshal(retention, &config.Retention); err != nil {
+			return &config, fmt.Errorf("invalid retention of query %s: %w", config.ID, err)
+		}
 	}
 
-	return configs, nil
+	return &config, nil
 }
 
 // SaveQueryToDB saves a query configuration to the database
-func SaveQueryToDB(db *sql.DB, config models.QueryConfig) error {
+func SaveQueryToDB(db Execer, config models.QueryConfig) error {
 	var timeRangeType, timeRangeTime, timeRangeStart, timeRangeEnd, timeRangeStep sql.NullString
+	datasource := sql.NullString{String: config.Datasource, Valid: config.Datasource != ""}
 
 	if config.TimeRange != nil {
 		timeRangeType = sql.NullString{String: config.TimeRange.Type, Valid: true}
@@ -110,16 +223,56 @@ func SaveQueryToDB(db *sql.DB, config models.QueryConfig) error {
 		}
 	}
 
+	var relabelConfigs []byte
+	if len(config.RelabelConfigs) > 0 {
+		if _, err := relabel.Compile(config.RelabelConfigs); err != nil {
+			return fmt.Errorf("invalid relabel configs: %w", err)
+		}
+		var err error
+		relabelConfigs, err = json.Marshal(config.RelabelConfigs)
+		if err != nil {
+			return fmt.Errorf("failed to marshal relabel configs: %w", err)
+		}
+	}
+
+	var promotedLabels []byte
+	if len(config.PromotedLabels) > 0 {
+		for _, label := range config.PromotedLabels {
+			if _, err := database.LabelColumn(label); err != nil {
+				return err
+			}
+		}
+		var err error
+		promotedLabels, err = json.Marshal(config.PromotedLabels)
+		if err != nil {
+			return fmt.Errorf("failed to marshal promoted labels: %w", err)
+		}
+	}
+
+	var retention []byte
+	if config.Retention != nil {
+		if _, err := maintenance.ParsePolicy(config.Retention, 0); err != nil {
+			return fmt.Errorf("invalid retention: %w", err)
+		}
+		var err error
+		retention, err = json.Marshal(config.Retention)
+		if err != nil {
+			return fmt.Errorf("failed to marshal retention: %w", err)
+		}
+	}
+
 	query := `
 		INSERT INTO query_configs (
-			query_id, name, description, query, schedule, timeout, 
+			query_id, name, description, query, datasource, schedule, timeout, 
 			enabled, retry_count, retry_interval,
-			time_range_type, time_range_time, time_range_start, time_range_end, time_range_step
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			time_range_type, time_range_time, time_range_start, time_range_end, time_range_step,
+			relabel_configs, promoted_labels, retention
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON DUPLICATE KEY UPDATE
 			name = VALUES(name),
 			description = VALUES(description),
 			query = VALUES(query),
+			datasource = VALUES(datasource),
 			schedule = VALUES(schedule),
 			timeout = VALUES(timeout),
 			enabled = VALUES(enabled),
@@ -130,6 +283,9 @@ func SaveQueryToDB(db *sql.DB, config models.QueryConfig) error {
 			time_range_start = VALUES(time_range_start),
 			time_range_end = VALUES(time_range_end),
 			time_range_step = VALUES(time_range_step),
+			relabel_configs = VALUES(relabel_configs),
+			promoted_labels = VALUES(promoted_labels),
+			retention = VALUES(retention),
 			updated_at = CURRENT_TIMESTAMP
 	`
 
@@ -138,6 +294,7 @@ func SaveQueryToDB(db *sql.DB, config models.QueryConfig) error {
 		config.Name,
 		config.Description,
 		config.Query,
+		datasource,
 		config.Schedule,
 		config.Timeout,
 		config.Enabled,
@@ -148,6 +305,9 @@ func SaveQueryToDB(db *sql.DB, config models.QueryConfig) error {
 		timeRangeStart,
 		timeRangeEnd,
 		timeRangeStep,
+		relabelConfigs,
+		promotedLabels,
+		retention,
 	)
 
 	if err != nil {
@@ -158,7 +318,7 @@ func SaveQueryToDB(db *sql.DB, config models.QueryConfig) error {
 }
 
 // DeleteQueryFromDB deletes a query configuration from the database
-func DeleteQueryFromDB(db *sql.DB, queryID string) error {
+func DeleteQueryFromDB(db Execer, queryID string) error {
 	query := `DELETE FROM query_configs WHERE query_id = ?`
 
 	result, err := db.Exec(query, queryID)
@@ -172,7 +332,7 @@ func DeleteQueryFromDB(db *sql.DB, queryID string) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("no configuration found with query_id: %s", queryID)
+		return fmt.Errorf("%w: %s", ErrQueryNotFound, queryID)
 	}
 
 	return nil
@@ -193,7 +353,7 @@ func ToggleQueryEnabled(db *sql.DB, queryID string, enabled bool) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("no configuration found with query_id: %s", queryID)
+		return fmt.Errorf("%w: %s", ErrQueryNotFound, queryID)
 	}
 
 	return nil
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queryConfigRows are the rows returned by the queryConfigRows driver, by DSN
+var queryConfigRows = struct {
+	sync.Mutex
+	byDSN map[string][][]driver.Value
+}{byDSN: make(map[string][][]driver.Value)}
+
+var registerQueryConfigDriver sync.Once
+
+// openQueryConfigs returns a database whose queries all return rows, in the columns
+// of queryConfigColumns, so scanning goes through database/sql's conversions
+func openQueryConfigs(t *testing.T, rows [][]driver.Value) *sql.DB {
+	t.Helper()
+	registerQueryConfigDriver.Do(func() {
+		sql.Register("query_configs", queryConfigDriver{})
+	})
+
+	queryConfigRows.Lock()
+	queryConfigRows.byDSN[t.Name()] = rows
+	queryConfigRows.Unlock()
+
+	db, err := sql.Open("query_configs", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+type queryConfigDriver struct{}
+
+func (queryConfigDriver) Open(dsn string) (driver.Conn, error) {
+	queryConfigRows.Lock()
+	defer queryConfigRows.Unlock()
+	return queryConfigConn{rows: queryConfigRows.byDSN[dsn]}, nil
+}
+
+type queryConfigConn struct {
+	rows [][]driver.Value
+}
+
+func (c queryConfigConn) Prepare(query string) (driver.Stmt, error) { return queryConfigStmt(c), nil }
+func (c queryConfigConn) Close() error                              { return nil }
+func (c queryConfigConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+type queryConfigStmt queryConfigConn
+
+func (s queryConfigStmt) Close() error  { return nil }
+func (s queryConfigStmt) NumInput() int { return -1 }
+func (s queryConfigStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+func (s queryConfigStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &queryConfigResult{rows: s.rows}, nil
+}
+
+type queryConfigResult struct {
+	rows [][]driver.Value
+}
+
+func (r *queryConfigResult) Columns() []string {
+	columns := strings.Split(queryConfigColumns, ",")
+	for i := range columns {
+		columns[i] = strings.TrimSpace(columns[i])
+	}
+	return columns
+}
+
+func (r *queryConfigResult) Close() error { return nil }
+
+func (r *queryConfigResult) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// queryConfigRow returns a valid row of query id; set overrides columns by name
+func queryConfigRow(id string, set map[string]driver.Value) []driver.Value {
+	row := map[string]driver.Value{
+		"query_id":         id,
+		"name":             id,
+		"description":      "a query",
+		"query":            "up",
+		"datasource":       nil,
+		"schedule":         "0 * * * * *",
+		"timeout":          "30s",
+		"enabled":          int64(1),
+		"retry_count":      int64(3),
+		"retry_interval":   "10s",
+		"time_range_type":  "instant",
+		"time_range_time":  nil,
+		"time_range_start": nil,
+		"time_range_end":   nil,
+		"time_range_step":  nil,
+		"relabel_configs":  nil,
+		"promoted_labels":  nil,
+		"retention":        nil,
+		"updated_at":       time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for column, value := range set {
+		row[column] = value
+	}
+
+	var values []driver.Value
+	for _, column := range (&queryConfigResult{}).Columns() {
+		values = append(values, row[column])
+	}
+	return values
+}
+
+func TestLoadAllQueriesFromDB(t *testing.T) {
+	db := openQueryConfigs(t, [][]driver.Value{
+		queryConfigRow("described", nil),
+		// Nullable columns holding NULL read as empty values
+		queryConfigRow("nulls", map[string]driver.Value{
+			"description":    nil,
+			"timeout":        nil,
+			"enabled":        nil,
+			"retry_count":    nil,
+			"retry_interval": nil,
+		}),
+		queryConfigRow("unscannable", map[string]driver.Value{"retry_count": "three"}),
+		queryConfigRow("bad_relabel", map[string]driver.Value{"relabel_configs": []byte("{")}),
+		queryConfigRow("last", nil),
+	})
+
+	queries, invalid, err := LoadAllQueriesFromDB(db)
+	if err != nil {
+		t.Fatalf("LoadAllQueriesFromDB: %v", err)
+	}
+
+	var ids []string
+	for _, query := range queries {
+		ids = append(ids, query.ID)
+	}
+	if got := strings.Join(ids, ","); got != "described,nulls,last" {
+		t.Errorf("loaded %s, want described,nulls,last", got)
+	}
+	if len(invalid) != 2 || invalid["unscannable"] == nil || invalid["bad_relabel"] == nil {
+		t.Errorf("invalid = %v, want unscannable and bad_relabel", invalid)
+	}
+
+	nulls := queries[1]
+	if nulls.Description != "" || nulls.Timeout != "" || nulls.Enabled || nulls.RetryCount != 0 || nulls.RetryInterval != "" {
+		t.Errorf("query with NULL columns = %+v, want empty values", nulls)
+	}
+	if described := queries[0]; described.Description != "a query" || !described.Enabled || described.RetryCount != 3 {
+		t.Errorf("query = %+v, want the stored values", described)
+	}
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// datasourceColumns lists the datasources columns read by scanDatasource
+const datasourceColumns = `
+			name, url, timeout, auth_type, username, password, password_file, token, token_file,
+			tls_ca_file, tls_cert_file, tls_key_file, tls_server_name, tls_insecure_skip_verify,
+			headers, updated_at`
+
+// LoadDatasourcesFromDB loads all Prometheus datasources from the database
+func LoadDatasourcesFromDB(db *sql.DB) ([]models.Datasource, error) {
+	query := `
+		SELECT ` + datasourceColumns + `
+		FROM datasources
+		ORDER BY name
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query datasources: %w", err)
+	}
+	defer rows.Close()
+
+	var datasources []models.Datasource
+	for rows.Next() {
+		datasource, err := scanDatasource(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan datasource row: %w", err)
+		}
+		datasources = append(datasources, *datasource)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return datasources, nil
+}
+
+// scanDatasource scans a row selected with datasourceColumns
+func scanDatasource(row rowScanner) (*models.Datasource, error) {
+	var datasource models.Datasource
+	var timeout, authType, username, password, passwordFile, token, tokenFile sql.NullString
+	var caFile, certFile, keyFile, serverName sql.NullString
+	var insecureSkipVerify sql.NullBool
+	var headers []byte
+
+	err := row.Scan(
+		&datasource.Name,
+		&datasource.Prometheus.URL,
+		&timeout,
+		&authType,
+		&username,
+		&password,
+		&passwordFile,
+		&token,
+		&tokenFile,
+		&caFile,
+		&certFile,
+		&keyFile,
+		&serverName,
+		&insecureSkipVerify,
+		&headers,
+		&datasource.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	prom := &datasource.Prometheus
+	prom.Timeout = timeout.String
+	prom.Auth = models.AuthConfig{
+		Type:         authType.String,
+		Username:     username.String,
+		Password:     password.String,
+		PasswordFile: passwordFile.String,
+		Token:        token.String,
+		TokenFile:    tokenFile.String,
+	}
+	prom.TLS = models.TLSConfig{
+		CAFile:             caFile.String,
+		CertFile:           certFile.String,
+		KeyFile:            keyFile.String,
+		ServerName:         serverName.String,
+		InsecureSkipVerify: insecureSkipVerify.Bool,
+	}
+
+	if len(headers) > 0 {
+		if err := json.Unmarshal(headers, &prom.Headers); err != nil {
+			return nil, fmt.Errorf("invalid headers of datasource %s: %w", datasource.Name, err)
+		}
+	}
+
+	return &datasource, nil
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DuplicateGroup identifies metrics_data rows that share a series and timestamp
+type DuplicateGroup struct {
+	QueryID    string
+	MetricName string
+	LabelsHash []byte
+	Timestamp  time.Time
+	// KeepID is the most recently written row, which is kept
+	KeepID int64
+	Count  int64
+}
+
+// DuplicateTimeRange returns the oldest and newest sample timestamps that dedupe walks;
+// ok is false when there are no samples. An empty queryID covers all queries.
+func (db *DB) DuplicateTimeRange(queryID string) (oldest, newest time.Time, ok bool, err error) {
+	query := `SELECT MIN(timestamp), MAX(timestamp) FROM metrics_data`
+	var args []interface{}
+	if queryID != "" {
+		query += ` WHERE query_id = ?`
+		args = append(args, queryID)
+	}
+
+	var minTime, maxTime sql.NullTime
+	if err := db.conn.QueryRow(query, args...).Scan(&minTime, &maxTime); err != nil {
+		return time.Time{}, time.Time{}, false, fmt.Errorf("failed to get sample time range: %w", err)
+	}
+
+	return minTime.Time, maxTime.Time, minTime.Valid, nil
+}
+
+// FindDuplicateGroups returns the duplicated samples with a timestamp in [from, to).
+// Duplicates share their timestamp, so walking the table in consecutive windows finds
+// all of them in one pass, and each window only reads its range of a timestamp index.
+// An empty queryID searches across all queries.
+func (db *DB) FindDuplicateGroups(queryID string, from, to time.Time) ([]DuplicateGroup, error) {
+	filter := ""
+	args := []interface{}{from, to}
+	if queryID != "" {
+		filter = " AND query_id = ?"
+		args = append(args, queryID)
+	}
+	query := `
+		SELECT query_id, metric_name, labels_hash, timestamp, MAX(id), COUNT(*)
+		FROM metrics_data
+		WHERE timestamp >= ? AND timestamp < ?` + filter + `
+		GROUP BY query_id, metric_name, labels_hash, timestamp
+		HAVING COUNT(*) > 1
+	`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query duplicates: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []DuplicateGroup
+	for rows.Next() {
+		var group DuplicateGroup
+		err := rows.Scan(
+			&group.QueryID,
+			&group.MetricName,
+			&group.LabelsHash,
+			&group.Timestamp,
+			&group.KeepID,
+			&group.Count,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan duplicate group: %w", err)
+		}
+		groups = append(groups, group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return groups, nil
+}
+
+// DeleteDuplicates removes every row of group except the one with KeepID
+func (db *DB) DeleteDuplicates(group DuplicateGroup) (int64, error) {
+	query := `
+		DELETE FROM metrics_data
+		WHERE query_id = ? AND metric_name = ? AND labels_hash = ? AND timestamp = ? AND id <> ?
+	`
+
+	result, err := db.conn.Exec(query, group.QueryID, group.MetricName, group.LabelsHash, group.Timestamp, group.KeepID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete duplicates: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected, nil
+}
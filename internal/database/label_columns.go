@@ -0,0 +1,120 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// labelColumnPrefix prefixes the metrics_data columns of promoted labels
+const labelColumnPrefix = "label_"
+
+// labelColumnLength is the length of promoted label columns; longer values are truncated
+const labelColumnLength = 255
+
+// maxPromotedLabelLength keeps column and index names within MySQL's 64 character limit
+const maxPromotedLabelLength = 64 - len("idx_") - len(labelColumnPrefix)
+
+// promotableLabel matches the label names that can be promoted; unlike
+// Prometheus, reserved labels starting with __ are not accepted
+var promotableLabel = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+
+// LabelColumn returns the metrics_data column holding a promoted label
+func LabelColumn(label string) (string, error) {
+	if !promotableLabel.MatchString(label) {
+		return "", fmt.Errorf("invalid promoted label %q", label)
+	}
+	if len(label) > maxPromotedLabelLength {
+		return "", fmt.Errorf("promoted label %q is longer than %d characters", label, maxPromotedLabelLength)
+	}
+	return labelColumnPrefix + label, nil
+}
+
+// EnsureLabelColumns adds a generated column and an index to metrics_data for every
+// label that has none yet, and returns the added columns. The columns are virtual,
+// so existing rows are covered without rewriting the table; columns of labels that
+// are no longer promoted are kept.
+func (db *DB) EnsureLabelColumns(labels []string) ([]string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	columns, err := db.tableNames(`
+		SELECT COLUMN_NAME FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'metrics_data'
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metrics_data columns: %w", err)
+	}
+
+	indexes, err := db.tableNames(`
+		SELECT DISTINCT INDEX_NAME FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'metrics_data'
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metrics_data indexes: %w", err)
+	}
+
+	sorted := append([]string(nil), labels...)
+	sort.Strings(sorted)
+
+	var added []string
+	var errs []error
+	for i, label := range sorted {
+		if i > 0 && label == sorted[i-1] {
+			continue
+		}
+
+		column, err := LabelColumn(label)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if !columns[column] {
+			// The label name is validated, so it can be quoted into the statement
+			statement := fmt.Sprintf(
+				"ALTER TABLE metrics_data ADD COLUMN `%s` varchar(%d) "+
+					"GENERATED ALWAYS AS (left(json_unquote(json_extract(`labels`, '$.\"%s\"')), %d)) VIRTUAL",
+				column, labelColumnLength, label, labelColumnLength)
+			if _, err := db.conn.Exec(statement); err != nil {
+				errs = append(errs, fmt.Errorf("failed to add column %s: %w", column, err))
+				continue
+			}
+			added = append(added, column)
+		}
+
+		// The index is added separately, so a failed index is retried on the next call
+		index := "idx_" + column
+		if !indexes[index] {
+			statement := fmt.Sprintf(
+				"ALTER TABLE metrics_data ADD INDEX `%s` (`query_id`, `%s`, `timestamp`)",
+				index, column)
+			if _, err := db.conn.Exec(statement); err != nil {
+				errs = append(errs, fmt.Errorf("failed to add index %s: %w", index, err))
+			}
+		}
+	}
+
+	return added, errors.Join(errs...)
+}
+
+// tableNames runs a query returning one name per row and returns the names as a set
+func (db *DB) tableNames(query string) (map[string]bool, error) {
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names[name] = true
+	}
+	return names, rows.Err()
+}
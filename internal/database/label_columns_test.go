@@ -0,0 +1,52 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabelColumn(t *testing.T) {
+	longest := strings.Repeat("a", maxPromotedLabelLength)
+
+	tests := []struct {
+		label   string
+		want    string
+		wantErr string
+	}{
+		{label: "cluster", want: "label_cluster"},
+		{label: "Pod_2", want: "label_Pod_2"},
+		// Column and index names stay within MySQL's 64 character limit
+		{label: longest, want: "label_" + longest},
+		{label: longest + "a", wantErr: "longer than 54 characters"},
+		{label: "", wantErr: "invalid promoted label"},
+		{label: "__name__", wantErr: "invalid promoted label"},
+		{label: "_private", wantErr: "invalid promoted label"},
+		{label: "2xx", wantErr: "invalid promoted label"},
+		{label: "pod-name", wantErr: "invalid promoted label"},
+		// Labels are quoted into DDL, so quotes must never pass
+		{label: "a`b", wantErr: "invalid promoted label"},
+		{label: `a"b`, wantErr: "invalid promoted label"},
+		{label: "a'b", wantErr: "invalid promoted label"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			got, err := LabelColumn(tt.label)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LabelColumn: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("LabelColumn = %q, want %q", got, tt.want)
+			}
+			if index := "idx_" + got; len(index) > 64 {
+				t.Errorf("index name %q is longer than 64 characters", index)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// Downsampling intervals
+const (
+	RollupHourly = "hourly"
+	RollupDaily  = "daily"
+)
+
+// rollupTable describes the table holding the rollups of an interval
+type rollupTable struct {
+	name    string
+	seconds int
+}
+
+// rollupTables maps the downsampling intervals to their rollup tables
+var rollupTables = map[string]rollupTable{
+	RollupHourly: {name: "metrics_data_hourly", seconds: 3600},
+	RollupDaily:  {name: "metrics_data_daily", seconds: 86400},
+}
+
+// RollupIntervals returns the supported downsampling intervals, finest first
+func RollupIntervals() []string {
+	return []string{RollupHourly, RollupDaily}
+}
+
+// RollupMetrics aggregates the numeric samples of queryID in [from, to) into
+// min/max/avg/count rollups of the interval and returns the rows written.
+// Buckets that already exist are overwritten, so a window can be rolled up again.
+func (db *DB) RollupMetrics(interval, queryID string, from, to time.Time) (int64, error) {
+	table, ok := rollupTables[interval]
+	if !ok {
+		return 0, fmt.Errorf("unknown rollup interval: %s", interval)
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s
+		(query_id, source, metric_name, labels, bucket, min_value, max_value, avg_value, sample_count)
+		SELECT query_id, ANY_VALUE(source), metric_name, ANY_VALUE(labels),
+			FROM_UNIXTIME(FLOOR(UNIX_TIMESTAMP(timestamp) / ?) * ?) AS bucket,
+			MIN(value), MAX(value), AVG(value), COUNT(*)
+		FROM metrics_data
+		WHERE query_id = ? AND timestamp >= ? AND timestamp < ? AND value IS NOT NULL
+		GROUP BY query_id, metric_name, labels_hash, bucket
+		ON DUPLICATE KEY UPDATE
+			source = VALUES(source),
+			min_value = VALUES(min_value),
+			max_value = VALUES(max_value),
+			avg_value = VALUES(avg_value),
+			sample_count = VALUES(sample_count)
+	`, table.name)
+
+	result, err := db.conn.Exec(query, table.seconds, table.seconds, queryID, from, to)
+	if err != nil {
+		return 0, fmt.Errorf("failed to roll up metrics into %s: %w", table.name, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows, nil
+}
+
+// DeleteMetricsBefore removes up to limit samples of queryID older than before
+func (db *DB) DeleteMetricsBefore(queryID string, before time.Time, limit int) (int64, error) {
+	query := `DELETE FROM metrics_data WHERE query_id = ? AND timestamp < ? LIMIT ?`
+
+	result, err := db.conn.Exec(query, queryID, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete metrics: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteRollupsBefore removes up to limit rollups of queryID whose bucket starts before before
+func (db *DB) DeleteRollupsBefore(interval, queryID string, before time.Time, limit int) (int64, error) {
+	table, ok := rollupTables[interval]
+	if !ok {
+		return 0, fmt.Errorf("unknown rollup interval: %s", interval)
+	}
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE query_id = ? AND bucket < ? LIMIT ?`, table.name)
+
+	result, err := db.conn.Exec(query, queryID, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete rollups from %s: %w", table.name, err)
+	}
+
+	return result.RowsAffected()
+}
+
+// GetOldestMetricTime returns the timestamp of the oldest sample of queryID before before;
+// ok is false when there is none
+func (db *DB) GetOldestMetricTime(queryID string, before time.Time) (oldest time.Time, ok bool, err error) {
+	query := `SELECT MIN(timestamp) FROM metrics_data WHERE query_id = ? AND timestamp < ?`
+
+	var value sql.NullTime
+	if err := db.conn.QueryRow(query, queryID, before).Scan(&value); err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to get oldest metric time: %w", err)
+	}
+
+	return value.Time, value.Valid, nil
+}
+
+// GetRollupWatermark returns the time up to which the samples of queryID were rolled up;
+// ok is false when the query was never rolled up
+func (db *DB) GetRollupWatermark(queryID string) (watermark time.Time, ok bool, err error) {
+	query := `SELECT rolled_up_until FROM metrics_rollup_state WHERE query_id = ?`
+
+	err = db.conn.QueryRow(query, queryID).Scan(&watermark)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to get rollup watermark: %w", err)
+	}
+
+	return watermark, true, nil
+}
+
+// SetRollupWatermark records that the samples of queryID before watermark were rolled up
+func (db *DB) SetRollupWatermark(queryID string, watermark time.Time) error {
+	query := `
+		INSERT INTO metrics_rollup_state (query_id, rolled_up_until)
+		VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE rolled_up_until = VALUES(rolled_up_until)
+	`
+
+	if _, err := db.conn.Exec(query, queryID, watermark); err != nil {
+		return fmt.Errorf("failed to set rollup watermark: %w", err)
+	}
+
+	return nil
+}
+
+// InsertMaintenanceRun inserts a maintenance run record and sets its ID
+func (db *DB) InsertMaintenanceRun(run *models.MaintenanceRun) error {
+	query := `
+		INSERT INTO maintenance_runs
+		(query_id, status, start_time, end_time, duration_ms, cutoff, rollup_rows, rows_deleted, rollups_deleted, error_message, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := db.conn.Exec(query,
+		run.QueryID,
+		run.Status,
+		run.StartTime,
+		run.EndTime,
+		run.DurationMs,
+		run.Cutoff,
+		run.RollupRows,
+		run.RowsDeleted,
+		run.RollupsDeleted,
+		run.ErrorMessage,
+		run.CreatedAt,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert maintenance run: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get maintenance run id: %w", err)
+	}
+	run.ID = id
+
+	return nil
+}
+
+// UpdateMaintenanceRun stores the outcome of a run inserted by InsertMaintenanceRun
+func (db *DB) UpdateMaintenanceRun(run *models.MaintenanceRun) error {
+	query := `
+		UPDATE maintenance_runs
+		SET status = ?, end_time = ?, duration_ms = ?, cutoff = ?, rollup_rows = ?,
+			rows_deleted = ?, rollups_deleted = ?, error_message = ?
+		WHERE id = ?
+	`
+
+	_, err := db.conn.Exec(query,
+		run.Status,
+		run.EndTime,
+		run.DurationMs,
+		run.Cutoff,
+		run.RollupRows,
+		run.RowsDeleted,
+		run.RollupsDeleted,
+		run.ErrorMessage,
+		run.ID,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to update maintenance run: %w", err)
+	}
+
+	return nil
+}
+
+// GetMaintenanceRuns returns the maintenance history of a query
+func (db *DB) GetMaintenanceRuns(queryID string, limit int) ([]*models.MaintenanceRun, error) {
+	query := `
+		SELECT id, query_id, status, start_time, end_time, duration_ms, cutoff,
+			rollup_rows, rows_deleted, rollups_deleted, error_message, created_at
+		FROM maintenance_runs
+		WHERE query_id = ?
+		ORDER BY start_time DESC
+		LIMIT ?
+	`
+
+	rows, err := db.conn.Query(query, queryID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query maintenance runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []*models.MaintenanceRun
+	for rows.Next() {
+		run := &models.MaintenanceRun{}
+
+		err := rows.Scan(
+			&run.ID,
+			&run.QueryID,
+			&run.Status,
+			&run.StartTime,
+			&run.EndTime,
+			&run.DurationMs,
+			&run.Cutoff,
+			&run.RollupRows,
+			&run.RowsDeleted,
+			&run.RollupsDeleted,
+			&run.ErrorMessage,
+			&run.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan maintenance run: %w", err)
+		}
+
+		runs = append(runs, run)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return runs, nil
+}
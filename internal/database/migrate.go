@@ -0,0 +1,400 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the named lock serializing migrations of concurrently starting instances
+const migrationLock = "prom_etl_db_migrate"
+
+// migrationLockTimeout is how long to wait, in seconds, for another instance's migration
+const migrationLockTimeout = 300
+
+// PartitioningMigration is the version of the migration partitioning metrics_data,
+// which the partition command records after converting the table itself
+const PartitioningMigration = 11
+
+// createMigrationsTable creates the table recording the applied migrations
+const createMigrationsTable = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint NOT NULL,
+		name varchar(255) NOT NULL,
+		applied_at timestamp DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (version)
+	) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci
+`
+
+// Migration is a numbered schema change embedded in the binary
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and the time it was applied, nil when it is pending
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrations returns the embedded migrations ordered by version. Every migration
+// consists of migrations/<version>_<name>.up.sql and the matching .down.sql.
+func Migrations() ([]Migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, name := range names {
+		base := path.Base(name)
+		direction := path.Ext(strings.TrimSuffix(base, ".sql"))
+		stem := strings.TrimSuffix(base, direction+".sql")
+		prefix, title, ok := strings.Cut(stem, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 || (direction != ".up" && direction != ".down") {
+			return nil, fmt.Errorf("invalid migration file name: %s", base)
+		}
+
+		content, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", base, err)
+		}
+
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &Migration{Version: version, Name: title}
+			byVersion[version] = migration
+		}
+		if migration.Name != title {
+			return nil, fmt.Errorf("migration %d has files named %s and %s", version, migration.Name, title)
+		}
+		if direction == ".up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// String returns the migration's file name prefix, e.g. 0002_query_executions_evaluation_time
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// MigrationStatuses returns every embedded migration with the time it was applied
+// and leaves the database unchanged
+func (db *DB) MigrationStatuses(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing was applied before schema_migrations exists
+	applied := make(map[int]time.Time)
+	exists, err := db.TableExists("schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if applied, err = appliedMigrations(ctx, db.conn); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		statuses[i].Migration = migration
+		if appliedAt, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies the pending migrations up to target, or all of them when target
+// is 0, and returns the migrations applied. A database whose tables were created
+// without migrations must be baselined first, so existing tables are not recreated.
+func (db *DB) MigrateUp(ctx context.Context, target int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	err = db.withMigrationLock(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+		if len(applied) == 0 {
+			unmanaged, err := db.TableExists("query_configs")
+			if err != nil {
+				return err
+			}
+			if unmanaged {
+				return errors.New("database has tables but no recorded migrations; " +
+					"record the schema scripts already applied with the migrate baseline command")
+			}
+		}
+
+		for _, migration := range migrations {
+			if target > 0 && migration.Version > target {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if migration.Version == PartitioningMigration {
+				if err := checkPartitioningMigration(ctx, conn, migration); err != nil {
+					return err
+				}
+			}
+
+			if err := execMigration(ctx, conn, migration.Up); err != nil {
+				return fmt.Errorf("migration %s failed: %w", migration, err)
+			}
+			if _, err := conn.ExecContext(ctx,
+				`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`,
+				migration.Version, migration.Name); err != nil {
+				return fmt.Errorf("failed to record migration %s: %w", migration, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// checkPartitioningMigration refuses to partition a metrics_data table holding rows:
+// the migration rebuilds the table and blocks writes for the whole copy, while the
+// partition command converts it online and records the migration afterwards
+func checkPartitioningMigration(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	var hasRows bool
+	if err := conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM metrics_data)`).Scan(&hasRows); err != nil {
+		return fmt.Errorf("failed to check metrics_data before migration %s: %w", migration, err)
+	}
+	if hasRows {
+		return fmt.Errorf("migration %s would rebuild metrics_data, which already holds samples; "+
+			"convert the table online with the prom-etl-db partition command, which records the migration", migration)
+	}
+	return nil
+}
+
+// MigrateDown reverts the steps most recently applied migrations and returns them
+func (db *DB) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	err = db.withMigrationLock(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			if err := execMigration(ctx, conn, migration.Down); err != nil {
+				return fmt.Errorf("reverting migration %s failed: %w", migration, err)
+			}
+			if _, err := conn.ExecContext(ctx,
+				`DELETE FROM schema_migrations WHERE version = ?`, migration.Version); err != nil {
+				return fmt.Errorf("failed to unrecord migration %s: %w", migration, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// BaselineMigrations records the migrations up to version as applied without running
+// them, for databases whose schema was created or upgraded by hand
+func (db *DB) BaselineMigrations(ctx context.Context, version int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if version <= 0 || version > migrations[len(migrations)-1].Version {
+		return nil, fmt.Errorf("baseline version must be between 1 and %d, got %d",
+			migrations[len(migrations)-1].Version, version)
+	}
+
+	var done []Migration
+	err = db.withMigrationLock(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+		for _, migration := range migrations {
+			if migration.Version > version {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			if _, err := conn.ExecContext(ctx,
+				`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`,
+				migration.Version, migration.Name); err != nil {
+				return fmt.Errorf("failed to record migration %s: %w", migration, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// RecordMigration records the migration version as applied without running it, once
+// the change was made another way. Databases without schema_migrations are left alone,
+// as they are not managed by migrations until they are baselined.
+func (db *DB) RecordMigration(ctx context.Context, version int) error {
+	exists, err := db.TableExists("schema_migrations")
+	if err != nil || !exists {
+		return err
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		if migration.Version != version {
+			continue
+		}
+		return db.withMigrationLock(ctx, func(conn *sql.Conn, applied map[int]time.Time) error {
+			if _, ok := applied[version]; ok {
+				return nil
+			}
+			if _, err := conn.ExecContext(ctx,
+				`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`,
+				migration.Version, migration.Name); err != nil {
+				return fmt.Errorf("failed to record migration %s: %w", migration, err)
+			}
+			return nil
+		})
+	}
+	return fmt.Errorf("unknown migration version %d", version)
+}
+
+// withMigrationLock creates schema_migrations and calls fn with the applied migrations
+// while holding a named lock, so instances starting together do not migrate twice
+func (db *DB) withMigrationLock(ctx context.Context, fn func(conn *sql.Conn, applied map[int]time.Time) error) error {
+	// GET_LOCK belongs to the session, so everything runs on one connection
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, migrationLock, migrationLockTimeout).Scan(&locked); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("timed out waiting for the migration lock held by another instance")
+	}
+	defer conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, migrationLock)
+
+	if _, err := conn.ExecContext(ctx, createMigrationsTable); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+// queryer is implemented by *sql.DB and *sql.Conn
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// appliedMigrations returns the applied migration versions and when they were applied
+func appliedMigrations(ctx context.Context, q queryer) (map[int]time.Time, error) {
+	rows, err := q.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan migration: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return applied, nil
+}
+
+// execMigration runs the statements of a migration one by one. MySQL commits DDL
+// immediately, so the statements before a failed one stay applied.
+func execMigration(ctx context.Context, conn *sql.Conn, script string) error {
+	for i, statement := range splitStatements(script) {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("statement %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// splitStatements splits a migration script into statements ending with a
+// semicolon at the end of a line, dropping -- comment lines
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
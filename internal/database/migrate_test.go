@@ -0,0 +1,81 @@
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %s has version %d, want %d", migration, migration.Version, i+1)
+		}
+		if len(splitStatements(migration.Up)) == 0 || len(splitStatements(migration.Down)) == 0 {
+			t.Errorf("migration %s has an empty up or down script", migration)
+		}
+	}
+
+	// 0001 is the schema of scripts/migrate.sql, so fresh and upgraded databases match
+	initial := migrations[0]
+	if initial.String() != "0001_initial" {
+		t.Errorf("first migration = %s", initial)
+	}
+	if strings.Contains(initial.Up, "PARTITION") || !strings.Contains(initial.Up, "'gpu_utilization_daily'") {
+		t.Errorf("0001_initial differs from the original schema")
+	}
+	if strings.Count(initial.Up, "CREATE TABLE IF NOT EXISTS") != strings.Count(initial.Up, "CREATE TABLE") {
+		t.Errorf("0001_initial creates tables that may already exist")
+	}
+
+	if PartitioningMigration > len(migrations) || migrations[PartitioningMigration-1].Name != "metrics_data_partitioning" {
+		t.Errorf("PartitioningMigration %d does not name the partitioning migration", PartitioningMigration)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{
+			name:   "comments and blank lines are dropped",
+			script: "-- comment\n\nDROP TABLE a;\n  -- indented comment\nDROP TABLE b;\n",
+			want:   []string{"DROP TABLE a", "DROP TABLE b"},
+		},
+		{
+			name:   "statements span lines",
+			script: "ALTER TABLE a\n  ADD COLUMN b int,\n  ADD KEY idx_b (b);\n",
+			want:   []string{"ALTER TABLE a\n  ADD COLUMN b int,\n  ADD KEY idx_b (b)"},
+		},
+		{
+			name:   "semicolons inside a line do not split",
+			script: "INSERT INTO a VALUES ('x;y');\n",
+			want:   []string{"INSERT INTO a VALUES ('x;y')"},
+		},
+		{
+			name:   "last statement without semicolon",
+			script: "DROP TABLE a;\nDROP TABLE b",
+			want:   []string{"DROP TABLE a", "DROP TABLE b"},
+		},
+		{
+			name:   "only comments",
+			script: "-- nothing to do\n",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.script); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
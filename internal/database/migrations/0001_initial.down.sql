@@ -0,0 +1,6 @@
+-- Drop the initial schema together with all collected data
+DROP TABLE `query_configs`;
+
+DROP TABLE `query_executions`;
+
+DROP TABLE `metrics_data`;
@@ -0,0 +1,3 @@
+ALTER TABLE `query_executions`
+  DROP KEY `idx_query_id_evaluation_time`,
+  DROP COLUMN `evaluation_time`;
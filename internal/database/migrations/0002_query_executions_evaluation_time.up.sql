@@ -0,0 +1,4 @@
+-- Record the logical evaluation time (scheduled fire time) of each execution
+ALTER TABLE `query_executions`
+  ADD COLUMN `evaluation_time` timestamp(3) NULL AFTER `status`,
+  ADD KEY `idx_query_id_evaluation_time` (`query_id`, `evaluation_time`);
@@ -0,0 +1,2 @@
+ALTER TABLE `metrics_data`
+  DROP COLUMN `labels_hash`;
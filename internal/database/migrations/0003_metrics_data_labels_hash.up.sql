@@ -0,0 +1,7 @@
+-- Derive a series identity from the labels so duplicated samples can be detected
+-- Adding a STORED generated column copies the whole metrics_data table and blocks
+-- writes meanwhile, so on large tables this is an offline step: stop the service,
+-- run `prom-etl-db migrate up --to 3` and start it again afterwards.
+-- 0004 fails while duplicates exist, run `prom-etl-db dedupe` then
+ALTER TABLE `metrics_data`
+  ADD COLUMN `labels_hash` binary(16) GENERATED ALWAYS AS (unhex(md5(cast(`labels` as char)))) STORED NOT NULL AFTER `labels`;
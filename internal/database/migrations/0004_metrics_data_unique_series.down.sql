@@ -0,0 +1,2 @@
+ALTER TABLE `metrics_data`
+  DROP KEY `uk_series_timestamp`;
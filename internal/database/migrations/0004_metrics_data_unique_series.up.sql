@@ -0,0 +1,4 @@
+-- Make metric writes idempotent: one row per series and timestamp
+-- Fails while duplicates exist; run `prom-etl-db dedupe` first
+ALTER TABLE `metrics_data`
+  ADD UNIQUE KEY `uk_series_timestamp` (`query_id`, `metric_name`, `labels_hash`, `timestamp`);
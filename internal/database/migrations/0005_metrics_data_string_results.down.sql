@@ -0,0 +1,9 @@
+-- String results cannot be stored without string_value and are removed
+DELETE FROM `metrics_data`
+WHERE
+  `value` IS NULL;
+
+ALTER TABLE `metrics_data`
+  MODIFY COLUMN `value` double NOT NULL,
+  DROP COLUMN `string_value`,
+  MODIFY COLUMN `result_type` enum ('instant', 'range', 'scalar') NOT NULL;
@@ -0,0 +1,6 @@
+-- Store scalar and string query results
+-- String results are kept in string_value and have no numeric value
+ALTER TABLE `metrics_data`
+  MODIFY COLUMN `value` double NULL,
+  ADD COLUMN `string_value` text NULL AFTER `value`,
+  MODIFY COLUMN `result_type` enum ('instant', 'range', 'scalar', 'string') NOT NULL;
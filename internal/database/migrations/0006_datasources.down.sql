@@ -0,0 +1,8 @@
+ALTER TABLE `metrics_data`
+  DROP KEY `idx_source`,
+  DROP COLUMN `source`;
+
+ALTER TABLE `query_configs`
+  DROP COLUMN `datasource`;
+
+DROP TABLE `datasources`;
@@ -0,0 +1,33 @@
+-- Query several Prometheus endpoints
+-- Adds the datasources table, the datasource of every query and the source of every sample;
+-- existing samples and queries keep using the PROMETHEUS_* endpoint, recorded as 'default'
+CREATE TABLE
+  `datasources` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `name` varchar(100) NOT NULL,
+    `url` varchar(1024) NOT NULL,
+    `timeout` varchar(20) NULL,
+    `auth_type` enum ('none', 'basic', 'bearer') DEFAULT 'none',
+    `username` varchar(255) NULL,
+    `password` varchar(1024) NULL,
+    `password_file` varchar(1024) NULL,
+    `token` text NULL,
+    `token_file` varchar(1024) NULL,
+    `tls_ca_file` varchar(1024) NULL,
+    `tls_cert_file` varchar(1024) NULL,
+    `tls_key_file` varchar(1024) NULL,
+    `tls_server_name` varchar(255) NULL,
+    `tls_insecure_skip_verify` tinyint (1) DEFAULT 0,
+    `headers` json NULL,
+    `created_at` timestamp DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` timestamp DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uk_name` (`name`)
+  ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
+
+ALTER TABLE `query_configs`
+  ADD COLUMN `datasource` varchar(100) NULL AFTER `query`;
+
+ALTER TABLE `metrics_data`
+  ADD COLUMN `source` varchar(100) NOT NULL DEFAULT 'default' AFTER `query_id`,
+  ADD KEY `idx_source` (`source`);
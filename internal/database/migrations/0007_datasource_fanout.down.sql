@@ -0,0 +1,13 @@
+-- Partial executions are recorded as failed; fails while a query lists
+-- datasources longer than the previous column
+UPDATE `query_executions`
+SET
+  `status` = 'failed'
+WHERE
+  `status` = 'partial';
+
+ALTER TABLE `query_executions`
+  MODIFY COLUMN `status` enum ('running', 'success', 'failed', 'timeout') NOT NULL;
+
+ALTER TABLE `query_configs`
+  MODIFY COLUMN `datasource` varchar(100) NULL;
@@ -0,0 +1,8 @@
+-- Fan out queries over several datasources
+-- query_configs.datasource may list several datasources (or *), and executions
+-- where only some of them answered are recorded as partial
+ALTER TABLE `query_configs`
+  MODIFY COLUMN `datasource` varchar(1024) NULL;
+
+ALTER TABLE `query_executions`
+  MODIFY COLUMN `status` enum ('running', 'success', 'partial', 'failed', 'timeout') NOT NULL;
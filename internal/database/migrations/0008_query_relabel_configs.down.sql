@@ -0,0 +1,2 @@
+ALTER TABLE `query_configs`
+  DROP COLUMN `relabel_configs`;
@@ -0,0 +1,5 @@
+-- Per-query relabeling rules
+-- query_configs.relabel_configs holds a JSON array of Prometheus-style
+-- relabel_config rules applied to every series before it is stored
+ALTER TABLE `query_configs`
+  ADD COLUMN `relabel_configs` json NULL AFTER `time_range_step`;
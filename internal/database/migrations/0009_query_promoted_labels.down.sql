@@ -0,0 +1,3 @@
+-- The label_<name> columns already added to metrics_data are kept
+ALTER TABLE `query_configs`
+  DROP COLUMN `promoted_labels`;
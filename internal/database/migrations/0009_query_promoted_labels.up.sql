@@ -0,0 +1,5 @@
+-- Promote labels to metrics_data columns
+-- query_configs.promoted_labels holds a JSON array of label names; the service
+-- adds an indexed label_<name> column to metrics_data for each of them
+ALTER TABLE `query_configs`
+  ADD COLUMN `promoted_labels` json NULL AFTER `relabel_configs`;
@@ -0,0 +1,10 @@
+DROP TABLE `maintenance_runs`;
+
+DROP TABLE `metrics_rollup_state`;
+
+DROP TABLE `metrics_data_daily`;
+
+DROP TABLE `metrics_data_hourly`;
+
+ALTER TABLE `query_configs`
+  DROP COLUMN `retention`;
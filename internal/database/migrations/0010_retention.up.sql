@@ -0,0 +1,61 @@
+-- Retention and downsampling
+-- query_configs.retention holds the retention policy of a query; the
+-- maintenance job records its runs in maintenance_runs
+ALTER TABLE `query_configs`
+  ADD COLUMN `retention` json NULL AFTER `promoted_labels`;
+
+-- Downsampled metrics
+-- Hourly and daily min/max/avg/count rollups of numeric samples, written by the
+-- maintenance job before expired raw samples are removed; bucket is the start
+-- of the hour or day in UTC
+CREATE TABLE
+  `metrics_data_hourly` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `query_id` varchar(100) NOT NULL,
+    `source` varchar(100) NOT NULL DEFAULT 'default',
+    `metric_name` varchar(255) NOT NULL,
+    `labels` json NOT NULL,
+    `labels_hash` binary(16) GENERATED ALWAYS AS (unhex(md5(cast(`labels` as char)))) STORED NOT NULL,
+    `bucket` timestamp NOT NULL,
+    `min_value` double NULL,
+    `max_value` double NULL,
+    `avg_value` double NULL,
+    `sample_count` bigint NOT NULL,
+    `created_at` timestamp DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uk_series_bucket` (`query_id`, `metric_name`, `labels_hash`, `bucket`),
+    KEY `idx_query_id_bucket` (`query_id`, `bucket`)
+  ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
+
+CREATE TABLE
+  `metrics_data_daily` LIKE `metrics_data_hourly`;
+
+-- Time up to which the samples of each query were rolled up
+CREATE TABLE
+  `metrics_rollup_state` (
+    `query_id` varchar(100) NOT NULL,
+    `rolled_up_until` timestamp NOT NULL,
+    `updated_at` timestamp DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`query_id`)
+  ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
+
+-- Maintenance runs
+-- One row per query and run of the retention job; raw samples before cutoff were removed
+CREATE TABLE
+  `maintenance_runs` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `query_id` varchar(100) NOT NULL,
+    `status` enum ('running', 'success', 'failed') NOT NULL,
+    `start_time` timestamp(3) NOT NULL,
+    `end_time` timestamp(3) NULL,
+    `duration_ms` int NULL,
+    `cutoff` timestamp(3) NULL,
+    `rollup_rows` bigint DEFAULT 0,
+    `rows_deleted` bigint DEFAULT 0,
+    `rollups_deleted` bigint DEFAULT 0,
+    `error_message` text NULL,
+    `created_at` timestamp DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    KEY `idx_query_id_start_time` (`query_id`, `start_time`),
+    KEY `idx_status` (`status`)
+  ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
@@ -0,0 +1,6 @@
+-- Merge the partitions of metrics_data back into one table; rebuilds metrics_data
+ALTER TABLE `metrics_data` REMOVE PARTITIONING;
+
+ALTER TABLE `metrics_data`
+  DROP PRIMARY KEY,
+  ADD PRIMARY KEY (`id`);
@@ -0,0 +1,11 @@
+-- Partition metrics_data by timestamp with only the pmax partition; the service
+-- splits it into daily or monthly partitions when PARTITION_INTERVAL is set.
+-- MySQL requires the partitioning column in every key, so the timestamp joins the
+-- primary key. The ALTER rebuilds metrics_data and blocks writes meanwhile, so
+-- migrate up only runs it on an empty table; tables holding samples are converted
+-- online with the partition command instead, which records this migration.
+ALTER TABLE `metrics_data`
+  DROP PRIMARY KEY,
+  ADD PRIMARY KEY (`id`, `timestamp`)
+PARTITION BY
+  RANGE (FLOOR(UNIX_TIMESTAMP(`timestamp`))) (PARTITION `pmax` VALUES LESS THAN MAXVALUE);
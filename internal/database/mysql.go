@@ -10,6 +10,32 @@ import (
 	"github.com/samzong/prom-etl-db/internal/models"
 )
 
+// upsertMetricQuery writes a metric sample. A sample is identified by query_id,
+// metric_name, labels_hash and timestamp (uk_series_timestamp), so writing the
+// same sample again, e.g. on retries or reruns, updates it instead of duplicating it.
+// The source column is not part of the key: series of a query fanned out over several
+// datasources only stay apart through the source label in labels_hash, which the
+// executor requires on every record of such queries.
+const upsertMetricQuery = `
+	INSERT INTO metrics_data 
+	(query_id, source, metric_name, labels, value, string_value, timestamp, result_type, collected_at) 
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE
+		source = VALUES(source),
+		value = VALUES(value),
+		string_value = VALUES(string_value),
+		result_type = VALUES(result_type),
+		collected_at = VALUES(collected_at)
+`
+
+// numericValue returns the value column of record; string results have none
+func numericValue(record *models.MetricRecord) interface{} {
+	if record.StringValue != nil {
+		return nil
+	}
+	return record.Value
+}
+
 // DB represents a database connection
 type DB struct {
 	conn *sql.DB
@@ -50,7 +76,7 @@ func (db *DB) TestConnection() error {
 	return db.conn.Ping()
 }
 
-// InsertMetricRecord inserts a metric record into the database
+// InsertMetricRecord inserts or updates a metric record in the database
 func (db *DB) InsertMetricRecord(record *models.MetricRecord) error {
 	// Convert labels to JSON
 	labelsJSON, err := json.Marshal(record.Labels)
@@ -58,17 +84,13 @@ func (db *DB) InsertMetricRecord(record *models.MetricRecord) error {
 		return fmt.Errorf("failed to marshal labels: %w", err)
 	}
 
-	query := `
-		INSERT INTO metrics_data 
-		(query_id, metric_name, labels, value, timestamp, result_type, collected_at) 
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`
-
-	_, err = db.conn.Exec(query,
+	_, err = db.conn.Exec(upsertMetricQuery,
 		record.QueryID,
+		record.Source,
 		record.MetricName,
 		labelsJSON,
-		record.Value,
+		numericValue(record),
+		record.StringValue,
 		record.Timestamp,
 		record.ResultType,
 		record.CollectedAt,
@@ -81,7 +103,7 @@ func (db *DB) InsertMetricRecord(record *models.MetricRecord) error {
 	return nil
 }
 
-// InsertMetricRecords inserts multiple metric records in a transaction
+// InsertMetricRecords inserts or updates multiple metric records in a transaction
 func (db *DB) InsertMetricRecords(records []*models.MetricRecord) error {
 	if len(records) == 0 {
 		return nil
@@ -95,11 +117,7 @@ func (db *DB) InsertMetricRecords(records []*models.MetricRecord) error {
 	defer tx.Rollback()
 
 	// Prepare statement
-	stmt, err := tx.Prepare(`
-		INSERT INTO metrics_data 
-		(query_id, metric_name, labels, value, timestamp, result_type, collected_at) 
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`)
+	stmt, err := tx.Prepare(upsertMetricQuery)
 	if err != nil {
 		return fmt.Errorf("failed to prepare statement: %w", err)
 	}
@@ -115,9 +133,11 @@ func (db *DB) InsertMetricRecords(records []*models.MetricRecord) error {
 
 		_, err = stmt.Exec(
 			record.QueryID,
+			record.Source,
 			record.MetricName,
 			labelsJSON,
-			record.Value,
+			numericValue(record),
+			record.StringValue,
 			record.Timestamp,
 			record.ResultType,
 			record.CollectedAt,
@@ -135,18 +155,19 @@ func (db *DB) InsertMetricRecords(records []*models.MetricRecord) error {
 	return nil
 }
 
-// InsertQueryExecution inserts a query execution record
+// InsertQueryExecution inserts a query execution record and sets its ID
 func (db *DB) InsertQueryExecution(execution *models.QueryExecution) error {
 	query := `
 		INSERT INTO query_executions 
-		(query_id, query_name, status, start_time, end_time, duration_ms, records_count, error_message, created_at) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		(query_id, query_name, status, evaluation_time, start_time, end_time, duration_ms, records_count, error_message, created_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := db.conn.Exec(query,
+	result, err := db.conn.Exec(query,
 		execution.QueryID,
 		execution.QueryName,
 		execution.Status,
+		execution.EvaluationTime,
 		execution.StartTime,
 		execution.EndTime,
 		execution.DurationMs,
@@ -159,13 +180,43 @@ func (db *DB) InsertQueryExecution(execution *models.QueryExecution) error {
 		return fmt.Errorf("failed to insert query execution: %w", err)
 	}
 
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get execution id: %w", err)
+	}
+	execution.ID = id
+
+	return nil
+}
+
+// UpdateQueryExecution stores the outcome of an execution inserted by InsertQueryExecution
+func (db *DB) UpdateQueryExecution(execution *models.QueryExecution) error {
+	query := `
+		UPDATE query_executions
+		SET status = ?, end_time = ?, duration_ms = ?, records_count = ?, error_message = ?
+		WHERE id = ?
+	`
+
+	_, err := db.conn.Exec(query,
+		execution.Status,
+		execution.EndTime,
+		execution.DurationMs,
+		execution.RecordsCount,
+		execution.ErrorMessage,
+		execution.ID,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to update query execution: %w", err)
+	}
+
 	return nil
 }
 
 // GetLatestMetrics returns the latest metrics for a query
 func (db *DB) GetLatestMetrics(queryID string, limit int) ([]*models.MetricRecord, error) {
 	query := `
-		SELECT id, query_id, metric_name, labels, value, timestamp, result_type, collected_at
+		SELECT id, query_id, source, metric_name, labels, value, string_value, timestamp, result_type, collected_at
 		FROM metrics_data 
 		WHERE query_id = ? 
 		ORDER BY timestamp DESC 
@@ -182,13 +233,16 @@ func (db *DB) GetLatestMetrics(queryID string, limit int) ([]*models.MetricRecor
 	for rows.Next() {
 		record := &models.MetricRecord{}
 		var labelsJSON []byte
+		var value sql.NullFloat64
 
 		err := rows.Scan(
 			&record.ID,
 			&record.QueryID,
+			&record.Source,
 			&record.MetricName,
 			&labelsJSON,
-			&record.Value,
+			&value,
+			&record.StringValue,
 			&record.Timestamp,
 			&record.ResultType,
 			&record.CollectedAt,
@@ -201,6 +255,7 @@ func (db *DB) GetLatestMetrics(queryID string, limit int) ([]*models.MetricRecor
 		if err := json.Unmarshal(labelsJSON, &record.Labels); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal labels: %w", err)
 		}
+		record.Value = value.Float64
 
 		records = append(records, record)
 	}
@@ -215,7 +270,7 @@ func (db *DB) GetLatestMetrics(queryID string, limit int) ([]*models.MetricRecor
 // GetQueryExecutions returns query execution history
 func (db *DB) GetQueryExecutions(queryID string, limit int) ([]*models.QueryExecution, error) {
 	query := `
-		SELECT id, query_id, query_name, status, start_time, end_time, duration_ms, records_count, error_message, created_at
+		SELECT id, query_id, query_name, status, evaluation_time, start_time, end_time, duration_ms, records_count, error_message, created_at
 		FROM query_executions 
 		WHERE query_id = ? 
 		ORDER BY start_time DESC 
@@ -237,6 +292,7 @@ func (db *DB) GetQueryExecutions(queryID string, limit int) ([]*models.QueryExec
 			&execution.QueryID,
 			&execution.QueryName,
 			&execution.Status,
+			&execution.EvaluationTime,
 			&execution.StartTime,
 			&execution.EndTime,
 			&execution.DurationMs,
@@ -258,6 +314,38 @@ func (db *DB) GetQueryExecutions(queryID string, limit int) ([]*models.QueryExec
 	return executions, nil
 }
 
+// GetSucceededEvaluationTimes returns the evaluation times in [start, end] that
+// have a successful execution of queryID, keyed by Unix milliseconds
+func (db *DB) GetSucceededEvaluationTimes(queryID string, start, end time.Time) (map[int64]bool, error) {
+	query := `
+		SELECT DISTINCT evaluation_time
+		FROM query_executions 
+		WHERE query_id = ? AND status = 'success' 
+			AND evaluation_time BETWEEN ? AND ?
+	`
+
+	rows, err := db.conn.Query(query, queryID, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query succeeded executions: %w", err)
+	}
+	defer rows.Close()
+
+	succeeded := make(map[int64]bool)
+	for rows.Next() {
+		var evaluationTime time.Time
+		if err := rows.Scan(&evaluationTime); err != nil {
+			return nil, fmt.Errorf("failed to scan evaluation time: %w", err)
+		}
+		succeeded[evaluationTime.UnixMilli()] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return succeeded, nil
+}
+
 // GetMetricsCount returns the count of metrics for a query
 func (db *DB) GetMetricsCount(queryID string) (int64, error) {
 	query := `SELECT COUNT(*) FROM metrics_data WHERE query_id = ?`
@@ -0,0 +1,287 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tables used while converting metrics_data to a partitioned table
+const (
+	PartitionedMetricsTable   = "metrics_data_partitioned"
+	UnpartitionedMetricsTable = "metrics_data_unpartitioned"
+)
+
+// MaxPartition is the catch-all partition holding rows beyond the last bound,
+// so inserts never fail when partitions were not created in time
+const MaxPartition = "pmax"
+
+// metricsCopyColumns are the stored, non-generated metrics_data columns without id
+const metricsCopyColumns = "query_id, source, metric_name, labels, value, string_value, timestamp, result_type, collected_at"
+
+// Partition describes a RANGE partition of a metrics table
+type Partition struct {
+	Name string
+	// LessThan is the exclusive upper bound; zero for the MAXVALUE partition
+	LessThan time.Time
+	// Rows is InnoDB's estimate of the partition's row count
+	Rows int64
+}
+
+// PartitionSpec defines a partition to create
+type PartitionSpec struct {
+	Name     string
+	LessThan time.Time
+}
+
+// ListPartitions returns the partitions of table in order; the result is empty
+// when the table is not partitioned
+func (db *DB) ListPartitions(table string) ([]Partition, error) {
+	query := `
+		SELECT PARTITION_NAME, PARTITION_DESCRIPTION, TABLE_ROWS
+		FROM information_schema.PARTITIONS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND PARTITION_NAME IS NOT NULL
+		ORDER BY PARTITION_ORDINAL_POSITION
+	`
+
+	rows, err := db.conn.Query(query, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var partitions []Partition
+	for rows.Next() {
+		var partition Partition
+		var description string
+		var tableRows sql.NullInt64
+		if err := rows.Scan(&partition.Name, &description, &tableRows); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		partition.Rows = tableRows.Int64
+
+		if description != "MAXVALUE" {
+			bound, err := strconv.ParseInt(description, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected bound %q of partition %s", description, partition.Name)
+			}
+			partition.LessThan = time.Unix(bound, 0).UTC()
+		}
+		partitions = append(partitions, partition)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return partitions, nil
+}
+
+// AddPartitions splits the MAXVALUE partition of table into specs followed by a new MAXVALUE partition
+func (db *DB) AddPartitions(table string, specs []PartitionSpec) error {
+	if len(specs) == 0 {
+		return nil
+	}
+
+	statement := fmt.Sprintf("ALTER TABLE `%s` REORGANIZE PARTITION %s INTO (%s)",
+		table, MaxPartition, partitionDefinitions(specs))
+	if _, err := db.conn.Exec(statement); err != nil {
+		return fmt.Errorf("failed to add partitions to %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// DropPartitions drops partitions of table together with their rows
+func (db *DB) DropPartitions(table string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	statement := fmt.Sprintf("ALTER TABLE `%s` DROP PARTITION %s", table, strings.Join(names, ", "))
+	if _, err := db.conn.Exec(statement); err != nil {
+		return fmt.Errorf("failed to drop partitions of %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// CreatePartitionedMetricsTable creates an empty copy of metrics_data partitioned into specs.
+// The timestamp is added to the primary key, as MySQL requires every unique key
+// to include the partitioning column.
+func (db *DB) CreatePartitionedMetricsTable(specs []PartitionSpec) error {
+	statements := []string{
+		fmt.Sprintf("CREATE TABLE `%s` LIKE metrics_data", PartitionedMetricsTable),
+		fmt.Sprintf("ALTER TABLE `%s` DROP PRIMARY KEY, ADD PRIMARY KEY (`id`, `timestamp`) "+
+			"PARTITION BY RANGE (FLOOR(UNIX_TIMESTAMP(`timestamp`))) (%s)",
+			PartitionedMetricsTable, partitionDefinitions(specs)),
+	}
+
+	for _, statement := range statements {
+		if _, err := db.conn.Exec(statement); err != nil {
+			return fmt.Errorf("failed to create %s: %w", PartitionedMetricsTable, err)
+		}
+	}
+
+	return nil
+}
+
+// TableExists reports whether table exists in the current database
+func (db *DB) TableExists(table string) (bool, error) {
+	query := `SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?`
+
+	var count int
+	if err := db.conn.QueryRow(query, table).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check table %s: %w", table, err)
+	}
+
+	return count > 0, nil
+}
+
+// GetMetricsTimeRange returns the oldest timestamp and the highest id of metrics_data;
+// ok is false when the table is empty
+func (db *DB) GetMetricsTimeRange() (oldest time.Time, maxID int64, ok bool, err error) {
+	query := `SELECT MIN(timestamp), COALESCE(MAX(id), 0) FROM metrics_data`
+
+	var value sql.NullTime
+	if err := db.conn.QueryRow(query).Scan(&value, &maxID); err != nil {
+		return time.Time{}, 0, false, fmt.Errorf("failed to get metrics time range: %w", err)
+	}
+
+	return value.Time, maxID, value.Valid, nil
+}
+
+// GetMaxMetricID returns the highest id in table, or 0 when it is empty
+func (db *DB) GetMaxMetricID(table string) (int64, error) {
+	query := fmt.Sprintf("SELECT COALESCE(MAX(id), 0) FROM `%s`", table)
+
+	var maxID int64
+	if err := db.conn.QueryRow(query).Scan(&maxID); err != nil {
+		return 0, fmt.Errorf("failed to get max id of %s: %w", table, err)
+	}
+
+	return maxID, nil
+}
+
+// CopyMetricsBatch copies up to limit rows with an id above afterID from metrics_data
+// to the partitioned table, keeping their ids. It returns the highest id selected,
+// whether copied or ignored as a duplicate, or afterID when no row was left.
+func (db *DB) CopyMetricsBatch(afterID int64, limit int) (int64, error) {
+	lastID, ok, err := db.metricsBatchEnd("metrics_data", afterID, limit)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return afterID, nil
+	}
+
+	statement := fmt.Sprintf(`
+		INSERT IGNORE INTO %s (id, %s)
+		SELECT id, %s FROM metrics_data
+		WHERE id > ? AND id <= ?
+	`, PartitionedMetricsTable, metricsCopyColumns, metricsCopyColumns)
+	if _, err := db.conn.Exec(statement, afterID, lastID); err != nil {
+		return 0, fmt.Errorf("failed to copy metrics: %w", err)
+	}
+
+	return lastID, nil
+}
+
+// SwapPartitionedMetricsTable atomically renames metrics_data to the unpartitioned
+// table and the partitioned table to metrics_data
+func (db *DB) SwapPartitionedMetricsTable() error {
+	statement := fmt.Sprintf("RENAME TABLE metrics_data TO `%s`, `%s` TO metrics_data",
+		UnpartitionedMetricsTable, PartitionedMetricsTable)
+	if _, err := db.conn.Exec(statement); err != nil {
+		return fmt.Errorf("failed to swap metrics tables: %w", err)
+	}
+
+	return nil
+}
+
+// CopyRemainingMetricsBatch copies up to limit rows with an id in (afterID, maxID] from
+// the unpartitioned table to metrics_data with new ids, skipping samples metrics_data
+// already has. It returns the highest id copied, or maxID when no row was left.
+func (db *DB) CopyRemainingMetricsBatch(afterID, maxID int64, limit int) (int64, error) {
+	lastID, ok, err := db.metricsBatchEnd(UnpartitionedMetricsTable, afterID, limit)
+	if err != nil {
+		return 0, err
+	}
+	if !ok || lastID > maxID {
+		lastID = maxID
+	}
+	if lastID <= afterID {
+		return maxID, nil
+	}
+
+	statement := fmt.Sprintf(`
+		INSERT IGNORE INTO metrics_data (%s)
+		SELECT %s FROM %s
+		WHERE id > ? AND id <= ?
+	`, metricsCopyColumns, metricsCopyColumns, UnpartitionedMetricsTable)
+	if _, err := db.conn.Exec(statement, afterID, lastID); err != nil {
+		return 0, fmt.Errorf("failed to copy remaining metrics: %w", err)
+	}
+
+	return lastID, nil
+}
+
+// SyncRewrittenMetricsBatch updates the samples of metrics_data whose row in the
+// unpartitioned table, with an id in (afterID, afterID+limit] up to maxID, was collected
+// later: samples rewritten by retries or reruns after they were copied. Samples are matched
+// on uk_series_timestamp, so rows written to metrics_data after the swap are only replaced
+// by older rows. It returns the highest id checked.
+func (db *DB) SyncRewrittenMetricsBatch(afterID, maxID int64, limit int) (int64, error) {
+	lastID := afterID + int64(limit)
+	if lastID > maxID {
+		lastID = maxID
+	}
+
+	statement := fmt.Sprintf(`
+		UPDATE metrics_data m
+		JOIN %s u
+			ON u.query_id = m.query_id
+			AND u.metric_name = m.metric_name
+			AND u.labels_hash = m.labels_hash
+			AND u.timestamp = m.timestamp
+		SET m.source = u.source,
+			m.value = u.value,
+			m.string_value = u.string_value,
+			m.result_type = u.result_type,
+			m.collected_at = u.collected_at
+		WHERE u.id > ? AND u.id <= ? AND u.collected_at > m.collected_at
+	`, UnpartitionedMetricsTable)
+	if _, err := db.conn.Exec(statement, afterID, lastID); err != nil {
+		return 0, fmt.Errorf("failed to sync rewritten metrics: %w", err)
+	}
+
+	return lastID, nil
+}
+
+// metricsBatchEnd returns the highest id among the first limit rows of table with
+// an id above afterID; ok is false when there is no such row
+func (db *DB) metricsBatchEnd(table string, afterID int64, limit int) (int64, bool, error) {
+	var lastID sql.NullInt64
+	query := fmt.Sprintf(`
+		SELECT MAX(id) FROM (
+			SELECT id FROM %s WHERE id > ? ORDER BY id LIMIT ?
+		) batch
+	`, table)
+	if err := db.conn.QueryRow(query, afterID, limit).Scan(&lastID); err != nil {
+		return 0, false, fmt.Errorf("failed to find the next metrics of %s: %w", table, err)
+	}
+
+	return lastID.Int64, lastID.Valid, nil
+}
+
+// partitionDefinitions renders specs followed by the MAXVALUE partition
+func partitionDefinitions(specs []PartitionSpec) string {
+	definitions := make([]string, 0, len(specs)+1)
+	for _, spec := range specs {
+		definitions = append(definitions, fmt.Sprintf("PARTITION %s VALUES LESS THAN (%d)", spec.Name, spec.LessThan.Unix()))
+	}
+	definitions = append(definitions, fmt.Sprintf("PARTITION %s VALUES LESS THAN MAXVALUE", MaxPartition))
+	return strings.Join(definitions, ", ")
+}
@@ -2,59 +2,99 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"strconv"
+	"math/rand"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/prometheus/common/model"
 	"github.com/samzong/prom-etl-db/internal/database"
 	"github.com/samzong/prom-etl-db/internal/logger"
+	"github.com/samzong/prom-etl-db/internal/metrics"
 	"github.com/samzong/prom-etl-db/internal/models"
 	"github.com/samzong/prom-etl-db/internal/prometheus"
+	"github.com/samzong/prom-etl-db/internal/relabel"
+	"github.com/samzong/prom-etl-db/internal/sink"
+)
+
+const (
+	// sourceLabel tags records of queries fanned out over several datasources
+	sourceLabel = "source"
+
+	defaultRetryInterval = 5 * time.Second
+	maxRetryDelay        = 5 * time.Minute
 )
 
 // Executor handles query execution and data storage
 type Executor struct {
-	promClient *prometheus.Client
-	db         *database.DB
-	logger     *slog.Logger
+	clients        *prometheus.Registry
+	db             *database.DB
+	sink           sink.Sink
+	metrics        *metrics.Metrics
+	defaultTimeout time.Duration
+	logger         *slog.Logger
 }
 
-// NewExecutor creates a new query executor
-func NewExecutor(promClient *prometheus.Client, db *database.DB, baseLogger *slog.Logger) *Executor {
+// NewExecutor creates a new query executor; m may be nil to disable instrumentation.
+// Queries run against their datasource's client in clients.
+// Metric records are written to s, execution records to db.
+// defaultTimeout applies to queries without a valid timeout of their own.
+func NewExecutor(clients *prometheus.Registry, db *database.DB, s sink.Sink, m *metrics.Metrics, defaultTimeout time.Duration, baseLogger *slog.Logger) *Executor {
 	return &Executor{
-		promClient: promClient,
-		db:         db,
-		logger:     logger.WithComponent(baseLogger, "executor"),
+		clients:        clients,
+		db:             db,
+		sink:           s,
+		metrics:        m,
+		defaultTimeout: defaultTimeout,
+		logger:         logger.WithComponent(baseLogger, "executor"),
 	}
 }
 
-// ExecuteQuery executes a single query and stores the results
-func (e *Executor) ExecuteQuery(ctx context.Context, queryConfig *models.QueryConfig) error {
+// ExecuteQuery executes a single query and stores the results.
+// evaluationTime is the logical time of the run (normally the scheduled fire
+// time); relative time expressions in the query's time range resolve against it.
+func (e *Executor) ExecuteQuery(ctx context.Context, queryConfig *models.QueryConfig, evaluationTime time.Time) error {
 	startTime := time.Now()
 	queryLogger := logger.WithQueryID(e.logger, queryConfig.ID)
 
 	// Create query execution record
 	execution := &models.QueryExecution{
-		QueryID:   queryConfig.ID,
-		QueryName: queryConfig.Name,
-		Status:    "running",
-		StartTime: startTime,
-		CreatedAt: startTime,
+		QueryID:        queryConfig.ID,
+		QueryName:      queryConfig.Name,
+		Status:         "running",
+		EvaluationTime: &evaluationTime,
+		StartTime:      startTime,
+		CreatedAt:      startTime,
+	}
+
+	// Record the running execution so sinks can reference its ID
+	if err := e.db.InsertQueryExecution(execution); err != nil {
+		logger.WithError(queryLogger, err).Warn("Failed to record running execution")
+	}
+
+	rules, err := relabel.Compile(queryConfig.RelabelConfigs)
+	if err != nil {
+		queryLogger.Error("Invalid relabel configuration", "error", err)
+		return e.failExecution(ctx, execution, metrics.StageParse, err)
+	}
+
+	sources := e.resolveSources(queryConfig.Datasource)
+	if len(sources) == 0 {
+		return e.failExecution(ctx, execution, metrics.StageQuery, fmt.Errorf("no datasource matches %q", queryConfig.Datasource))
 	}
 
 	queryLogger.Info("Starting query execution",
 		"query", queryConfig.Query,
 		"name", queryConfig.Name,
+		"datasources", sources,
+		"evaluation_time", evaluationTime.Format(time.RFC3339),
 	)
 
 	// Execute Prometheus query based on time range configuration
-	var response *models.PrometheusResponse
-	var err error
-
 	if queryConfig.TimeRange != nil {
-		// Use time range configuration
-		response, err = e.promClient.QueryWithTimeRange(ctx, queryConfig.Query, queryConfig.TimeRange)
 		queryLogger.Info("Executing query with time range",
 			"type", queryConfig.TimeRange.Type,
 			"time", queryConfig.TimeRange.Time,
@@ -62,158 +102,83 @@ func (e *Executor) ExecuteQuery(ctx context.Context, queryConfig *models.QueryCo
 			"end", queryConfig.TimeRange.End,
 		)
 	} else {
-		// Use default instant query
-		response, err = e.promClient.QueryInstant(ctx, queryConfig.Query)
-		queryLogger.Info("Executing instant query with current time")
+		queryLogger.Info("Executing instant query at evaluation time")
 	}
 
-	if err != nil {
-		// Record failure
-		execution.Status = "failed"
-		endTime := time.Now()
-		execution.EndTime = &endTime
-		duration := endTime.Sub(startTime).Milliseconds()
-		execution.DurationMs = &duration
-		errorMsg := err.Error()
-		execution.ErrorMessage = &errorMsg
-
-		// Log error
-		logger.WithError(queryLogger, err).Error("Query execution failed")
-
-		// Store execution record
-		if dbErr := e.db.InsertQueryExecution(execution); dbErr != nil {
-			logger.WithError(queryLogger, dbErr).Error("Failed to store execution record")
-		}
-
-		return fmt.Errorf("failed to execute query: %w", err)
+	// Query every datasource in parallel; when the query names several datasources
+	// or "*", every series is tagged with its source so series of different clusters
+	// stay apart, however many datasources the query currently resolves to
+	tag := fansOut(queryConfig.Datasource)
+	results := make([]sourceResult, len(sources))
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source string) {
+			defer wg.Done()
+			results[i] = e.querySource(ctx, queryConfig, source, tag, rules, evaluationTime)
+		}(i, source)
 	}
+	wg.Wait()
 
-	// Parse result based on result type
+	// Collect records of the sources that answered
 	var metricRecords []*models.MetricRecord
-
-	switch response.Data.ResultType {
-	case "vector":
-		// Parse vector result (instant queries)
-		vectorResult, err := response.ParseVectorResult()
-		if err != nil {
-			// Record failure
-			execution.Status = "failed"
-			endTime := time.Now()
-			execution.EndTime = &endTime
-			duration := endTime.Sub(startTime).Milliseconds()
-			execution.DurationMs = &duration
-			errorMsg := err.Error()
-			execution.ErrorMessage = &errorMsg
-
-			logger.WithError(queryLogger, err).Error("Failed to parse vector result")
-
-			// Store execution record
-			if dbErr := e.db.InsertQueryExecution(execution); dbErr != nil {
-				logger.WithError(queryLogger, dbErr).Error("Failed to store execution record")
+	var failures []error
+	failedStage := ""
+	for _, result := range results {
+		if result.err != nil {
+			logger.WithError(queryLogger, result.err).Error("Query execution failed", "datasource", result.source, "stage", result.stage)
+			failures = append(failures, fmt.Errorf("%s: %w", result.source, result.err))
+			if failedStage == "" {
+				failedStage = result.stage
 			}
-
-			return fmt.Errorf("failed to parse vector result: %w", err)
-		}
-
-		// Convert vector samples to metric records
-		for _, sample := range vectorResult {
-			record, err := e.convertSampleToRecord(&sample, queryConfig.ID, queryConfig.TimeRange)
-			if err != nil {
-				logger.WithError(queryLogger, err).Warn("Failed to convert sample to record, skipping")
-				continue
-			}
-			metricRecords = append(metricRecords, record)
+			continue
 		}
+		metricRecords = append(metricRecords, result.records...)
+	}
 
-	case "matrix":
-		// Parse matrix result (range queries)
-		matrixResult, err := response.ParseMatrixResult()
-		if err != nil {
-			// Record failure
-			execution.Status = "failed"
-			endTime := time.Now()
-			execution.EndTime = &endTime
-			duration := endTime.Sub(startTime).Milliseconds()
-			execution.DurationMs = &duration
-			errorMsg := err.Error()
-			execution.ErrorMessage = &errorMsg
-
-			logger.WithError(queryLogger, err).Error("Failed to parse matrix result")
-
-			// Store execution record
-			if dbErr := e.db.InsertQueryExecution(execution); dbErr != nil {
-				logger.WithError(queryLogger, dbErr).Error("Failed to store execution record")
-			}
-
-			return fmt.Errorf("failed to parse matrix result: %w", err)
+	if len(failures) == len(sources) {
+		err := errors.Join(failures...)
+		if len(sources) == 1 {
+			err = results[0].err
 		}
+		return e.failExecution(ctx, execution, failedStage, err)
+	}
 
-		// Convert matrix samples to metric records
-		for _, matrixSample := range matrixResult {
-			records, err := e.convertMatrixSampleToRecords(&matrixSample, queryConfig.ID, queryConfig.TimeRange)
-			if err != nil {
-				logger.WithError(queryLogger, err).Warn("Failed to convert matrix sample to records, skipping")
-				continue
-			}
-			metricRecords = append(metricRecords, records...)
-		}
-
-	default:
-		// Record failure
-		execution.Status = "failed"
-		endTime := time.Now()
-		execution.EndTime = &endTime
-		duration := endTime.Sub(startTime).Milliseconds()
-		execution.DurationMs = &duration
-		errorMsg := fmt.Sprintf("unsupported result type: %s", response.Data.ResultType)
-		execution.ErrorMessage = &errorMsg
-
-		queryLogger.Error("Unsupported result type", "error", fmt.Errorf(errorMsg))
-
-		// Store execution record
-		if dbErr := e.db.InsertQueryExecution(execution); dbErr != nil {
-			logger.WithError(queryLogger, dbErr).Error("Failed to store execution record")
+	// Series of several datasources are only kept apart by their source label
+	if tag {
+		if err := checkSourceLabels(metricRecords); err != nil {
+			return e.failExecution(ctx, execution, metrics.StageParse, err)
 		}
-
-		return fmt.Errorf("unsupported result type: %s", response.Data.ResultType)
 	}
 
 	// Store metric records
 	if len(metricRecords) > 0 {
-		if err := e.db.InsertMetricRecords(metricRecords); err != nil {
-			// Record failure
-			execution.Status = "failed"
-			endTime := time.Now()
-			execution.EndTime = &endTime
-			duration := endTime.Sub(startTime).Milliseconds()
-			execution.DurationMs = &duration
-			errorMsg := err.Error()
-			execution.ErrorMessage = &errorMsg
-
+		if err := e.sink.Write(ctx, execution, metricRecords); err != nil {
 			logger.WithError(queryLogger, err).Error("Failed to store metric records")
-
-			// Store execution record
-			if dbErr := e.db.InsertQueryExecution(execution); dbErr != nil {
-				logger.WithError(queryLogger, dbErr).Error("Failed to store execution record")
-			}
-
-			return fmt.Errorf("failed to store metric records: %w", err)
+			return e.failExecution(ctx, execution, metrics.StageStore, fmt.Errorf("failed to store metric records: %w", err))
 		}
 	}
 
-	// Record success
-	execution.Status = "success"
-	endTime := time.Now()
-	execution.EndTime = &endTime
-	duration := endTime.Sub(startTime).Milliseconds()
-	execution.DurationMs = &duration
 	execution.RecordsCount = len(metricRecords)
 
-	// Store execution record
-	if err := e.db.InsertQueryExecution(execution); err != nil {
-		logger.WithError(queryLogger, err).Error("Failed to store execution record")
+	// Record partial success when some datasources failed; their records are missing
+	if len(failures) > 0 {
+		for _, result := range results {
+			if result.err != nil {
+				e.metrics.IncFailure(execution.QueryID, result.stage)
+			}
+		}
+		duration := e.finishExecution(execution, "partial", errors.Join(failures...))
+		logger.WithDuration(
+			logger.WithCount(queryLogger, len(metricRecords)),
+			duration,
+		).Warn("Query execution partially succeeded", "failed_datasources", len(failures), "datasources", len(sources))
+		return nil
 	}
 
+	// Record success
+	duration := e.finishExecution(execution, "success", nil)
+
 	// Log success
 	logger.WithDuration(
 		logger.WithCount(queryLogger, len(metricRecords)),
@@ -223,164 +188,367 @@ func (e *Executor) ExecuteQuery(ctx context.Context, queryConfig *models.QueryCo
 	return nil
 }
 
-// convertSampleToRecord converts a VectorSample to MetricRecord
-func (e *Executor) convertSampleToRecord(sample *models.VectorSample, queryID string, timeRange *models.TimeRangeConfig) (*models.MetricRecord, error) {
-	// Extract metric name
-	metricName := sample.Metric["__name__"]
-	if metricName == "" {
-		metricName = queryID
-	}
-
-	// Parse timestamp and value
-	if len(sample.Value) != 2 {
-		return nil, fmt.Errorf("invalid sample value format")
-	}
+// sourceResult is the outcome of a query against one datasource
+type sourceResult struct {
+	source  string
+	records []*models.MetricRecord
+	// stage is the metrics stage that failed
+	stage string
+	err   error
+}
 
-	timestamp, ok := sample.Value[0].(float64)
-	if !ok {
-		return nil, fmt.Errorf("invalid timestamp format")
-	}
+// querySource runs the query against one datasource and converts the result,
+// applying rules and then adding a source label to every series when tag is set.
+// The label is added last, so relabeling cannot remove it.
+func (e *Executor) querySource(ctx context.Context, queryConfig *models.QueryConfig, source string, tag bool, rules []*relabel.Rule, evaluationTime time.Time) sourceResult {
+	result := sourceResult{source: source, stage: metrics.StageQuery}
 
-	valueStr, ok := sample.Value[1].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid value format")
+	promClient, err := e.clients.Client(source)
+	if err != nil {
+		result.err = err
+		return result
 	}
 
-	// Convert string value to float64
-	value, err := strconv.ParseFloat(valueStr, 64)
+	value, err := promClient.QueryWithTimeRange(ctx, queryConfig.Query, queryConfig.TimeRange, evaluationTime)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse value: %w", err)
+		result.err = fmt.Errorf("failed to execute query: %w", err)
+		return result
 	}
 
-	// Clean labels (remove internal labels)
-	labels := make(map[string]interface{})
-	for k, v := range sample.Metric {
-		if k != "__name__" {
-			labels[k] = v
+	// Convert typed result to metric records
+	collectedAt := time.Now()
+
+	switch value := value.(type) {
+	case model.Vector:
+		// Instant queries
+		kept := value[:0]
+		series := make([]model.Metric, 0, len(value))
+		for _, sample := range value {
+			if !relabel.Process(sample.Metric, rules) {
+				continue
+			}
+			if tag {
+				tagSource(sample.Metric, source)
+			}
+			kept = append(kept, sample)
+			series = append(series, sample.Metric)
 		}
+		if err := checkDuplicateSeries(series); err != nil {
+			result.stage = metrics.StageParse
+			result.err = err
+			return result
+		}
+		result.records = convertVector(kept, queryConfig.ID, vectorResultType(queryConfig.TimeRange), collectedAt)
+
+	case model.Matrix:
+		// Range queries
+		kept := value[:0]
+		series := make([]model.Metric, 0, len(value))
+		for _, stream := range value {
+			if !relabel.Process(stream.Metric, rules) {
+				continue
+			}
+			if tag {
+				tagSource(stream.Metric, source)
+			}
+			kept = append(kept, stream)
+			series = append(series, stream.Metric)
+		}
+		if err := checkDuplicateSeries(series); err != nil {
+			result.stage = metrics.StageParse
+			result.err = err
+			return result
+		}
+		result.records = convertMatrix(kept, queryConfig.ID, collectedAt)
+
+	case *model.Scalar:
+		// Single numeric value, e.g. scalar(sum(up)) or time()
+		result.records = []*models.MetricRecord{convertScalar(value, queryConfig.ID, collectedAt)}
+
+	case *model.String:
+		result.records = []*models.MetricRecord{convertString(value, queryConfig.ID, collectedAt)}
+
+	default:
+		result.stage = metrics.StageParse
+		result.err = fmt.Errorf("unsupported result type: %s", value.Type())
+		return result
 	}
 
-	// Determine result type
-	resultType := "instant"
-	if timeRange != nil && timeRange.Type == "range" {
-		resultType = "range"
+	for _, record := range result.records {
+		record.Source = source
+		// Scalars and strings have no series labels of their own
+		if tag && (record.ResultType == "scalar" || record.ResultType == "string") {
+			record.Labels[sourceLabel] = source
+		}
 	}
 
-	return &models.MetricRecord{
-		QueryID:     queryID,
-		MetricName:  metricName,
-		Labels:      labels,
-		Value:       value,
-		Timestamp:   time.Unix(int64(timestamp), 0),
-		ResultType:  resultType,
-		CollectedAt: time.Now(),
-	}, nil
+	return result
 }
 
-// convertMatrixSampleToRecords converts a MatrixSample to multiple MetricRecords
-func (e *Executor) convertMatrixSampleToRecords(matrixSample *models.MatrixSample, queryID string, timeRange *models.TimeRangeConfig) ([]*models.MetricRecord, error) {
-	// Extract metric name
-	metricName := matrixSample.Metric["__name__"]
-	if metricName == "" {
-		metricName = queryID
+// resolveSources returns the datasources of a query: a comma separated list of names,
+// "*" for every datasource of the datasources table, or the default endpoint when empty
+func (e *Executor) resolveSources(datasource string) []string {
+	if strings.TrimSpace(datasource) == "" {
+		return []string{prometheus.DefaultDatasource}
+	}
+
+	var sources []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(datasource, ",") {
+		name = strings.TrimSpace(name)
+		names := []string{name}
+		if name == "*" {
+			names = e.clients.Names()[1:]
+		}
+		for _, name := range names {
+			if name != "" && !seen[name] {
+				seen[name] = true
+				sources = append(sources, name)
+			}
+		}
 	}
+	return sources
+}
 
-	// Clean labels (remove internal labels)
-	labels := make(map[string]interface{})
-	for k, v := range matrixSample.Metric {
-		if k != "__name__" {
-			labels[k] = v
+// fansOut reports whether a datasource spec may name several datasources: "*"
+// or a comma separated list. Its series are always tagged with their source, so
+// they keep the same labels when datasources are added or removed.
+func fansOut(datasource string) bool {
+	names := 0
+	for _, name := range strings.Split(datasource, ",") {
+		name = strings.TrimSpace(name)
+		if name == "*" {
+			return true
+		}
+		if name != "" {
+			names++
 		}
 	}
+	return names > 1
+}
 
-	var records []*models.MetricRecord
+// tagSource adds a source label to a series. An existing source label of
+// the series is kept as exported_source, following Prometheus' convention.
+func tagSource(metric model.Metric, source string) {
+	if existing, ok := metric[sourceLabel]; ok {
+		metric[model.ExportedLabelPrefix+sourceLabel] = existing
+	}
+	metric[sourceLabel] = model.LabelValue(source)
+}
 
-	// Process each value in the matrix
-	for _, valueArray := range matrixSample.Values {
-		// Each valueArray should contain [timestamp, value]
-		if len(valueArray) != 2 {
-			e.logger.Warn("Invalid matrix value format, skipping",
-				"query_id", queryID,
-				"value_length", len(valueArray),
-			)
+// checkDuplicateSeries returns an error when several series have the same labels,
+// as relabeling can leave them when it drops or rewrites the labels that told them
+// apart. Their samples would overwrite each other in storage.
+func checkDuplicateSeries(series []model.Metric) error {
+	seen := make(map[model.Fingerprint]model.Metric, len(series))
+	duplicates := 0
+	var example model.Metric
+	for _, metric := range series {
+		fingerprint := metric.Fingerprint()
+		if existing, ok := seen[fingerprint]; ok && existing.Equal(metric) {
+			if duplicates == 0 {
+				example = metric
+			}
+			duplicates++
 			continue
 		}
+		seen[fingerprint] = metric
+	}
 
-		timestamp, ok := valueArray[0].(float64)
-		if !ok {
-			e.logger.Warn("Invalid timestamp format in matrix, skipping",
-				"query_id", queryID,
-				"timestamp_type", fmt.Sprintf("%T", valueArray[0]),
-			)
-			continue
-		}
+	if duplicates > 0 {
+		return fmt.Errorf("%d series have the same labels as another series after relabeling, e.g. %s", duplicates, example)
+	}
+	return nil
+}
 
-		valueStr, ok := valueArray[1].(string)
-		if !ok {
-			e.logger.Warn("Invalid value format in matrix, skipping",
-				"query_id", queryID,
-				"value_type", fmt.Sprintf("%T", valueArray[1]),
-			)
-			continue
+// checkSourceLabels returns an error when a record lacks the source label. Stored
+// samples are keyed by their labels but not by source, so without the label the
+// series of different datasources would overwrite each other.
+func checkSourceLabels(records []*models.MetricRecord) error {
+	for _, record := range records {
+		if _, ok := record.Labels[sourceLabel]; !ok {
+			return fmt.Errorf("record of %s from datasource %s lacks the %s label required for several datasources", record.MetricName, record.Source, sourceLabel)
 		}
+	}
+	return nil
+}
 
-		// Convert string value to float64
-		value, err := strconv.ParseFloat(valueStr, 64)
-		if err != nil {
-			e.logger.Warn("Failed to parse value in matrix, skipping",
-				"query_id", queryID,
-				"value", valueStr,
-				"error", err,
-			)
-			continue
-		}
+// failExecution records a failed execution at the given stage and returns err.
+// The execution is marked as timeout when ctx hit its deadline.
+func (e *Executor) failExecution(ctx context.Context, execution *models.QueryExecution, stage string, err error) error {
+	status := "failed"
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		status = "timeout"
+	}
+
+	e.metrics.IncFailure(execution.QueryID, stage)
+	e.finishExecution(execution, status, err)
+	return err
+}
+
+// finishExecution completes the execution record, stores it and returns its duration in milliseconds.
+// The running record is updated; it is inserted only if recording the start failed.
+func (e *Executor) finishExecution(execution *models.QueryExecution, status string, err error) int64 {
+	execution.Status = status
+	endTime := time.Now()
+	execution.EndTime = &endTime
+	duration := endTime.Sub(execution.StartTime).Milliseconds()
+	execution.DurationMs = &duration
+	if err != nil {
+		errorMsg := err.Error()
+		execution.ErrorMessage = &errorMsg
+	}
+
+	e.metrics.ObserveExecution(execution.QueryID, status, endTime.Sub(execution.StartTime), execution.RecordsCount)
+
+	// Store execution record
+	store := e.db.UpdateQueryExecution
+	if execution.ID == 0 {
+		store = e.db.InsertQueryExecution
+	}
+	if dbErr := store(execution); dbErr != nil {
+		logger.WithError(logger.WithQueryID(e.logger, execution.QueryID), dbErr).Error("Failed to store execution record")
+	}
+
+	return duration
+}
 
-		// Create metric record
-		record := &models.MetricRecord{
+// convertVector converts vector samples to metric records
+func convertVector(vector model.Vector, queryID, resultType string, collectedAt time.Time) []*models.MetricRecord {
+	records := make([]*models.MetricRecord, 0, len(vector))
+	for _, sample := range vector {
+		metricName, labels := seriesLabels(sample.Metric, queryID)
+		records = append(records, &models.MetricRecord{
 			QueryID:     queryID,
 			MetricName:  metricName,
 			Labels:      labels,
-			Value:       value,
-			Timestamp:   time.Unix(int64(timestamp), 0),
-			ResultType:  "range",
-			CollectedAt: time.Now(),
+			Value:       float64(sample.Value),
+			Timestamp:   sample.Timestamp.Time(),
+			ResultType:  resultType,
+			CollectedAt: collectedAt,
+		})
+	}
+	return records
+}
+
+// convertMatrix converts every point of every series to metric records
+func convertMatrix(matrix model.Matrix, queryID string, collectedAt time.Time) []*models.MetricRecord {
+	total := 0
+	for _, stream := range matrix {
+		total += len(stream.Values)
+	}
+
+	records := make([]*models.MetricRecord, 0, total)
+	for _, stream := range matrix {
+		// Points of a series share its name and labels
+		metricName, labels := seriesLabels(stream.Metric, queryID)
+		for _, pair := range stream.Values {
+			records = append(records, &models.MetricRecord{
+				QueryID:     queryID,
+				MetricName:  metricName,
+				Labels:      labels,
+				Value:       float64(pair.Value),
+				Timestamp:   pair.Timestamp.Time(),
+				ResultType:  "range",
+				CollectedAt: collectedAt,
+			})
 		}
+	}
+	return records
+}
+
+// convertScalar converts a scalar result to a single record named after the query
+func convertScalar(scalar *model.Scalar, queryID string, collectedAt time.Time) *models.MetricRecord {
+	return &models.MetricRecord{
+		QueryID:     queryID,
+		MetricName:  queryID,
+		Labels:      map[string]interface{}{},
+		Value:       float64(scalar.Value),
+		Timestamp:   scalar.Timestamp.Time(),
+		ResultType:  "scalar",
+		CollectedAt: collectedAt,
+	}
+}
 
-		records = append(records, record)
+// convertString converts a string result to a single record stored in string_value
+func convertString(str *model.String, queryID string, collectedAt time.Time) *models.MetricRecord {
+	value := str.Value
+	return &models.MetricRecord{
+		QueryID:     queryID,
+		MetricName:  queryID,
+		Labels:      map[string]interface{}{},
+		StringValue: &value,
+		Timestamp:   str.Timestamp.Time(),
+		ResultType:  "string",
+		CollectedAt: collectedAt,
 	}
+}
 
-	return records, nil
+// seriesLabels returns the metric name, falling back to queryID, and the labels without __name__
+func seriesLabels(metric model.Metric, queryID string) (string, map[string]interface{}) {
+	metricName := string(metric[model.MetricNameLabel])
+	if metricName == "" {
+		metricName = queryID
+	}
+
+	labels := make(map[string]interface{}, len(metric))
+	for k, v := range metric {
+		if k != model.MetricNameLabel {
+			labels[string(k)] = string(v)
+		}
+	}
+	return metricName, labels
 }
 
-// ExecuteQueryWithRetry executes a query with retry logic
-func (e *Executor) ExecuteQueryWithRetry(ctx context.Context, queryConfig *models.QueryConfig) error {
+// vectorResultType returns the result_type stored for vector samples
+func vectorResultType(timeRange *models.TimeRangeConfig) string {
+	if timeRange != nil && timeRange.Type == "range" {
+		return "range"
+	}
+	return "instant"
+}
+
+// ExecuteQueryWithRetry executes a query with retry logic; every attempt uses the same evaluation time.
+// Each attempt is bounded by the query's timeout and retries back off
+// exponentially from the query's retry interval with jitter.
+func (e *Executor) ExecuteQueryWithRetry(ctx context.Context, queryConfig *models.QueryConfig, evaluationTime time.Time) error {
 	var lastErr error
 
+	timeout := e.queryTimeout(queryConfig)
+	retryInterval, err := time.ParseDuration(queryConfig.RetryInterval)
+	if err != nil || retryInterval <= 0 {
+		retryInterval = defaultRetryInterval
+	}
+
 	for attempt := 0; attempt <= queryConfig.RetryCount; attempt++ {
 		if attempt > 0 {
-			// Parse retry interval
-			retryInterval, err := time.ParseDuration(queryConfig.RetryInterval)
-			if err != nil {
-				retryInterval = 5 * time.Second
-			}
+			delay := backoff(retryInterval, attempt)
 
 			e.logger.Info("Retrying query execution",
 				"query_id", queryConfig.ID,
 				"attempt", attempt,
-				"retry_interval", retryInterval,
+				"retry_delay", delay,
 			)
 
 			// Wait before retry
+			timer := time.NewTimer(delay)
 			select {
 			case <-ctx.Done():
+				timer.Stop()
 				return ctx.Err()
-			case <-time.After(retryInterval):
+			case <-timer.C:
 			}
 		}
 
-		// Execute query
-		if err := e.ExecuteQuery(ctx, queryConfig); err != nil {
+		// Execute query with its own deadline
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := e.ExecuteQuery(attemptCtx, queryConfig, evaluationTime)
+		cancel()
+		if err != nil {
 			lastErr = err
+			if ctx.Err() != nil {
+				return lastErr
+			}
 			continue
 		}
 
@@ -391,18 +559,52 @@ func (e *Executor) ExecuteQueryWithRetry(ctx context.Context, queryConfig *model
 	return fmt.Errorf("query failed after %d attempts: %w", queryConfig.RetryCount+1, lastErr)
 }
 
-// TestConnections tests both Prometheus and MySQL connections
+// queryTimeout returns the query's timeout or the executor default
+func (e *Executor) queryTimeout(queryConfig *models.QueryConfig) time.Duration {
+	if timeout, err := time.ParseDuration(queryConfig.Timeout); err == nil && timeout > 0 {
+		return timeout
+	}
+	return e.defaultTimeout
+}
+
+// backoff returns the delay before the given retry attempt (starting at 1):
+// base doubled per attempt, capped at maxRetryDelay, with half of it randomized.
+func backoff(base time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// TestConnections tests the Prometheus, MySQL and sink connections.
+// Only the default Prometheus endpoint is required; unreachable datasources are logged.
 func (e *Executor) TestConnections(ctx context.Context) error {
 	// Test Prometheus connection
-	if err := e.promClient.TestConnection(ctx); err != nil {
+	if err := e.clients.Default().TestConnection(ctx); err != nil {
 		return fmt.Errorf("prometheus connection test failed: %w", err)
 	}
+	for name, err := range e.clients.Ping(ctx) {
+		if err != nil && name != prometheus.DefaultDatasource {
+			e.logger.Warn("Datasource connection test failed", "datasource", name, "error", err)
+		}
+	}
 
 	// Test MySQL connection
 	if err := e.db.TestConnection(); err != nil {
 		return fmt.Errorf("mysql connection test failed: %w", err)
 	}
 
+	// Test sink connection
+	if err := e.sink.TestConnection(ctx); err != nil {
+		return fmt.Errorf("%s sink connection test failed: %w", e.sink.Name(), err)
+	}
+
 	e.logger.Info("All connections tested successfully")
 	return nil
 }
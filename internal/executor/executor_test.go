@@ -0,0 +1,391 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/samzong/prom-etl-db/internal/models"
+	"github.com/samzong/prom-etl-db/internal/prometheus"
+)
+
+// Sizes of the synthetic results, 100k samples each
+const (
+	benchVectorSamples  = 100000
+	benchMatrixSeries   = 1000
+	benchMatrixPoints   = 100
+	benchSeriesPerLabel = 50
+)
+
+// syntheticMetric returns the labels of the i-th synthetic series
+func syntheticMetric(i int) model.Metric {
+	return model.Metric{
+		model.MetricNameLabel: "node_cpu_seconds_total",
+		"instance":            model.LabelValue(fmt.Sprintf("node-%d:9100", i/benchSeriesPerLabel)),
+		"cpu":                 model.LabelValue(strconv.Itoa(i % benchSeriesPerLabel)),
+		"mode":                "idle",
+		"job":                 "node-exporter",
+	}
+}
+
+// syntheticVector returns a vector of n samples
+func syntheticVector(n int) model.Vector {
+	ts := model.TimeFromUnixNano(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	vector := make(model.Vector, n)
+	for i := range vector {
+		vector[i] = &model.Sample{
+			Metric:    syntheticMetric(i),
+			Value:     model.SampleValue(float64(i) * 1.25),
+			Timestamp: ts,
+		}
+	}
+	return vector
+}
+
+// syntheticMatrix returns a matrix of series with points each, 15s apart
+func syntheticMatrix(series, points int) model.Matrix {
+	start := model.TimeFromUnixNano(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	matrix := make(model.Matrix, series)
+	for i := range matrix {
+		values := make([]model.SamplePair, points)
+		for j := range values {
+			values[j] = model.SamplePair{
+				Timestamp: start.Add(time.Duration(j) * 15 * time.Second),
+				Value:     model.SampleValue(float64(i*points+j) / 3),
+			}
+		}
+		matrix[i] = &model.SampleStream{Metric: syntheticMetric(i), Values: values}
+	}
+	return matrix
+}
+
+// The legacy* helpers reproduce the conversion removed from the client and the
+// executor: results were copied into []interface{}, marshalled to JSON, unmarshalled
+// into string based samples and parsed again. They are kept for the benchmarks only.
+
+type legacyVectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+}
+
+type legacyMatrixSample struct {
+	Metric map[string]string `json:"metric"`
+	Values [][]interface{}   `json:"values"`
+}
+
+func legacyUnixSeconds(t model.Time) float64 {
+	return float64(t) / 1000
+}
+
+func legacyTime(seconds float64) time.Time {
+	return time.UnixMilli(int64(math.Round(seconds * 1000)))
+}
+
+func legacyLabels(metric map[string]string) map[string]interface{} {
+	labels := make(map[string]interface{})
+	for k, v := range metric {
+		if k != "__name__" {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+func legacyConvertVector(vector model.Vector, queryID string, collectedAt time.Time) ([]*models.MetricRecord, error) {
+	result := make([]interface{}, len(vector))
+	for i, sample := range vector {
+		result[i] = map[string]interface{}{
+			"metric": sample.Metric,
+			"value":  []interface{}{legacyUnixSeconds(sample.Timestamp), sample.Value.String()},
+		}
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	var samples []legacyVectorSample
+	if err := json.Unmarshal(resultBytes, &samples); err != nil {
+		return nil, err
+	}
+
+	var records []*models.MetricRecord
+	for _, sample := range samples {
+		metricName := sample.Metric["__name__"]
+		if metricName == "" {
+			metricName = queryID
+		}
+		value, err := strconv.ParseFloat(sample.Value[1].(string), 64)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &models.MetricRecord{
+			QueryID:     queryID,
+			MetricName:  metricName,
+			Labels:      legacyLabels(sample.Metric),
+			Value:       value,
+			Timestamp:   legacyTime(sample.Value[0].(float64)),
+			ResultType:  "instant",
+			CollectedAt: collectedAt,
+		})
+	}
+	return records, nil
+}
+
+func legacyConvertMatrix(matrix model.Matrix, queryID string, collectedAt time.Time) ([]*models.MetricRecord, error) {
+	result := make([]interface{}, len(matrix))
+	for i, stream := range matrix {
+		values := make([]interface{}, len(stream.Values))
+		for j, pair := range stream.Values {
+			values[j] = []interface{}{legacyUnixSeconds(pair.Timestamp), pair.Value.String()}
+		}
+		result[i] = map[string]interface{}{
+			"metric": stream.Metric,
+			"values": values,
+		}
+	}
+
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	var samples []legacyMatrixSample
+	if err := json.Unmarshal(resultBytes, &samples); err != nil {
+		return nil, err
+	}
+
+	var records []*models.MetricRecord
+	for _, sample := range samples {
+		metricName := sample.Metric["__name__"]
+		if metricName == "" {
+			metricName = queryID
+		}
+		labels := legacyLabels(sample.Metric)
+		for _, pair := range sample.Values {
+			value, err := strconv.ParseFloat(pair[1].(string), 64)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, &models.MetricRecord{
+				QueryID:     queryID,
+				MetricName:  metricName,
+				Labels:      labels,
+				Value:       value,
+				Timestamp:   legacyTime(pair[0].(float64)),
+				ResultType:  "range",
+				CollectedAt: collectedAt,
+			})
+		}
+	}
+	return records, nil
+}
+
+// TestConvertMatchesLegacy checks that the typed conversion produces the records of the removed path
+func TestConvertMatchesLegacy(t *testing.T) {
+	collectedAt := time.Now()
+
+	vector := syntheticVector(200)
+	want, err := legacyConvertVector(vector, "q", collectedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := convertVector(vector, "q", "instant", collectedAt); !reflect.DeepEqual(got, want) {
+		t.Errorf("convertVector differs from the legacy conversion")
+	}
+
+	matrix := syntheticMatrix(20, 10)
+	want, err = legacyConvertMatrix(matrix, "q", collectedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := convertMatrix(matrix, "q", collectedAt); !reflect.DeepEqual(got, want) {
+		t.Errorf("convertMatrix differs from the legacy conversion")
+	}
+}
+
+func TestConvertFallsBackToQueryID(t *testing.T) {
+	vector := model.Vector{{Metric: model.Metric{"job": "a"}, Value: 1, Timestamp: 1500}}
+	records := convertVector(vector, "my_query", "instant", time.Now())
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	record := records[0]
+	if record.MetricName != "my_query" {
+		t.Errorf("metric name = %q, want my_query", record.MetricName)
+	}
+	if !record.Timestamp.Equal(time.UnixMilli(1500)) {
+		t.Errorf("timestamp = %v, want millisecond precision", record.Timestamp)
+	}
+	if _, ok := record.Labels[string(model.MetricNameLabel)]; ok {
+		t.Errorf("labels contain __name__")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		base    time.Duration
+		attempt int
+		// the delay is randomized within [max/2, max]
+		max time.Duration
+	}{
+		{10 * time.Second, 1, 10 * time.Second},
+		{10 * time.Second, 2, 20 * time.Second},
+		{10 * time.Second, 4, 80 * time.Second},
+		{10 * time.Second, 10, maxRetryDelay},
+		{10 * time.Minute, 1, maxRetryDelay},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			delay := backoff(tt.base, tt.attempt)
+			if delay < tt.max/2 || delay > tt.max {
+				t.Fatalf("backoff(%s, %d) = %s, want within [%s, %s]", tt.base, tt.attempt, delay, tt.max/2, tt.max)
+			}
+		}
+	}
+
+	// The jitter spreads retries of queries failing together
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		seen[backoff(10*time.Second, 1)] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("backoff is not randomized")
+	}
+}
+
+func BenchmarkConvertVector(b *testing.B) {
+	vector := syntheticVector(benchVectorSamples)
+	collectedAt := time.Now()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		convertVector(vector, "bench", "instant", collectedAt)
+	}
+}
+
+func BenchmarkConvertVectorLegacy(b *testing.B) {
+	vector := syntheticVector(benchVectorSamples)
+	collectedAt := time.Now()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := legacyConvertVector(vector, "bench", collectedAt); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConvertMatrix(b *testing.B) {
+	matrix := syntheticMatrix(benchMatrixSeries, benchMatrixPoints)
+	collectedAt := time.Now()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		convertMatrix(matrix, "bench", collectedAt)
+	}
+}
+
+func BenchmarkConvertMatrixLegacy(b *testing.B) {
+	matrix := syntheticMatrix(benchMatrixSeries, benchMatrixPoints)
+	collectedAt := time.Now()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := legacyConvertMatrix(matrix, "bench", collectedAt); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCheckSourceLabels(t *testing.T) {
+	tagged := &models.MetricRecord{MetricName: "up", Source: "a", Labels: map[string]interface{}{sourceLabel: "a"}}
+	untagged := &models.MetricRecord{MetricName: "up", Source: "b", Labels: map[string]interface{}{"job": "node"}}
+
+	if err := checkSourceLabels([]*models.MetricRecord{tagged}); err != nil {
+		t.Errorf("tagged records: %v", err)
+	}
+	if err := checkSourceLabels([]*models.MetricRecord{tagged, untagged}); err == nil {
+		t.Errorf("record without source label accepted")
+	}
+}
+
+func TestCheckDuplicateSeries(t *testing.T) {
+	unique := []model.Metric{
+		{"__name__": "up", "job": "a"},
+		{"__name__": "up", "job": "b"},
+	}
+	if err := checkDuplicateSeries(unique); err != nil {
+		t.Errorf("unique series: %v", err)
+	}
+
+	// e.g. after a labeldrop of the label that told them apart
+	duplicated := append(unique, model.Metric{"__name__": "up", "job": "a"}, model.Metric{"__name__": "up", "job": "b"})
+	err := checkDuplicateSeries(duplicated)
+	if err == nil || !strings.HasPrefix(err.Error(), "2 series") {
+		t.Errorf("err = %v, want 2 duplicated series", err)
+	}
+}
+
+func TestFansOut(t *testing.T) {
+	tests := map[string]bool{
+		"":        false,
+		"prod":    false,
+		" prod, ": false,
+		"*":       true,
+		"prod,*":  true,
+		"a,b":     true,
+		"a, b,":   true,
+	}
+	for datasource, want := range tests {
+		if got := fansOut(datasource); got != want {
+			t.Errorf("fansOut(%q) = %v, want %v", datasource, got, want)
+		}
+	}
+}
+
+// A "*" query keeps its source label while it matches a single datasource
+func TestQuerySourceTagsSingleWildcardDatasource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"up","job":"node"},"value":[1700000000,"1"]}]}}`)
+	}))
+	defer server.Close()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	clients := prometheus.NewRegistry(nil, func() ([]models.Datasource, error) {
+		return []models.Datasource{{Name: "prod", Prometheus: models.PrometheusConfig{URL: server.URL}}}, nil
+	}, log)
+	if err := clients.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	e := &Executor{clients: clients, logger: log}
+
+	query := &models.QueryConfig{ID: "up", Query: "up", Datasource: "*"}
+	sources := e.resolveSources(query.Datasource)
+	if len(sources) != 1 || sources[0] != "prod" {
+		t.Fatalf("sources = %v, want [prod]", sources)
+	}
+
+	result := e.querySource(context.Background(), query, sources[0], fansOut(query.Datasource), nil, time.Now())
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	if len(result.records) != 1 || result.records[0].Labels[sourceLabel] != "prod" {
+		t.Fatalf("records = %+v, want one record with source prod", result.records)
+	}
+	if err := checkSourceLabels(result.records); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,293 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/logger"
+	"github.com/samzong/prom-etl-db/internal/metrics"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// Overlap policies applied when a query is triggered while its previous run is still queued or running
+const (
+	// OverlapSkip drops the new trigger, like cron's SkipIfStillRunning
+	OverlapSkip = "skip"
+	// OverlapQueue keeps the latest trigger and runs it once the previous run finishes
+	OverlapQueue = "queue"
+)
+
+// Reasons reported when a trigger is not executed
+const (
+	skipReasonOverlap   = "overlap"
+	skipReasonQueueFull = "queue_full"
+	skipReasonStopped   = "stopped"
+)
+
+// Pool executes queries on a bounded number of workers fed by a bounded queue.
+// At most one run per query is queued or running at any time.
+type Pool struct {
+	// execute runs a query with its retry policy; tests replace it
+	execute func(ctx context.Context, query *models.QueryConfig, evaluationTime time.Time) error
+	policy  string
+	workers int
+	tasks   chan *task
+	metrics *metrics.Metrics
+	logger  *slog.Logger
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	busy     atomic.Int64
+	stopping chan struct{}
+	waiting  sync.WaitGroup
+
+	mu       sync.Mutex
+	stopped  bool
+	inFlight map[string]*queryState
+}
+
+// task is a single query run waiting for a worker
+type task struct {
+	query          models.QueryConfig
+	evaluationTime time.Time
+}
+
+// queryState tracks the active run of a query and at most one follow-up run
+type queryState struct {
+	pending *task
+}
+
+// NewPool creates a worker pool; call Start before submitting work
+func NewPool(exec *Executor, workers, queueSize int, policy string, m *metrics.Metrics, baseLogger *slog.Logger) (*Pool, error) {
+	if workers <= 0 {
+		return nil, fmt.Errorf("worker pool size must be positive, got %d", workers)
+	}
+	if queueSize < 0 {
+		return nil, fmt.Errorf("worker queue size must not be negative, got %d", queueSize)
+	}
+	if policy != OverlapSkip && policy != OverlapQueue {
+		return nil, fmt.Errorf("unknown overlap policy: %s", policy)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{
+		execute:  exec.ExecuteQueryWithRetry,
+		policy:   policy,
+		workers:  workers,
+		tasks:    make(chan *task, queueSize),
+		metrics:  m,
+		logger:   logger.WithComponent(baseLogger, "worker-pool"),
+		ctx:      ctx,
+		cancel:   cancel,
+		stopping: make(chan struct{}),
+		inFlight: make(map[string]*queryState),
+	}
+
+	m.RegisterWorkerPool(workers,
+		func() int { return len(p.tasks) },
+		func() int { return int(p.busy.Load()) },
+	)
+
+	return p, nil
+}
+
+// Start launches the workers
+func (p *Pool) Start() {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+	p.logger.Info("Worker pool started",
+		"workers", p.workers,
+		"queue_size", cap(p.tasks),
+		"overlap_policy", p.policy)
+}
+
+// Submit queues a run of query evaluated at evaluationTime.
+// It returns false when the run was skipped because of the overlap policy,
+// a full queue or a stopped pool.
+func (p *Pool) Submit(query models.QueryConfig, evaluationTime time.Time) bool {
+	return p.submit(context.Background(), query, evaluationTime, false)
+}
+
+// SubmitWait is like Submit, but waits for room in a full queue instead of skipping
+// the run; it gives up when ctx is done or the pool stops. It is used for the initial run of
+// all queries, which may outnumber the workers and the queue.
+func (p *Pool) SubmitWait(ctx context.Context, query models.QueryConfig, evaluationTime time.Time) bool {
+	return p.submit(ctx, query, evaluationTime, true)
+}
+
+// submit implements Submit and SubmitWait
+func (p *Pool) submit(ctx context.Context, query models.QueryConfig, evaluationTime time.Time, wait bool) bool {
+	p.mu.Lock()
+
+	t := &task{query: query, evaluationTime: evaluationTime}
+
+	if p.stopped {
+		p.mu.Unlock()
+		p.skip(t, skipReasonStopped)
+		return false
+	}
+
+	if state, ok := p.inFlight[query.ID]; ok {
+		defer p.mu.Unlock()
+		if p.policy == OverlapSkip {
+			p.skip(t, skipReasonOverlap)
+			return false
+		}
+		if state.pending != nil {
+			p.skip(state.pending, skipReasonOverlap)
+		}
+		state.pending = t
+		p.logger.Info("Previous run still in progress, queued follow-up run",
+			"query_id", query.ID,
+			"evaluation_time", evaluationTime.Format(time.RFC3339))
+		return true
+	}
+
+	if !wait {
+		defer p.mu.Unlock()
+		if !p.enqueue(t) {
+			return false
+		}
+		p.inFlight[query.ID] = &queryState{}
+		return true
+	}
+
+	// The query counts as in flight while waiting, so overlapping triggers follow
+	// the overlap policy; Stop closes the queue only once no submit is waiting
+	p.inFlight[query.ID] = &queryState{}
+	p.waiting.Add(1)
+	p.mu.Unlock()
+	defer p.waiting.Done()
+
+	select {
+	case p.tasks <- t:
+		return true
+	case <-ctx.Done():
+	case <-p.stopping:
+	}
+
+	p.skip(t, skipReasonStopped)
+	p.finish(t)
+	return false
+}
+
+// Stop stops accepting work, drops queued runs and waits for running ones.
+// When ctx is done first, running executions are cancelled.
+func (p *Pool) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	first := !p.stopped
+	p.stopped = true
+	p.mu.Unlock()
+
+	if first {
+		// Waiting submits give up before the queue is closed
+		close(p.stopping)
+		p.waiting.Wait()
+		close(p.tasks)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-done
+		return fmt.Errorf("worker pool stop timed out: %w", ctx.Err())
+	}
+}
+
+// worker executes tasks until the queue is closed
+func (p *Pool) worker() {
+	defer p.wg.Done()
+
+	for t := range p.tasks {
+		if p.isStopped() {
+			p.skip(t, skipReasonStopped)
+			p.finish(t)
+			continue
+		}
+
+		p.busy.Add(1)
+		p.run(t)
+		p.busy.Add(-1)
+		p.finish(t)
+	}
+}
+
+// run executes a single task with the query's retry policy
+func (p *Pool) run(t *task) {
+	q := t.query
+	if err := p.execute(p.ctx, &q, t.evaluationTime); err != nil {
+		p.logger.Error("Query execution failed",
+			"query_id", q.ID,
+			"evaluation_time", t.evaluationTime.Format(time.RFC3339),
+			"error", err)
+		return
+	}
+	p.logger.Info("Query executed successfully",
+		"query_id", q.ID,
+		"evaluation_time", t.evaluationTime.Format(time.RFC3339))
+}
+
+// finish releases the query slot or starts its queued follow-up run
+func (p *Pool) finish(t *task) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, ok := p.inFlight[t.query.ID]
+	if !ok {
+		return
+	}
+
+	next := state.pending
+	state.pending = nil
+	if next != nil {
+		if p.stopped {
+			p.skip(next, skipReasonStopped)
+		} else if p.enqueue(next) {
+			return
+		}
+	}
+	delete(p.inFlight, t.query.ID)
+}
+
+// enqueue adds t to the queue without blocking; callers must hold p.mu
+func (p *Pool) enqueue(t *task) bool {
+	select {
+	case p.tasks <- t:
+		return true
+	default:
+		p.skip(t, skipReasonQueueFull)
+		return false
+	}
+}
+
+// skip records a run that will not be executed
+func (p *Pool) skip(t *task, reason string) {
+	p.metrics.IncSkipped(t.query.ID, reason)
+	p.logger.Warn("Skipping query run",
+		"query_id", t.query.ID,
+		"evaluation_time", t.evaluationTime.Format(time.RFC3339),
+		"reason", reason)
+}
+
+// isStopped reports whether Stop has been called
+func (p *Pool) isStopped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stopped
+}
@@ -0,0 +1,215 @@
+package executor
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/metrics"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// fakeRuns replaces query execution: each run reports "id@HH:MM" on started and
+// blocks until it receives from release or the pool cancels it
+type fakeRuns struct {
+	started chan string
+	release chan struct{}
+
+	mu  sync.Mutex
+	ran []string
+}
+
+func newTestPool(t *testing.T, workers, queueSize int, policy string) (*Pool, *fakeRuns) {
+	t.Helper()
+	p, err := NewPool(nil, workers, queueSize, policy, metrics.NewMetrics(nil, ""), slog.Default())
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+
+	runs := &fakeRuns{started: make(chan string, 16), release: make(chan struct{})}
+	p.execute = func(ctx context.Context, query *models.QueryConfig, evaluationTime time.Time) error {
+		name := query.ID + "@" + evaluationTime.Format("15:04")
+		runs.started <- name
+		select {
+		case <-runs.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		runs.mu.Lock()
+		runs.ran = append(runs.ran, name)
+		runs.mu.Unlock()
+		return nil
+	}
+	p.Start()
+	return p, runs
+}
+
+// waitStarted waits until the run named want has started
+func (r *fakeRuns) waitStarted(t *testing.T, want string) {
+	t.Helper()
+	select {
+	case name := <-r.started:
+		if name != want {
+			t.Fatalf("started %s, want %s", name, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not start", want)
+	}
+}
+
+func (r *fakeRuns) completed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ran
+}
+
+// skipped returns the skipped runs of the pool with reason
+func skipped(t *testing.T, p *Pool, reason string) float64 {
+	t.Helper()
+	families, err := p.metrics.Registry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total float64
+	for _, family := range families {
+		if family.GetName() != "prom_etl_query_skipped_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "reason" && label.GetValue() == reason {
+					total += metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return total
+}
+
+func at(minute int) time.Time {
+	return time.Date(2024, 3, 1, 10, minute, 0, 0, time.UTC)
+}
+
+func TestPoolSkipsOverlappingRun(t *testing.T) {
+	p, runs := newTestPool(t, 2, 4, OverlapSkip)
+	q := models.QueryConfig{ID: "q"}
+
+	if !p.Submit(q, at(0)) {
+		t.Fatal("first run skipped")
+	}
+	runs.waitStarted(t, "q@10:00")
+	if p.Submit(q, at(1)) {
+		t.Error("overlapping run accepted")
+	}
+
+	runs.release <- struct{}{}
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if got, want := runs.completed(), []string{"q@10:00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ran %v, want %v", got, want)
+	}
+	if got := skipped(t, p, skipReasonOverlap); got != 1 {
+		t.Errorf("overlap skips = %v, want 1", got)
+	}
+}
+
+func TestPoolQueuesLatestOverlappingRun(t *testing.T) {
+	p, runs := newTestPool(t, 2, 4, OverlapQueue)
+	q := models.QueryConfig{ID: "q"}
+
+	p.Submit(q, at(0))
+	runs.waitStarted(t, "q@10:00")
+	// Only the latest follow-up is kept; 10:01 is replaced by 10:02
+	if !p.Submit(q, at(1)) || !p.Submit(q, at(2)) {
+		t.Fatal("follow-up run skipped")
+	}
+
+	runs.release <- struct{}{}
+	runs.waitStarted(t, "q@10:02")
+	runs.release <- struct{}{}
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if got, want := runs.completed(), []string{"q@10:00", "q@10:02"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ran %v, want %v", got, want)
+	}
+	if got := skipped(t, p, skipReasonOverlap); got != 1 {
+		t.Errorf("overlap skips = %v, want 1", got)
+	}
+}
+
+func TestPoolQueueFull(t *testing.T) {
+	p, runs := newTestPool(t, 1, 1, OverlapSkip)
+
+	p.Submit(models.QueryConfig{ID: "a"}, at(0))
+	runs.waitStarted(t, "a@10:00")
+	if !p.Submit(models.QueryConfig{ID: "b"}, at(0)) {
+		t.Fatal("run skipped with room in the queue")
+	}
+	if p.Submit(models.QueryConfig{ID: "c"}, at(0)) {
+		t.Fatal("run accepted with a full queue")
+	}
+	if got := skipped(t, p, skipReasonQueueFull); got != 1 {
+		t.Errorf("queue_full skips = %v, want 1", got)
+	}
+
+	// SubmitWait waits for room instead of skipping
+	accepted := make(chan bool)
+	go func() {
+		accepted <- p.SubmitWait(context.Background(), models.QueryConfig{ID: "c"}, at(0))
+	}()
+	runs.release <- struct{}{}
+	runs.waitStarted(t, "b@10:00")
+	runs.release <- struct{}{}
+	if !<-accepted {
+		t.Fatal("SubmitWait skipped the run")
+	}
+	runs.waitStarted(t, "c@10:00")
+	runs.release <- struct{}{}
+
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if got, want := runs.completed(), []string{"a@10:00", "b@10:00", "c@10:00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ran %v, want %v", got, want)
+	}
+}
+
+func TestPoolStop(t *testing.T) {
+	p, runs := newTestPool(t, 1, 1, OverlapQueue)
+
+	p.Submit(models.QueryConfig{ID: "a"}, at(0))
+	runs.waitStarted(t, "a@10:00")
+	p.Submit(models.QueryConfig{ID: "a"}, at(1))
+	p.Submit(models.QueryConfig{ID: "b"}, at(0))
+
+	accepted := make(chan bool)
+	go func() {
+		accepted <- p.SubmitWait(context.Background(), models.QueryConfig{ID: "c"}, at(0))
+	}()
+
+	// The running execution never finishes, so Stop times out and cancels it
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Stop(ctx); err == nil {
+		t.Error("Stop did not time out")
+	}
+	if <-accepted {
+		t.Error("SubmitWait accepted a run during Stop")
+	}
+	if p.Submit(models.QueryConfig{ID: "d"}, at(0)) {
+		t.Error("run accepted after Stop")
+	}
+
+	if got := runs.completed(); len(got) != 0 {
+		t.Errorf("ran %v, want none", got)
+	}
+	// The follow-up of a, queued b, waiting c and d after Stop
+	if got := skipped(t, p, skipReasonStopped); got != 4 {
+		t.Errorf("stopped skips = %v, want 4", got)
+	}
+}
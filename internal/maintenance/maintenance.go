@@ -0,0 +1,389 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/robfig/cron/v3"
+	"github.com/samzong/prom-etl-db/internal/database"
+	"github.com/samzong/prom-etl-db/internal/logger"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// day is the rollup chunk size; the raw cutoff of downsampled queries is aligned to it
+const day = 24 * time.Hour
+
+// QueryLoader loads the query configurations whose data is maintained
+type QueryLoader func() ([]models.QueryConfig, error)
+
+// Policy is a parsed retention policy; zero durations keep data forever
+type Policy struct {
+	Raw        time.Duration
+	Downsample []string
+	Rollup     time.Duration
+}
+
+// ParseRetention parses a retention duration such as 30d or 12w; empty means forever
+func ParseRetention(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	duration, err := model.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid retention %q", value)
+	}
+	return time.Duration(duration), nil
+}
+
+// ParsePolicy validates a query's retention configuration, using defaultRaw
+// when it has no raw retention of its own
+func ParsePolicy(cfg *models.RetentionConfig, defaultRaw time.Duration) (Policy, error) {
+	policy := Policy{Raw: defaultRaw}
+	if cfg == nil {
+		return policy, nil
+	}
+
+	if cfg.Raw != "" {
+		raw, err := ParseRetention(cfg.Raw)
+		if err != nil {
+			return Policy{}, err
+		}
+		policy.Raw = raw
+	}
+
+	rollup, err := ParseRetention(cfg.Rollup)
+	if err != nil {
+		return Policy{}, err
+	}
+	policy.Rollup = rollup
+
+	// Keep the finest-first order of RollupIntervals, whatever the configured order
+	for _, interval := range database.RollupIntervals() {
+		for _, configured := range cfg.Downsample {
+			if configured == interval {
+				policy.Downsample = append(policy.Downsample, interval)
+				break
+			}
+		}
+	}
+	if len(policy.Downsample) != len(cfg.Downsample) {
+		return Policy{}, fmt.Errorf("invalid downsample %v: intervals must be distinct and one of %v", cfg.Downsample, database.RollupIntervals())
+	}
+
+	return policy, nil
+}
+
+// Runner applies the retention policies of the queries to metrics_data and the rollup tables
+type Runner struct {
+	db               *database.DB
+	load             QueryLoader
+	defaultRetention time.Duration
+	batchSize        int
+	partitions       *PartitionManager
+	logger           *slog.Logger
+
+	// running prevents overlapping runs
+	running sync.Mutex
+}
+
+// NewRunner creates a maintenance runner; it manages the partitions of
+// metrics_data when a partition interval is configured
+func NewRunner(db *database.DB, load QueryLoader, cfg models.MaintenanceConfig, baseLogger *slog.Logger) (*Runner, error) {
+	defaultRetention, err := ParseRetention(cfg.DefaultRetention)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default retention: %w", err)
+	}
+	if cfg.BatchSize <= 0 {
+		return nil, fmt.Errorf("maintenance batch size must be positive, got %d", cfg.BatchSize)
+	}
+
+	partitions, err := NewPartitionManager(db, cfg, baseLogger)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Runner{
+		db:               db,
+		load:             load,
+		defaultRetention: defaultRetention,
+		batchSize:        cfg.BatchSize,
+		partitions:       partitions,
+		logger:           logger.WithComponent(baseLogger, "maintenance"),
+	}, nil
+}
+
+// EnsurePartitions creates the upcoming partitions of metrics_data, if partitioning is enabled
+func (r *Runner) EnsurePartitions() error {
+	if r.partitions == nil {
+		return nil
+	}
+	return r.partitions.Ensure(time.Now())
+}
+
+// RunLoop runs the maintenance at every fire time of schedule until ctx is done
+func (r *Runner) RunLoop(ctx context.Context, schedule cron.Schedule) {
+	for {
+		timer := time.NewTimer(time.Until(schedule.Next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := r.Run(ctx); err != nil {
+			logger.WithError(r.logger, err).Error("Maintenance run failed")
+		}
+	}
+}
+
+// Run applies the retention policy of every query, then drops expired partitions
+// and creates upcoming ones. A run that starts while the previous one is still
+// running is skipped.
+func (r *Runner) Run(ctx context.Context) error {
+	if !r.running.TryLock() {
+		r.logger.Warn("Previous maintenance run still running, skipping")
+		return nil
+	}
+	defer r.running.Unlock()
+
+	queries, err := r.load()
+	if err != nil {
+		return fmt.Errorf("failed to load queries: %w", err)
+	}
+
+	now := time.Now()
+
+	// Samples of the partitions dropped by this run are rolled up first
+	var errs []error
+	var rollupFailed bool
+	var expired []database.Partition
+	var dropBefore time.Time
+	if r.partitions != nil {
+		expired, err = r.partitions.Expired(now)
+		if err != nil {
+			errs = append(errs, err)
+		} else if len(expired) > 0 {
+			dropBefore = expired[len(expired)-1].LessThan
+		}
+	}
+
+	for _, query := range queries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		policy, err := r.Policy(query)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("query %s: %w", query.ID, err))
+			continue
+		}
+		rollupBeforeDrop := !dropBefore.IsZero() && len(policy.Downsample) > 0
+		if policy.Raw == 0 && policy.Rollup == 0 && !rollupBeforeDrop {
+			continue
+		}
+
+		if _, err := r.runQuery(ctx, query.ID, policy, dropBefore); err != nil {
+			errs = append(errs, fmt.Errorf("query %s: %w", query.ID, err))
+			rollupFailed = rollupFailed || rollupBeforeDrop
+		}
+	}
+
+	if r.partitions != nil {
+		// A failed query may not have been rolled up; the partitions are dropped by a later run
+		if rollupFailed {
+			r.logger.Warn("Keeping expired partitions after failed maintenance", "partitions", len(expired))
+		} else if err := r.partitions.Drop(expired); err != nil {
+			errs = append(errs, err)
+		}
+
+		if err := r.partitions.Ensure(now); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Policy returns the retention policy of query, falling back to the default retention
+func (r *Runner) Policy(query models.QueryConfig) (Policy, error) {
+	return ParsePolicy(query.Retention, r.defaultRetention)
+}
+
+// RunQuery applies policy to the data of one query and records the run in maintenance_runs
+func (r *Runner) RunQuery(ctx context.Context, queryID string, policy Policy) (*models.MaintenanceRun, error) {
+	return r.runQuery(ctx, queryID, policy, time.Time{})
+}
+
+// runQuery runs RunQuery, also rolling up the samples before dropBefore when it is set
+func (r *Runner) runQuery(ctx context.Context, queryID string, policy Policy, dropBefore time.Time) (*models.MaintenanceRun, error) {
+	queryLogger := logger.WithQueryID(r.logger, queryID)
+
+	now := time.Now()
+	run := &models.MaintenanceRun{
+		QueryID:   queryID,
+		Status:    "running",
+		StartTime: now,
+		CreatedAt: now,
+	}
+	if err := r.db.InsertMaintenanceRun(run); err != nil {
+		queryLogger.Warn("Failed to record maintenance run start", "error", err)
+	}
+
+	err := r.apply(ctx, run, policy, now, dropBefore)
+
+	endTime := time.Now()
+	duration := endTime.Sub(run.StartTime).Milliseconds()
+	run.EndTime = &endTime
+	run.DurationMs = &duration
+	run.Status = "success"
+	if err != nil {
+		run.Status = "failed"
+		errMsg := err.Error()
+		run.ErrorMessage = &errMsg
+	}
+
+	var saveErr error
+	if run.ID != 0 {
+		saveErr = r.db.UpdateMaintenanceRun(run)
+	} else {
+		saveErr = r.db.InsertMaintenanceRun(run)
+	}
+	if saveErr != nil {
+		queryLogger.Error("Failed to record maintenance run", "error", saveErr)
+	}
+
+	if err != nil {
+		logger.WithError(queryLogger, err).Error("Maintenance failed",
+			"rollup_rows", run.RollupRows,
+			"rows_deleted", run.RowsDeleted,
+			"rollups_deleted", run.RollupsDeleted)
+		return run, err
+	}
+
+	queryLogger.Info("Maintenance completed",
+		"rollup_rows", run.RollupRows,
+		"rows_deleted", run.RowsDeleted,
+		"rollups_deleted", run.RollupsDeleted,
+		"duration_ms", duration)
+	return run, nil
+}
+
+// apply rolls up and removes expired raw samples, then removes expired rollups.
+// Samples before dropBefore are rolled up too, as their partitions are about to be dropped.
+func (r *Runner) apply(ctx context.Context, run *models.MaintenanceRun, policy Policy, now, dropBefore time.Time) error {
+	var cutoff time.Time
+	if policy.Raw > 0 {
+		cutoff = now.Add(-policy.Raw)
+		if len(policy.Downsample) > 0 {
+			// Only whole days are rolled up and removed, so no bucket is left incomplete
+			cutoff = cutoff.UTC().Truncate(day)
+		}
+	}
+
+	if len(policy.Downsample) > 0 {
+		// Partition bounds are UTC midnights, so dropBefore needs no alignment
+		rollupCutoff := cutoff
+		if dropBefore.After(rollupCutoff) {
+			rollupCutoff = dropBefore
+		}
+		if !rollupCutoff.IsZero() {
+			if err := r.rollup(ctx, run, policy.Downsample, rollupCutoff); err != nil {
+				return err
+			}
+		}
+	}
+
+	if policy.Raw > 0 {
+		run.Cutoff = &cutoff
+
+		deleted, err := r.deleteBatches(ctx, func() (int64, error) {
+			return r.db.DeleteMetricsBefore(run.QueryID, cutoff, r.batchSize)
+		})
+		run.RowsDeleted += deleted
+		if err != nil {
+			return err
+		}
+	}
+
+	if policy.Rollup > 0 {
+		cutoff := now.Add(-policy.Rollup)
+		for _, interval := range database.RollupIntervals() {
+			deleted, err := r.deleteBatches(ctx, func() (int64, error) {
+				return r.db.DeleteRollupsBefore(interval, run.QueryID, cutoff, r.batchSize)
+			})
+			run.RollupsDeleted += deleted
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// rollup aggregates the raw samples before cutoff that were not rolled up yet,
+// one day at a time. The watermark is advanced after every day, before any
+// sample of it is removed, so an interrupted run never rolls up a day twice.
+func (r *Runner) rollup(ctx context.Context, run *models.MaintenanceRun, intervals []string, cutoff time.Time) error {
+	oldest, found, err := r.db.GetOldestMetricTime(run.QueryID, cutoff)
+	if err != nil || !found {
+		return err
+	}
+
+	from := oldest.UTC().Truncate(day)
+	watermark, ok, err := r.db.GetRollupWatermark(run.QueryID)
+	if err != nil {
+		return err
+	}
+	if ok && watermark.After(from) {
+		from = watermark
+	}
+
+	for start := from; start.Before(cutoff); start = start.Add(day) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		for _, interval := range intervals {
+			rows, err := r.db.RollupMetrics(interval, run.QueryID, start, start.Add(day))
+			if err != nil {
+				return err
+			}
+			run.RollupRows += rows
+		}
+
+		if err := r.db.SetRollupWatermark(run.QueryID, start.Add(day)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteBatches calls del until it removes fewer than a batch of rows, so every
+// statement only holds its locks briefly
+func (r *Runner) deleteBatches(ctx context.Context, del func() (int64, error)) (int64, error) {
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		deleted, err := del()
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+
+		if deleted < int64(r.batchSize) {
+			return total, nil
+		}
+	}
+}
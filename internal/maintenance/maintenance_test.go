@@ -0,0 +1,116 @@
+package maintenance
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "30d", want: 30 * day},
+		{value: "12w", want: 84 * day},
+		{value: "1y", want: 365 * day},
+		{value: "36h", want: 36 * time.Hour},
+		{value: "1d12h", want: 36 * time.Hour},
+		{value: "0d", wantErr: true},
+		{value: "-1d", wantErr: true},
+		{value: "30 days", wantErr: true},
+		{value: "1.5d", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseRetention(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseRetention = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRetention: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRetention = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	const defaultRaw = 90 * day
+
+	tests := []struct {
+		name    string
+		cfg     *models.RetentionConfig
+		want    Policy
+		wantErr bool
+	}{
+		{
+			name: "no retention uses the default",
+			cfg:  nil,
+			want: Policy{Raw: defaultRaw},
+		},
+		{
+			name: "empty raw uses the default",
+			cfg:  &models.RetentionConfig{Rollup: "1y"},
+			want: Policy{Raw: defaultRaw, Rollup: 365 * day},
+		},
+		{
+			name: "raw overrides the default",
+			cfg:  &models.RetentionConfig{Raw: "7d"},
+			want: Policy{Raw: 7 * day},
+		},
+		{
+			name: "downsample is ordered finest first",
+			cfg:  &models.RetentionConfig{Raw: "7d", Downsample: []string{"daily", "hourly"}, Rollup: "52w"},
+			want: Policy{Raw: 7 * day, Downsample: []string{"hourly", "daily"}, Rollup: 364 * day},
+		},
+		{
+			name:    "invalid raw",
+			cfg:     &models.RetentionConfig{Raw: "week"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid rollup",
+			cfg:     &models.RetentionConfig{Rollup: "0d"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown downsample interval",
+			cfg:     &models.RetentionConfig{Downsample: []string{"weekly"}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate downsample interval",
+			cfg:     &models.RetentionConfig{Downsample: []string{"hourly", "hourly"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePolicy(tt.cfg, defaultRaw)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParsePolicy = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePolicy: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePolicy = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
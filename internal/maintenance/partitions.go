@@ -0,0 +1,301 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/database"
+	"github.com/samzong/prom-etl-db/internal/logger"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// Partition intervals of metrics_data
+const (
+	PartitionDaily   = "day"
+	PartitionMonthly = "month"
+)
+
+// metricsTable is the table whose partitions are managed
+const metricsTable = "metrics_data"
+
+// PartitionManager keeps RANGE partitions of metrics_data on timestamp: it creates
+// partitions ahead of time and drops those whose whole range is past the retention.
+// Partition bounds are UTC midnights or first days of months.
+type PartitionManager struct {
+	db        *database.DB
+	interval  string
+	retention time.Duration
+	precreate int
+	logger    *slog.Logger
+}
+
+// NewPartitionManager creates a partition manager, or returns nil when partitioning is disabled
+func NewPartitionManager(db *database.DB, cfg models.MaintenanceConfig, baseLogger *slog.Logger) (*PartitionManager, error) {
+	if cfg.PartitionInterval == "" {
+		return nil, nil
+	}
+	if cfg.PartitionInterval != PartitionDaily && cfg.PartitionInterval != PartitionMonthly {
+		return nil, fmt.Errorf("partition interval must be %s or %s, got %q", PartitionDaily, PartitionMonthly, cfg.PartitionInterval)
+	}
+
+	retention, err := ParseRetention(cfg.PartitionRetention)
+	if err != nil {
+		return nil, fmt.Errorf("invalid partition retention: %w", err)
+	}
+	if cfg.PartitionPrecreate < 1 {
+		return nil, fmt.Errorf("partitions created ahead must be at least 1, got %d", cfg.PartitionPrecreate)
+	}
+
+	return &PartitionManager{
+		db:        db,
+		interval:  cfg.PartitionInterval,
+		retention: retention,
+		precreate: cfg.PartitionPrecreate,
+		logger:    logger.WithComponent(baseLogger, "partitions"),
+	}, nil
+}
+
+// Expired returns the partitions whose whole range is older than the retention
+func (m *PartitionManager) Expired(now time.Time) ([]database.Partition, error) {
+	if m.retention == 0 {
+		return nil, nil
+	}
+
+	partitions, err := m.db.ListPartitions(metricsTable)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := now.Add(-m.retention)
+	var expired []database.Partition
+	for _, partition := range partitions {
+		if partition.LessThan.IsZero() || partition.LessThan.After(cutoff) {
+			break
+		}
+		expired = append(expired, partition)
+	}
+	return expired, nil
+}
+
+// Drop drops partitions, removing their rows
+func (m *PartitionManager) Drop(partitions []database.Partition) error {
+	if len(partitions) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(partitions))
+	var rows int64
+	for i, partition := range partitions {
+		names[i] = partition.Name
+		rows += partition.Rows
+	}
+
+	if err := m.db.DropPartitions(metricsTable, names); err != nil {
+		return err
+	}
+
+	m.logger.Info("Expired partitions dropped", "partitions", names, "estimated_rows", rows)
+	return nil
+}
+
+// Ensure creates the partitions up to precreate intervals after the current one.
+// Nothing is done when metrics_data is not partitioned yet.
+func (m *PartitionManager) Ensure(now time.Time) error {
+	partitions, err := m.db.ListPartitions(metricsTable)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		m.logger.Warn("metrics_data is not partitioned; run the partition command to convert it")
+		return nil
+	}
+
+	last := partitions[len(partitions)-1]
+	if last.Name != database.MaxPartition || !last.LessThan.IsZero() {
+		return fmt.Errorf("last partition of metrics_data must be %s VALUES LESS THAN MAXVALUE", database.MaxPartition)
+	}
+
+	// New partitions start at the last bound, or at the current interval on a table with only pmax
+	start := m.start(now)
+	if len(partitions) > 1 {
+		start = partitions[len(partitions)-2].LessThan
+	}
+
+	specs := m.specs(start, m.end(now))
+	if err := m.db.AddPartitions(metricsTable, specs); err != nil {
+		return err
+	}
+
+	if len(specs) > 0 {
+		m.logger.Info("Partitions created", "first", specs[0].Name, "last", specs[len(specs)-1].Name, "count", len(specs))
+	}
+	return nil
+}
+
+// Convert copies metrics_data into a partitioned copy in batches of batchSize rows
+// and swaps the tables. Rows written or rewritten during the copy are synced from the
+// old table after the swap; the unpartitioned table is kept for verification.
+// An interrupted conversion continues where it stopped. The partitioning migration
+// is recorded as applied, so migrate up does not rebuild the converted table.
+func (m *PartitionManager) Convert(ctx context.Context, batchSize int, dryRun bool) error {
+	partitions, err := m.db.ListPartitions(metricsTable)
+	if err != nil {
+		return err
+	}
+	if len(partitions) > 0 {
+		m.logger.Info("metrics_data is already partitioned", "partitions", len(partitions))
+		if dryRun {
+			return nil
+		}
+		return m.db.RecordMigration(ctx, database.PartitioningMigration)
+	}
+
+	exists, err := m.db.TableExists(database.PartitionedMetricsTable)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		now := time.Now()
+		oldest, maxID, ok, err := m.db.GetMetricsTimeRange()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			oldest = now
+		}
+
+		specs := m.specs(m.start(oldest), m.end(now))
+		m.logger.Info("Creating partitioned table",
+			"table", database.PartitionedMetricsTable,
+			"first", specs[0].Name,
+			"last", specs[len(specs)-1].Name,
+			"partitions", len(specs),
+			"max_id", maxID)
+		if dryRun {
+			return nil
+		}
+
+		if err := m.db.CreatePartitionedMetricsTable(specs); err != nil {
+			return err
+		}
+	} else {
+		copied, err := m.db.ListPartitions(database.PartitionedMetricsTable)
+		if err != nil {
+			return err
+		}
+		if len(copied) == 0 {
+			return fmt.Errorf("%s exists but is not partitioned; drop it and rerun", database.PartitionedMetricsTable)
+		}
+		m.logger.Info("Resuming conversion", "table", database.PartitionedMetricsTable)
+		if dryRun {
+			return nil
+		}
+	}
+
+	// Copy until the copy has caught up with the rows written meanwhile
+	lastID, err := m.db.GetMaxMetricID(database.PartitionedMetricsTable)
+	if err != nil {
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		target, err := m.db.GetMaxMetricID(metricsTable)
+		if err != nil {
+			return err
+		}
+		if lastID >= target {
+			break
+		}
+
+		if lastID, err = m.db.CopyMetricsBatch(lastID, batchSize); err != nil {
+			return err
+		}
+		m.logger.Info("Conversion progress", "copied_up_to_id", lastID, "max_id", target)
+	}
+
+	if err := m.db.SwapPartitionedMetricsTable(); err != nil {
+		return err
+	}
+	m.logger.Info("Partitioned table swapped in", "previous_table", database.UnpartitionedMetricsTable)
+
+	// Rows written between the last batch and the swap are still in the old table
+	maxID, err := m.db.GetMaxMetricID(database.UnpartitionedMetricsTable)
+	if err != nil {
+		return err
+	}
+	copiedID := lastID
+	for lastID < maxID {
+		if lastID, err = m.db.CopyRemainingMetricsBatch(lastID, maxID, batchSize); err != nil {
+			return fmt.Errorf("%w; rerun the partition command to copy the rest", err)
+		}
+	}
+
+	// Samples rewritten by retries or reruns after their rows were copied are newer in the old table
+	for syncedID := int64(0); syncedID < copiedID; {
+		if syncedID, err = m.db.SyncRewrittenMetricsBatch(syncedID, copiedID, batchSize); err != nil {
+			return err
+		}
+		m.logger.Info("Sync progress", "checked_up_to_id", syncedID, "max_id", copiedID)
+	}
+
+	// The table now has the schema of the partitioning migration, which must not rewrite it again
+	if err := m.db.RecordMigration(ctx, database.PartitioningMigration); err != nil {
+		return err
+	}
+
+	m.logger.Info("Conversion completed; drop the previous table once verified",
+		"previous_table", database.UnpartitionedMetricsTable)
+	return nil
+}
+
+// specs returns the partitions covering [start, end), named after their first day or month
+func (m *PartitionManager) specs(start, end time.Time) []database.PartitionSpec {
+	var specs []database.PartitionSpec
+	for from := start; from.Before(end); {
+		next := m.next(from)
+		specs = append(specs, database.PartitionSpec{Name: m.name(from), LessThan: next})
+		from = next
+	}
+	return specs
+}
+
+// start returns the start of the interval containing t
+func (m *PartitionManager) start(t time.Time) time.Time {
+	t = t.UTC()
+	if m.interval == PartitionMonthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// next returns the start of the interval after the one starting at start
+func (m *PartitionManager) next(start time.Time) time.Time {
+	if m.interval == PartitionMonthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// end returns the bound up to which partitions exist: the end of the current
+// interval plus precreate intervals
+func (m *PartitionManager) end(now time.Time) time.Time {
+	end := m.next(m.start(now))
+	for i := 0; i < m.precreate; i++ {
+		end = m.next(end)
+	}
+	return end
+}
+
+// name returns the name of the partition starting at start
+func (m *PartitionManager) name(start time.Time) string {
+	if m.interval == PartitionMonthly {
+		return start.Format("p200601")
+	}
+	return start.Format("p20060102")
+}
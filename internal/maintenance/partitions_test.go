@@ -0,0 +1,115 @@
+package maintenance
+
+import (
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/database"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+func TestNewPartitionManager(t *testing.T) {
+	if m, err := NewPartitionManager(nil, models.MaintenanceConfig{}, slog.Default()); m != nil || err != nil {
+		t.Errorf("NewPartitionManager = %v, %v, want disabled", m, err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  models.MaintenanceConfig
+		want string
+	}{
+		{"unknown interval", models.MaintenanceConfig{PartitionInterval: "week", PartitionPrecreate: 1}, "partition interval"},
+		{"invalid retention", models.MaintenanceConfig{PartitionInterval: PartitionDaily, PartitionRetention: "0d", PartitionPrecreate: 1}, "invalid partition retention"},
+		{"no precreated partition", models.MaintenanceConfig{PartitionInterval: PartitionMonthly}, "at least 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPartitionManager(nil, tt.cfg, slog.Default())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPartitionBounds(t *testing.T) {
+	// 20:30 on December 31st in New York is already January 1st in UTC
+	now := time.Date(2024, 12, 31, 20, 30, 0, 0, time.FixedZone("EST", -5*60*60))
+
+	tests := []struct {
+		interval  string
+		precreate int
+		start     time.Time
+		end       time.Time
+		names     []string
+	}{
+		{
+			interval:  PartitionDaily,
+			precreate: 2,
+			start:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			end:       time.Date(2025, 1, 4, 0, 0, 0, 0, time.UTC),
+			names:     []string{"p20250101", "p20250102", "p20250103"},
+		},
+		{
+			interval:  PartitionMonthly,
+			precreate: 1,
+			start:     time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			end:       time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+			names:     []string{"p202501", "p202502"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.interval, func(t *testing.T) {
+			m := &PartitionManager{interval: tt.interval, precreate: tt.precreate}
+
+			start := m.start(now)
+			if !start.Equal(tt.start) || start.Location() != time.UTC {
+				t.Errorf("start = %v, want %v", start, tt.start)
+			}
+			end := m.end(now)
+			if !end.Equal(tt.end) {
+				t.Errorf("end = %v, want %v", end, tt.end)
+			}
+
+			var names []string
+			for _, spec := range m.specs(start, end) {
+				names = append(names, spec.Name)
+			}
+			if !reflect.DeepEqual(names, tt.names) {
+				t.Errorf("partitions = %v, want %v", names, tt.names)
+			}
+		})
+	}
+}
+
+func TestPartitionSpecsRollOverYears(t *testing.T) {
+	m := &PartitionManager{interval: PartitionMonthly, precreate: 1}
+	month := func(year int, month time.Month) time.Time {
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	want := []database.PartitionSpec{
+		{Name: "p202411", LessThan: month(2024, time.December)},
+		{Name: "p202412", LessThan: month(2025, time.January)},
+		{Name: "p202501", LessThan: month(2025, time.February)},
+	}
+	if got := m.specs(month(2024, time.November), month(2025, time.February)); !reflect.DeepEqual(got, want) {
+		t.Errorf("specs = %v, want %v", got, want)
+	}
+	if got := m.specs(month(2025, time.February), month(2025, time.February)); len(got) != 0 {
+		t.Errorf("specs of an empty range = %v, want none", got)
+	}
+
+	// Months of different lengths keep starting on the first
+	if got := m.start(time.Date(2024, 2, 29, 23, 59, 59, 0, time.UTC)); !got.Equal(month(2024, time.February)) {
+		t.Errorf("start = %v, want 2024-02-01", got)
+	}
+	if got := m.next(month(2024, time.January)); !got.Equal(month(2024, time.February)) {
+		t.Errorf("next = %v, want 2024-02-01", got)
+	}
+}
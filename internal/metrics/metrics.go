@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/samzong/prom-etl-db/internal/logger"
+)
+
+const namespace = "prom_etl"
+
+// Failure stages reported by the executor
+const (
+	StageQuery = "query"
+	StageParse = "parse"
+	StageStore = "store"
+)
+
+// Metrics holds the service's own Prometheus instrumentation.
+// All methods are safe to call on a nil *Metrics, which disables recording.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	executionsTotal   *prometheus.CounterVec
+	executionDuration *prometheus.HistogramVec
+	recordsWritten    *prometheus.CounterVec
+	failuresTotal     *prometheus.CounterVec
+	lastSuccess       *prometheus.GaugeVec
+	skippedTotal      *prometheus.CounterVec
+}
+
+// NewMetrics creates and registers all collectors, including connection pool stats for db
+func NewMetrics(db *sql.DB, dbName string) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		executionsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "query_executions_total",
+			Help:      "Total number of query executions by final status.",
+		}, []string{"query_id", "status"}),
+		executionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "query_duration_seconds",
+			Help:      "Duration of query executions including storage.",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+		}, []string{"query_id"}),
+		recordsWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "records_written_total",
+			Help:      "Total number of metric records written to storage.",
+		}, []string{"query_id"}),
+		failuresTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "query_failures_total",
+			Help:      "Total number of failed query executions by stage.",
+		}, []string{"query_id", "stage"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "query_last_success_timestamp_seconds",
+			Help:      "Unix timestamp of the last successful execution.",
+		}, []string{"query_id"}),
+		skippedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "query_skipped_total",
+			Help:      "Total number of query runs not executed by reason (overlap, queue_full, stopped).",
+		}, []string{"query_id", "reason"}),
+	}
+
+	m.registry.MustRegister(
+		m.executionsTotal,
+		m.executionDuration,
+		m.recordsWritten,
+		m.failuresTotal,
+		m.lastSuccess,
+		m.skippedTotal,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, dbName))
+	}
+
+	return m
+}
+
+// Handler returns the HTTP handler serving the registry
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Registry returns the underlying registry so other packages can add collectors
+func (m *Metrics) Registry() *prometheus.Registry {
+	if m == nil {
+		return nil
+	}
+	return m.registry
+}
+
+// ObserveExecution records the outcome of a query execution
+func (m *Metrics) ObserveExecution(queryID, status string, duration time.Duration, records int) {
+	if m == nil {
+		return
+	}
+
+	m.executionsTotal.WithLabelValues(queryID, status).Inc()
+	m.executionDuration.WithLabelValues(queryID).Observe(duration.Seconds())
+	if records > 0 {
+		m.recordsWritten.WithLabelValues(queryID).Add(float64(records))
+	}
+	if status == "success" {
+		m.lastSuccess.WithLabelValues(queryID).SetToCurrentTime()
+	}
+}
+
+// IncFailure records a failure at the given stage
+func (m *Metrics) IncFailure(queryID, stage string) {
+	if m == nil {
+		return
+	}
+	m.failuresTotal.WithLabelValues(queryID, stage).Inc()
+}
+
+// IncSkipped records a query run that was not executed
+func (m *Metrics) IncSkipped(queryID, reason string) {
+	if m == nil {
+		return
+	}
+	m.skippedTotal.WithLabelValues(queryID, reason).Inc()
+}
+
+// RegisterWorkerPool exports worker pool size, queue depth and busy workers
+func (m *Metrics) RegisterWorkerPool(size int, queueDepth, busy func() int) {
+	if m == nil {
+		return
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "worker_pool_size",
+			Help:      "Number of workers executing queries.",
+		}, func() float64 { return float64(size) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "worker_queue_depth",
+			Help:      "Number of query runs waiting for a worker.",
+		}, func() float64 { return float64(queueDepth()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "worker_busy",
+			Help:      "Number of workers currently executing a query.",
+		}, func() float64 { return float64(busy()) }),
+	)
+}
+
+// StartServer serves /metrics on its own port in the background
+func (m *Metrics) StartServer(port int, baseLogger *slog.Logger) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", srv.Addr, err)
+	}
+
+	log := logger.WithComponent(baseLogger, "metrics-server")
+	log.Info("Metrics server started", "addr", listener.Addr().String())
+
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.WithError(log, err).Error("Metrics server stopped unexpectedly")
+		}
+	}()
+
+	return srv, nil
+}
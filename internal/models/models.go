@@ -1,47 +1,19 @@
 package models
 
 import (
-	"encoding/json"
 	"time"
 )
 
-// PrometheusResponse represents the response from Prometheus API
-type PrometheusResponse struct {
-	Status string     `json:"status"`
-	Data   ResultData `json:"data"`
-}
-
-// ResultData represents the data part of Prometheus response
-type ResultData struct {
-	ResultType string      `json:"resultType"`
-	Result     interface{} `json:"result"`
-}
-
-// VectorResult represents a vector query result
-type VectorResult []VectorSample
-
-// VectorSample represents a single sample in vector result
-type VectorSample struct {
-	Metric map[string]string `json:"metric"`
-	Value  []interface{}     `json:"value"`
-}
-
-// MatrixResult represents a matrix query result (for range queries)
-type MatrixResult []MatrixSample
-
-// MatrixSample represents a single sample in matrix result
-type MatrixSample struct {
-	Metric map[string]string `json:"metric"`
-	Values [][]interface{}   `json:"values"`
-}
-
-// MetricRecord represents a metric record to be stored in database
+// MetricRecord represents a metric record to be stored in database.
+// Records of string results carry StringValue instead of Value.
 type MetricRecord struct {
 	ID          int64                  `json:"id"`
 	QueryID     string                 `json:"query_id"`
+	Source      string                 `json:"source"`
 	MetricName  string                 `json:"metric_name"`
 	Labels      map[string]interface{} `json:"labels"`
 	Value       float64                `json:"value"`
+	StringValue *string                `json:"string_value,omitempty"`
 	Timestamp   time.Time              `json:"timestamp"`
 	ResultType  string                 `json:"result_type"`
 	CollectedAt time.Time              `json:"collected_at"`
@@ -49,16 +21,47 @@ type MetricRecord struct {
 
 // QueryExecution represents a query execution record
 type QueryExecution struct {
-	ID           int64      `json:"id"`
-	QueryID      string     `json:"query_id"`
-	QueryName    string     `json:"query_name"`
-	Status       string     `json:"status"`
-	StartTime    time.Time  `json:"start_time"`
-	EndTime      *time.Time `json:"end_time,omitempty"`
-	DurationMs   *int64     `json:"duration_ms,omitempty"`
-	RecordsCount int        `json:"records_count"`
-	ErrorMessage *string    `json:"error_message,omitempty"`
-	CreatedAt    time.Time  `json:"created_at"`
+	ID             int64      `json:"id"`
+	QueryID        string     `json:"query_id"`
+	QueryName      string     `json:"query_name"`
+	Status         string     `json:"status"`
+	EvaluationTime *time.Time `json:"evaluation_time,omitempty"`
+	StartTime      time.Time  `json:"start_time"`
+	EndTime        *time.Time `json:"end_time,omitempty"`
+	DurationMs     *int64     `json:"duration_ms,omitempty"`
+	RecordsCount   int        `json:"records_count"`
+	ErrorMessage   *string    `json:"error_message,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// MaintenanceRun records one retention run of a query
+type MaintenanceRun struct {
+	ID         int64      `json:"id"`
+	QueryID    string     `json:"query_id"`
+	Status     string     `json:"status"`
+	StartTime  time.Time  `json:"start_time"`
+	EndTime    *time.Time `json:"end_time,omitempty"`
+	DurationMs *int64     `json:"duration_ms,omitempty"`
+	// Raw samples before Cutoff were removed; nil when raw samples are kept forever
+	Cutoff         *time.Time `json:"cutoff,omitempty"`
+	RollupRows     int64      `json:"rollup_rows"`
+	RowsDeleted    int64      `json:"rows_deleted"`
+	RollupsDeleted int64      `json:"rollups_deleted"`
+	ErrorMessage   *string    `json:"error_message,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// RetentionConfig represents the retention policy of a query.
+// Durations use Prometheus syntax, e.g. 30d or 12w; empty keeps data forever.
+type RetentionConfig struct {
+	// Retention of raw samples; empty uses DEFAULT_RETENTION
+	Raw string `yaml:"raw,omitempty" json:"raw,omitempty"`
+
+	// Rollups ("hourly", "daily") written from raw samples before they are removed
+	Downsample []string `yaml:"downsample,omitempty,flow" json:"downsample,omitempty"`
+
+	// Retention of the rollups
+	Rollup string `yaml:"rollup,omitempty" json:"rollup,omitempty"`
 }
 
 // TimeRangeConfig represents time range configuration for queries
@@ -91,22 +94,125 @@ type QueryConfig struct {
 	RetryCount    int    `yaml:"retry_count" json:"retry_count"`
 	RetryInterval string `yaml:"retry_interval" json:"retry_interval"`
 
+	// Datasource names the Prometheus endpoint to query; empty uses the default PROMETHEUS_* endpoint.
+	// A comma separated list, or * for every datasource, fans the query out over several endpoints.
+	Datasource string `yaml:"datasource,omitempty" json:"datasource,omitempty"`
+
 	// Time range configuration (optional)
 	TimeRange *TimeRangeConfig `yaml:"time_range,omitempty" json:"time_range,omitempty"`
+
+	// Relabeling rules applied to every result series before it is stored (optional)
+	RelabelConfigs []RelabelConfig `yaml:"relabel_configs,omitempty" json:"relabel_configs,omitempty"`
+
+	// Labels stored as indexed metrics_data columns, for filtering without JSON_EXTRACT (optional)
+	PromotedLabels []string `yaml:"promoted_labels,omitempty,flow" json:"promoted_labels,omitempty"`
+
+	// How long samples are kept and whether they are downsampled first (optional)
+	Retention *RetentionConfig `yaml:"retention,omitempty" json:"retention,omitempty"`
+
+	// Last modification time of the database row, used to detect changes on reload
+	UpdatedAt time.Time `yaml:"-" json:"updated_at"`
+}
+
+// RelabelConfig represents a relabeling rule modeled on Prometheus' relabel_config.
+// Empty fields take Prometheus' defaults: separator ";", regex "(.*)",
+// replacement "$1" and action "replace".
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,flow,omitempty" json:"source_labels,omitempty"`
+	Separator    string   `yaml:"separator,omitempty" json:"separator,omitempty"`
+	Regex        string   `yaml:"regex,omitempty" json:"regex,omitempty"`
+	Modulus      uint64   `yaml:"modulus,omitempty" json:"modulus,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty" json:"target_label,omitempty"`
+	// Replacement is a pointer so that an explicitly empty replacement differs from the default
+	Replacement *string `yaml:"replacement,omitempty" json:"replacement,omitempty"`
+	// Action is replace, keep, drop, hashmod, labelmap, labeldrop or labelkeep
+	Action string `yaml:"action,omitempty" json:"action,omitempty"`
 }
 
 // Config represents the application configuration
 type Config struct {
-	Prometheus PrometheusConfig `yaml:"prometheus" json:"prometheus"`
-	MySQL      MySQLConfig      `yaml:"mysql" json:"mysql"`
-	App        AppConfig        `yaml:"app" json:"app"`
-	Queries    []QueryConfig    `yaml:"queries" json:"queries"`
+	Prometheus  PrometheusConfig  `yaml:"prometheus" json:"prometheus"`
+	MySQL       MySQLConfig       `yaml:"mysql" json:"mysql"`
+	Sink        SinkConfig        `yaml:"sink" json:"sink"`
+	App         AppConfig         `yaml:"app" json:"app"`
+	Maintenance MaintenanceConfig `yaml:"maintenance" json:"maintenance"`
+	QueryFiles  QueryFilesConfig  `yaml:"query_files" json:"query_files"`
+	Queries     []QueryConfig     `yaml:"queries" json:"queries"`
+}
+
+// QueryFilesConfig represents the YAML query definitions applied to query_configs
+type QueryFilesConfig struct {
+	// Directory of *.yaml query definition files
+	Dir string `yaml:"dir" json:"dir"`
+
+	// Apply the files to query_configs when the service starts
+	SyncOnStartup bool `yaml:"sync_on_startup" json:"sync_on_startup"`
+
+	// Delete the queries that no file defines when syncing on startup
+	Prune bool `yaml:"prune" json:"prune"`
+}
+
+// MaintenanceConfig represents the settings of the metrics_data maintenance job
+type MaintenanceConfig struct {
+	// Cron expression (with seconds); empty disables the job
+	Schedule string `yaml:"schedule" json:"schedule"`
+
+	// Raw retention of queries without their own; empty keeps raw samples forever
+	DefaultRetention string `yaml:"default_retention" json:"default_retention"`
+
+	// Rows removed per DELETE statement
+	BatchSize int `yaml:"batch_size" json:"batch_size"`
+
+	// Partitioning of metrics_data: "day", "month" or empty when unmanaged
+	PartitionInterval string `yaml:"partition_interval" json:"partition_interval"`
+
+	// Age after which whole partitions are dropped; empty keeps them
+	PartitionRetention string `yaml:"partition_retention" json:"partition_retention"`
+
+	// Partitions created ahead of the current one
+	PartitionPrecreate int `yaml:"partition_precreate" json:"partition_precreate"`
 }
 
 // PrometheusConfig represents Prometheus configuration
 type PrometheusConfig struct {
 	URL     string `yaml:"url" json:"url"`
 	Timeout string `yaml:"timeout" json:"timeout"`
+
+	// Authentication, TLS and extra request headers (e.g. X-Scope-OrgID)
+	Auth    AuthConfig        `yaml:"auth" json:"auth"`
+	TLS     TLSConfig         `yaml:"tls" json:"tls"`
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+}
+
+// Datasource represents a named Prometheus-compatible endpoint stored in the datasources table
+type Datasource struct {
+	Name       string           `yaml:"name" json:"name"`
+	Prometheus PrometheusConfig `yaml:"prometheus" json:"prometheus"`
+
+	// Last modification time of the database row, used to detect changes on reload
+	UpdatedAt time.Time `yaml:"-" json:"updated_at"`
+}
+
+// AuthConfig represents HTTP authentication for a Prometheus-compatible endpoint
+type AuthConfig struct {
+	// Type is none, basic or bearer
+	Type     string `yaml:"type" json:"type"`
+	Username string `yaml:"username,omitempty" json:"username,omitempty"`
+	Password string `yaml:"password,omitempty" json:"-"`
+	Token    string `yaml:"token,omitempty" json:"-"`
+
+	// Files take precedence over inline secrets and are re-read when they change
+	PasswordFile string `yaml:"password_file,omitempty" json:"password_file,omitempty"`
+	TokenFile    string `yaml:"token_file,omitempty" json:"token_file,omitempty"`
+}
+
+// TLSConfig represents TLS settings for a Prometheus-compatible endpoint
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty" json:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty" json:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty" json:"key_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty" json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
 }
 
 // MySQLConfig represents MySQL configuration
@@ -119,78 +225,83 @@ type MySQLConfig struct {
 	Charset  string `yaml:"charset" json:"charset"`
 }
 
+// SinkConfig selects where metric records are written
+type SinkConfig struct {
+	// Types lists the sinks every execution is written to, e.g. mysql, postgres, clickhouse, file, s3
+	Types      []string         `yaml:"types" json:"types"`
+	Postgres   PostgresConfig   `yaml:"postgres" json:"postgres"`
+	ClickHouse ClickHouseConfig `yaml:"clickhouse" json:"clickhouse"`
+	File       FileSinkConfig   `yaml:"file" json:"file"`
+	S3         S3Config         `yaml:"s3" json:"s3"`
+}
+
+// PostgresConfig represents the PostgreSQL/TimescaleDB sink configuration
+type PostgresConfig struct {
+	DSN           string `yaml:"dsn" json:"-"`
+	Timescale     bool   `yaml:"timescale" json:"timescale"`
+	ChunkInterval string `yaml:"chunk_interval" json:"chunk_interval"`
+}
+
+// ClickHouseConfig represents the ClickHouse sink configuration
+type ClickHouseConfig struct {
+	// Addr lists host:port pairs of the native protocol
+	Addr     []string `yaml:"addr" json:"addr"`
+	Database string   `yaml:"database" json:"database"`
+	Username string   `yaml:"username" json:"username"`
+	Password string   `yaml:"password" json:"-"`
+	// BatchSize is the maximum number of rows per insert block
+	BatchSize int `yaml:"batch_size" json:"batch_size"`
+}
+
+// FileSinkConfig represents the file export sink configuration
+type FileSinkConfig struct {
+	// Dir is the root of the query_id=<id>/date=<YYYY-MM-DD> partitions
+	Dir string `yaml:"dir" json:"dir"`
+	// Format is parquet or csv
+	Format string `yaml:"format" json:"format"`
+	// Labels is map (one labels column) or flatten (one label_<name> column per label)
+	Labels string `yaml:"labels" json:"labels"`
+}
+
+// S3Config represents the S3-compatible object storage sink configuration
+type S3Config struct {
+	// Endpoint is host:port without scheme, e.g. s3.amazonaws.com or localhost:9000
+	Endpoint  string `yaml:"endpoint" json:"endpoint"`
+	Bucket    string `yaml:"bucket" json:"bucket"`
+	Prefix    string `yaml:"prefix" json:"prefix"`
+	Region    string `yaml:"region" json:"region"`
+	AccessKey string `yaml:"access_key" json:"-"`
+	SecretKey string `yaml:"secret_key" json:"-"`
+	UseSSL    bool   `yaml:"use_ssl" json:"use_ssl"`
+	// PathStyle forces path-style bucket addressing, as used by MinIO
+	PathStyle bool `yaml:"path_style" json:"path_style"`
+
+	// Format and Labels as in FileSinkConfig
+	Format string `yaml:"format" json:"format"`
+	Labels string `yaml:"labels" json:"labels"`
+
+	// PartSize is the multipart upload part size in bytes
+	PartSize int64 `yaml:"part_size" json:"part_size"`
+	// MaxRetries is the number of retries of a failed upload
+	MaxRetries int `yaml:"max_retries" json:"max_retries"`
+	// MaxRowsPerFile splits large executions into several files; 0 writes one file
+	MaxRowsPerFile int `yaml:"max_rows_per_file" json:"max_rows_per_file"`
+}
+
 // AppConfig represents application configuration
 type AppConfig struct {
-	LogLevel   string `yaml:"log_level" json:"log_level"`
-	HTTPPort   int    `yaml:"http_port" json:"http_port"`
-	WorkerPool int    `yaml:"worker_pool" json:"worker_pool"`
-}
-
-// ParseVectorResult parses vector result from Prometheus response
-func (pr *PrometheusResponse) ParseVectorResult() (VectorResult, error) {
-	resultBytes, err := json.Marshal(pr.Data.Result)
-	if err != nil {
-		return nil, err
-	}
-
-	var vectorResult VectorResult
-	err = json.Unmarshal(resultBytes, &vectorResult)
-	if err != nil {
-		return nil, err
-	}
-
-	return vectorResult, nil
-}
-
-// ParseMatrixResult parses matrix result from Prometheus response
-func (pr *PrometheusResponse) ParseMatrixResult() (MatrixResult, error) {
-	resultBytes, err := json.Marshal(pr.Data.Result)
-	if err != nil {
-		return nil, err
-	}
-
-	var matrixResult MatrixResult
-	err = json.Unmarshal(resultBytes, &matrixResult)
-	if err != nil {
-		return nil, err
-	}
-
-	return matrixResult, nil
-}
-
-// ToMetricRecord converts VectorSample to MetricRecord
-func (vs *VectorSample) ToMetricRecord(queryID string) (*MetricRecord, error) {
-	// Extract metric name
-	metricName := vs.Metric["__name__"]
-	if metricName == "" {
-		metricName = queryID
-	}
-
-	// Parse timestamp and value
-	timestamp := time.Unix(int64(vs.Value[0].(float64)), 0)
-	value := vs.Value[1].(string)
-
-	// Convert string value to float64
-	var floatValue float64
-	if err := json.Unmarshal([]byte(value), &floatValue); err != nil {
-		return nil, err
-	}
-
-	// Clean labels (remove internal labels)
-	labels := make(map[string]interface{})
-	for k, v := range vs.Metric {
-		if k != "__name__" {
-			labels[k] = v
-		}
-	}
-
-	return &MetricRecord{
-		QueryID:     queryID,
-		MetricName:  metricName,
-		Labels:      labels,
-		Value:       floatValue,
-		Timestamp:   timestamp,
-		ResultType:  "instant",
-		CollectedAt: time.Now(),
-	}, nil
+	LogLevel       string `yaml:"log_level" json:"log_level"`
+	HTTPPort       int    `yaml:"http_port" json:"http_port"`
+	WorkerPool     int    `yaml:"worker_pool" json:"worker_pool"`
+	WorkerQueue    int    `yaml:"worker_queue" json:"worker_queue"`
+	OverlapPolicy  string `yaml:"overlap_policy" json:"overlap_policy"`
+	MetricsEnabled bool   `yaml:"metrics_enabled" json:"metrics_enabled"`
+	MetricsPort    int    `yaml:"metrics_port" json:"metrics_port"`
+	ReloadInterval string `yaml:"reload_interval" json:"reload_interval"`
+
+	// Timeout for queries whose own timeout is empty or invalid
+	DefaultQueryTimeout string `yaml:"default_query_timeout" json:"default_query_timeout"`
+
+	// Apply pending schema migrations when the service starts
+	AutoMigrate bool `yaml:"auto_migrate" json:"auto_migrate"`
 }
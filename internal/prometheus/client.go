@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"time"
 
 	"github.com/jinzhu/now"
@@ -15,9 +16,8 @@ import (
 
 // Client represents a Prometheus client using official library
 type Client struct {
-	client       v1.API
-	timeResolver TimeResolver
-	logger       *slog.Logger
+	client v1.API
+	logger *slog.Logger
 }
 
 // TimeResolver defines interface for time expression resolution
@@ -110,9 +110,32 @@ func NewClient(baseURL, timeout string) (*Client, error) {
 
 // NewClientWithLogger creates a new Prometheus client with custom logger
 func NewClientWithLogger(baseURL string, timeout time.Duration, baseLogger *slog.Logger) (*Client, error) {
+	return NewClientWithConfig(models.PrometheusConfig{URL: baseURL, Timeout: timeout.String()}, baseLogger)
+}
+
+// NewClientWithConfig creates a new Prometheus client with authentication, TLS and custom headers.
+// A non-empty cfg.Timeout bounds every request to the endpoint.
+func NewClientWithConfig(cfg models.PrometheusConfig, baseLogger *slog.Logger) (*Client, error) {
+	roundTripper, err := NewRoundTripper(cfg.Auth, cfg.TLS, cfg.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure prometheus transport: %w", err)
+	}
+
+	var timeout time.Duration
+	if cfg.Timeout != "" {
+		timeout, err = time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout format: %w", err)
+		}
+	}
+
 	// Create Prometheus API client
 	client, err := api.NewClient(api.Config{
-		Address: baseURL,
+		Address: cfg.URL,
+		Client: &http.Client{
+			Transport: roundTripper,
+			Timeout:   timeout,
+		},
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create prometheus client: %w", err)
@@ -127,19 +150,18 @@ func NewClientWithLogger(baseURL string, timeout time.Duration, baseLogger *slog
 	}
 
 	return &Client{
-		client:       v1.NewAPI(client),
-		timeResolver: NewRelativeTimeResolver(time.Now()),
-		logger:       clientLogger,
+		client: v1.NewAPI(client),
+		logger: clientLogger,
 	}, nil
 }
 
 // QueryInstant executes an instant query
-func (c *Client) QueryInstant(ctx context.Context, query string) (*models.PrometheusResponse, error) {
+func (c *Client) QueryInstant(ctx context.Context, query string) (model.Value, error) {
 	return c.QueryInstantWithTime(ctx, query, time.Now())
 }
 
 // QueryInstantWithTime executes an instant query at a specific time
-func (c *Client) QueryInstantWithTime(ctx context.Context, query string, queryTime time.Time) (*models.PrometheusResponse, error) {
+func (c *Client) QueryInstantWithTime(ctx context.Context, query string, queryTime time.Time) (model.Value, error) {
 	c.logger.Info("Executing instant query",
 		"query", query,
 		"time", queryTime.Format(time.RFC3339),
@@ -162,22 +184,22 @@ func (c *Client) QueryInstantWithTime(ctx context.Context, query string, queryTi
 		)
 	}
 
-	response := c.convertToPrometheusResponse(result)
 	c.logger.Info("Instant query completed successfully",
 		"query", query,
-		"result_type", response.Data.ResultType,
+		"result_type", result.Type().String(),
 	)
 
-	return response, nil
+	return result, nil
 }
 
-// QueryInstantWithConfig executes an instant query with time configuration
-func (c *Client) QueryInstantWithConfig(ctx context.Context, query string, timeConfig *models.TimeRangeConfig) (*models.PrometheusResponse, error) {
-	queryTime := time.Now()
+// QueryInstantWithConfig executes an instant query with time configuration.
+// Relative time expressions are resolved against baseTime.
+func (c *Client) QueryInstantWithConfig(ctx context.Context, query string, timeConfig *models.TimeRangeConfig, baseTime time.Time) (model.Value, error) {
+	queryTime := baseTime
 
 	if timeConfig != nil && timeConfig.Time != "" {
 		var err error
-		queryTime, err = c.timeResolver.ResolveTime(timeConfig.Time)
+		queryTime, err = NewRelativeTimeResolver(baseTime).ResolveTime(timeConfig.Time)
 		if err != nil {
 			c.logger.Error("Failed to resolve query time",
 				"time_expr", timeConfig.Time,
@@ -187,6 +209,7 @@ func (c *Client) QueryInstantWithConfig(ctx context.Context, query string, timeC
 		}
 		c.logger.Info("Resolved query time",
 			"time_expr", timeConfig.Time,
+			"base_time", baseTime.Format(time.RFC3339),
 			"resolved_time", queryTime.Format(time.RFC3339),
 		)
 	}
@@ -195,7 +218,7 @@ func (c *Client) QueryInstantWithConfig(ctx context.Context, query string, timeC
 }
 
 // QueryRange executes a range query
-func (c *Client) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) (*models.PrometheusResponse, error) {
+func (c *Client) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) (model.Value, error) {
 	c.logger.Info("Executing range query",
 		"query", query,
 		"start", start.Format(time.RFC3339),
@@ -226,22 +249,22 @@ func (c *Client) QueryRange(ctx context.Context, query string, start, end time.T
 		)
 	}
 
-	response := c.convertToPrometheusResponse(result)
 	c.logger.Info("Range query completed successfully",
 		"query", query,
-		"result_type", response.Data.ResultType,
+		"result_type", result.Type().String(),
 	)
 
-	return response, nil
+	return result, nil
 }
 
-// QueryRangeWithConfig executes a range query with time configuration
-func (c *Client) QueryRangeWithConfig(ctx context.Context, query string, timeConfig *models.TimeRangeConfig) (*models.PrometheusResponse, error) {
+// QueryRangeWithConfig executes a range query with time configuration.
+// Relative time expressions are resolved against baseTime.
+func (c *Client) QueryRangeWithConfig(ctx context.Context, query string, timeConfig *models.TimeRangeConfig, baseTime time.Time) (model.Value, error) {
 	if timeConfig == nil {
 		return nil, fmt.Errorf("time configuration is required for range query")
 	}
 
-	start, end, err := c.timeResolver.ResolveRangeTime(timeConfig.Start, timeConfig.End)
+	start, end, err := NewRelativeTimeResolver(baseTime).ResolveRangeTime(timeConfig.Start, timeConfig.End)
 	if err != nil {
 		c.logger.Error("Failed to resolve time range",
 			"start_expr", timeConfig.Start,
@@ -264,6 +287,7 @@ func (c *Client) QueryRangeWithConfig(ctx context.Context, query string, timeCon
 		"start_expr", timeConfig.Start,
 		"end_expr", timeConfig.End,
 		"step_expr", timeConfig.Step,
+		"base_time", baseTime.Format(time.RFC3339),
 		"resolved_start", start.Format(time.RFC3339),
 		"resolved_end", end.Format(time.RFC3339),
 		"resolved_step", step.String(),
@@ -272,10 +296,11 @@ func (c *Client) QueryRangeWithConfig(ctx context.Context, query string, timeCon
 	return c.QueryRange(ctx, query, start, end, step)
 }
 
-// QueryWithTimeRange executes a query with time range configuration (unified interface)
-func (c *Client) QueryWithTimeRange(ctx context.Context, query string, timeRange *models.TimeRangeConfig) (*models.PrometheusResponse, error) {
+// QueryWithTimeRange executes a query with time range configuration (unified interface).
+// baseTime is the logical evaluation time that relative expressions are resolved against.
+func (c *Client) QueryWithTimeRange(ctx context.Context, query string, timeRange *models.TimeRangeConfig, baseTime time.Time) (model.Value, error) {
 	if timeRange == nil {
-		return c.QueryInstant(ctx, query)
+		return c.QueryInstantWithTime(ctx, query, baseTime)
 	}
 
 	c.logger.Info("Processing time range configuration",
@@ -288,82 +313,14 @@ func (c *Client) QueryWithTimeRange(ctx context.Context, query string, timeRange
 
 	switch timeRange.Type {
 	case "instant":
-		return c.QueryInstantWithConfig(ctx, query, timeRange)
+		return c.QueryInstantWithConfig(ctx, query, timeRange, baseTime)
 	case "range":
-		return c.QueryRangeWithConfig(ctx, query, timeRange)
+		return c.QueryRangeWithConfig(ctx, query, timeRange, baseTime)
 	default:
 		c.logger.Warn("Unknown time range type, defaulting to instant query",
 			"type", timeRange.Type,
 		)
-		return c.QueryInstant(ctx, query)
-	}
-}
-
-// convertToPrometheusResponse converts Prometheus API result to our response format
-func (c *Client) convertToPrometheusResponse(value model.Value) *models.PrometheusResponse {
-	response := &models.PrometheusResponse{
-		Status: "success",
-		Data: models.ResultData{
-			ResultType: value.Type().String(),
-		},
-	}
-
-	switch v := value.(type) {
-	case model.Vector:
-		response.Data.Result = c.convertVector(v)
-	case model.Matrix:
-		response.Data.Result = c.convertMatrix(v)
-	case *model.Scalar:
-		response.Data.Result = c.convertScalar(v)
-	case *model.String:
-		response.Data.Result = c.convertString(v)
-	default:
-		c.logger.Warn("Unknown result type", "type", value.Type())
-		response.Data.Result = []interface{}{}
-	}
-
-	return response
-}
-
-// convertVector converts model.Vector to our format
-func (c *Client) convertVector(vector model.Vector) []interface{} {
-	result := make([]interface{}, len(vector))
-	for i, sample := range vector {
-		result[i] = map[string]interface{}{
-			"metric": sample.Metric,
-			"value":  []interface{}{float64(sample.Timestamp.Unix()), sample.Value.String()},
-		}
-	}
-	return result
-}
-
-// convertMatrix converts model.Matrix to our format
-func (c *Client) convertMatrix(matrix model.Matrix) []interface{} {
-	result := make([]interface{}, len(matrix))
-	for i, sampleStream := range matrix {
-		values := make([]interface{}, len(sampleStream.Values))
-		for j, pair := range sampleStream.Values {
-			values[j] = []interface{}{float64(pair.Timestamp.Unix()), pair.Value.String()}
-		}
-		result[i] = map[string]interface{}{
-			"metric": sampleStream.Metric,
-			"values": values,
-		}
-	}
-	return result
-}
-
-// convertScalar converts model.Scalar to our format
-func (c *Client) convertScalar(scalar *model.Scalar) []interface{} {
-	return []interface{}{
-		[]interface{}{float64(scalar.Timestamp.Unix()), scalar.Value.String()},
-	}
-}
-
-// convertString converts model.String to our format
-func (c *Client) convertString(str *model.String) []interface{} {
-	return []interface{}{
-		[]interface{}{float64(str.Timestamp.Unix()), string(str.Value)},
+		return c.QueryInstantWithTime(ctx, query, baseTime)
 	}
 }
 
@@ -376,6 +333,14 @@ func (c *Client) TestConnection(ctx context.Context) error {
 	return nil
 }
 
+// Ping checks that Prometheus answers queries without logging the result
+func (c *Client) Ping(ctx context.Context) error {
+	if _, _, err := c.client.Query(ctx, "vector(1)", time.Now()); err != nil {
+		return fmt.Errorf("ping failed: %w", err)
+	}
+	return nil
+}
+
 // GetMetrics returns available metrics
 func (c *Client) GetMetrics(ctx context.Context) ([]string, error) {
 	labelValues, warnings, err := c.client.LabelValues(ctx, "__name__", nil, time.Now().Add(-time.Hour), time.Now())
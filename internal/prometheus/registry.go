@@ -0,0 +1,163 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/logger"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// DefaultDatasource names the endpoint configured by the PROMETHEUS_* settings.
+// Queries without a datasource use it.
+const DefaultDatasource = "default"
+
+// DatasourceLoader returns the currently configured datasources
+type DatasourceLoader func() ([]models.Datasource, error)
+
+// Registry holds one client per datasource and keeps them in sync with the datasources table
+type Registry struct {
+	defaultClient *Client
+	load          DatasourceLoader
+	logger        *slog.Logger
+
+	mu      sync.RWMutex
+	clients map[string]*datasourceClient
+}
+
+// datasourceClient is a client with the modification time of its datasource
+type datasourceClient struct {
+	client    *Client
+	updatedAt time.Time
+}
+
+// NewRegistry creates a registry around the default client; call Reload to load the datasources.
+// load may be nil when only the default endpoint is used.
+func NewRegistry(defaultClient *Client, load DatasourceLoader, baseLogger *slog.Logger) *Registry {
+	return &Registry{
+		defaultClient: defaultClient,
+		load:          load,
+		logger:        logger.WithComponent(baseLogger, "datasource-registry"),
+		clients:       make(map[string]*datasourceClient),
+	}
+}
+
+// Reload loads the datasources and creates clients for new or modified ones.
+// A datasource whose client cannot be created keeps its previous client, if any.
+func (r *Registry) Reload() error {
+	if r.load == nil {
+		return nil
+	}
+
+	datasources, err := r.load()
+	if err != nil {
+		return fmt.Errorf("failed to load datasources: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+	seen := make(map[string]bool, len(datasources))
+	for _, datasource := range datasources {
+		if datasource.Name == DefaultDatasource {
+			errs = append(errs, fmt.Errorf("datasource name %q is reserved for the PROMETHEUS_* endpoint", DefaultDatasource))
+			continue
+		}
+		seen[datasource.Name] = true
+
+		existing, ok := r.clients[datasource.Name]
+		if ok && existing.updatedAt.Equal(datasource.UpdatedAt) {
+			continue
+		}
+
+		client, err := NewClientWithConfig(datasource.Prometheus, r.logger)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("datasource %s: %w", datasource.Name, err))
+			continue
+		}
+		r.clients[datasource.Name] = &datasourceClient{client: client, updatedAt: datasource.UpdatedAt}
+
+		if ok {
+			r.logger.Info("Datasource updated", "datasource", datasource.Name, "url", datasource.Prometheus.URL)
+		} else {
+			r.logger.Info("Datasource added", "datasource", datasource.Name, "url", datasource.Prometheus.URL)
+		}
+	}
+
+	for name, existing := range r.clients {
+		if !seen[name] {
+			_ = existing.client.Close()
+			delete(r.clients, name)
+			r.logger.Info("Datasource removed", "datasource", name)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Client returns the client of the named datasource; an empty name returns the default client
+func (r *Registry) Client(name string) (*Client, error) {
+	if name == "" || name == DefaultDatasource {
+		return r.defaultClient, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	existing, ok := r.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown datasource: %s", name)
+	}
+	return existing.client, nil
+}
+
+// Default returns the client of the PROMETHEUS_* endpoint
+func (r *Registry) Default() *Client {
+	return r.defaultClient
+}
+
+// Names returns the default datasource followed by the others in sorted order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.clients)+1)
+	names = append(names, DefaultDatasource)
+	for name := range r.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// Ping pings every datasource and returns the errors by datasource name
+func (r *Registry) Ping(ctx context.Context) map[string]error {
+	results := make(map[string]error)
+	for _, name := range r.Names() {
+		client, err := r.Client(name)
+		if err == nil {
+			err = client.Ping(ctx)
+		}
+		results[name] = err
+	}
+	return results
+}
+
+// Close closes every client
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	errs := []error{r.defaultClient.Close()}
+	for _, existing := range r.clients {
+		errs = append(errs, existing.client.Close())
+	}
+	r.clients = make(map[string]*datasourceClient)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,176 @@
+package prometheus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// Authentication types supported by the client
+const (
+	AuthTypeNone   = "none"
+	AuthTypeBasic  = "basic"
+	AuthTypeBearer = "bearer"
+)
+
+// NewRoundTripper builds the HTTP transport for a Prometheus-compatible endpoint:
+// TLS settings from tlsConfig, then authentication and extra headers on every request
+func NewRoundTripper(auth models.AuthConfig, tlsConfig models.TLSConfig, headers map[string]string) (http.RoundTripper, error) {
+	clientTLS, err := newTLSConfig(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the proxy, keep-alive, idle connection and handshake timeouts of the default transport
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = clientTLS
+
+	rt := &authRoundTripper{
+		next:    transport,
+		headers: headers,
+	}
+
+	switch auth.Type {
+	case "", AuthTypeNone:
+	case AuthTypeBasic:
+		if auth.Username == "" {
+			return nil, fmt.Errorf("basic auth requires a username")
+		}
+		if auth.Password == "" && auth.PasswordFile == "" {
+			return nil, fmt.Errorf("basic auth requires a password or password file")
+		}
+		rt.username = auth.Username
+		rt.password = newSecret(auth.Password, auth.PasswordFile)
+	case AuthTypeBearer:
+		if auth.Token == "" && auth.TokenFile == "" {
+			return nil, fmt.Errorf("bearer auth requires a token or token file")
+		}
+		rt.token = newSecret(auth.Token, auth.TokenFile)
+	default:
+		return nil, fmt.Errorf("auth type must be none, basic or bearer, got %q", auth.Type)
+	}
+
+	return rt, nil
+}
+
+// newTLSConfig loads the CA bundle and client certificate
+func newTLSConfig(cfg models.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, fmt.Errorf("client certificate and key files must be set together")
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// authRoundTripper adds authentication and static headers to every request
+type authRoundTripper struct {
+	next     http.RoundTripper
+	headers  map[string]string
+	username string
+	password *secret
+	token    *secret
+}
+
+// RoundTrip implements http.RoundTripper
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Requests must not be modified by round trippers
+	req = req.Clone(req.Context())
+
+	for name, value := range rt.headers {
+		req.Header.Set(name, value)
+	}
+
+	if rt.password != nil {
+		password, err := rt.password.Value()
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(rt.username, password)
+	}
+
+	if rt.token != nil {
+		token, err := rt.token.Value()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return rt.next.RoundTrip(req)
+}
+
+// secret is a credential given inline or read from a file.
+// File contents are cached and re-read when the file's size or modification time changes,
+// so rotated credentials are picked up without a restart.
+type secret struct {
+	value string
+	path  string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// newSecret creates a secret; path takes precedence over value
+func newSecret(value, path string) *secret {
+	return &secret{value: value, path: path}
+}
+
+// Value returns the current secret
+func (s *secret) Value() (string, error) {
+	if s.path == "" {
+		return s.value, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat secret file: %w", err)
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.value, nil
+	}
+
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+
+	s.value = strings.TrimSpace(string(content))
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return s.value, nil
+}
@@ -0,0 +1,104 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+func TestNewRoundTripperValidatesAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		auth models.AuthConfig
+		want string
+	}{
+		{"basic without username", models.AuthConfig{Type: AuthTypeBasic, Password: "p"}, "username"},
+		{"basic without password", models.AuthConfig{Type: AuthTypeBasic, Username: "u"}, "password"},
+		{"bearer without token", models.AuthConfig{Type: AuthTypeBearer}, "token"},
+		{"unknown type", models.AuthConfig{Type: "digest"}, "none, basic or bearer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRoundTripper(tt.auth, models.TLSConfig{}, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	_, err := NewRoundTripper(models.AuthConfig{}, models.TLSConfig{CertFile: "client.pem"}, nil)
+	if err == nil {
+		t.Errorf("certificate without key accepted")
+	}
+}
+
+// roundTrip sends a request through rt to a server recording the request headers
+func roundTrip(t *testing.T, rt http.RoundTripper) http.Header {
+	t.Helper()
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	resp, err := (&http.Client{Transport: rt}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	return received
+}
+
+func TestRoundTripperAddsHeadersAndBasicAuth(t *testing.T) {
+	rt, err := NewRoundTripper(
+		models.AuthConfig{Type: AuthTypeBasic, Username: "user", Password: "secret"},
+		models.TLSConfig{},
+		map[string]string{"X-Scope-OrgID": "team-a"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := roundTrip(t, rt)
+	if headers.Get("X-Scope-OrgID") != "team-a" {
+		t.Errorf("X-Scope-OrgID = %q", headers.Get("X-Scope-OrgID"))
+	}
+	request := &http.Request{Header: headers}
+	if user, password, ok := request.BasicAuth(); !ok || user != "user" || password != "secret" {
+		t.Errorf("basic auth = %q/%q", user, password)
+	}
+}
+
+func TestRoundTripperRereadsTokenFile(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := NewRoundTripper(models.AuthConfig{Type: AuthTypeBearer, TokenFile: tokenFile}, models.TLSConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := roundTrip(t, rt).Get("Authorization"); got != "Bearer first" {
+		t.Errorf("Authorization = %q, want Bearer first", got)
+	}
+
+	// A rotated token is picked up without recreating the transport
+	if err := os.WriteFile(tokenFile, []byte("second-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(tokenFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := roundTrip(t, rt).Get("Authorization"); got != "Bearer second-token" {
+		t.Errorf("Authorization = %q, want Bearer second-token", got)
+	}
+}
@@ -0,0 +1,252 @@
+package querydef
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/samzong/prom-etl-db/internal/config"
+	"github.com/samzong/prom-etl-db/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Actions of a query change
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// queryFile is the layout of a query definition file
+type queryFile struct {
+	Queries []yaml.Node `yaml:"queries"`
+}
+
+// QueryChange is a change applying the query definition files to query_configs
+type QueryChange struct {
+	Action string
+	ID     string
+	// Query is the definition to save; empty for deletions
+	Query models.QueryConfig
+	// Fields lists the changed fields of updates, by their YAML name
+	Fields []string
+}
+
+// QueryPlan is the set of changes that brings query_configs in line with the files
+type QueryPlan struct {
+	Changes   []QueryChange
+	Unchanged int
+	// Unmanaged lists the queries that are not in the files and are kept without prune
+	Unmanaged []string
+}
+
+// LoadQueriesFromDir loads the query definitions of every *.yaml and *.yml file below
+// dir. Each file holds a queries list; fields left out take the query_configs defaults
+// and every query is validated, so one broken file fails the whole load.
+func LoadQueriesFromDir(dir string) ([]models.QueryConfig, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read query directory: %w", err)
+	}
+
+	var queries []models.QueryConfig
+	files := make(map[string]string)
+	for _, path := range paths {
+		fileQueries, err := loadQueryFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		for _, query := range fileQueries {
+			if other, ok := files[query.ID]; ok {
+				return nil, fmt.Errorf("%s: query %s is already defined in %s", path, query.ID, other)
+			}
+			files[query.ID] = path
+			queries = append(queries, query)
+		}
+	}
+
+	return queries, nil
+}
+
+// loadQueryFile loads and validates the queries of one definition file
+func loadQueryFile(path string) ([]models.QueryConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unknown fields are rejected first, so a misspelled field is not silently ignored
+	strict := yaml.NewDecoder(bytes.NewReader(content))
+	strict.KnownFields(true)
+	var check struct {
+		Queries []models.QueryConfig `yaml:"queries"`
+	}
+	if err := strict.Decode(&check); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	var file queryFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, err
+	}
+
+	queries := make([]models.QueryConfig, 0, len(file.Queries))
+	for i := range file.Queries {
+		// Decoding over the defaults keeps them for the fields left out
+		query := models.QueryConfig{
+			Timeout:       "30s",
+			Enabled:       true,
+			RetryCount:    3,
+			RetryInterval: "10s",
+		}
+		if err := file.Queries[i].Decode(&query); err != nil {
+			return nil, err
+		}
+		if err := ValidateQuery(query); err != nil {
+			return nil, fmt.Errorf("query[%d] %s: %w", i, query.ID, err)
+		}
+		queries = append(queries, normalizeQuery(query))
+	}
+
+	return queries, nil
+}
+
+// PlanQueries compares the desired queries with the current rows of query_configs.
+// Queries missing from desired are deleted with prune and reported as unmanaged otherwise.
+func PlanQueries(desired, current []models.QueryConfig, prune bool) QueryPlan {
+	var plan QueryPlan
+
+	existing := make(map[string]models.QueryConfig, len(current))
+	for _, query := range current {
+		existing[query.ID] = normalizeQuery(query)
+	}
+
+	wanted := make(map[string]bool, len(desired))
+	for _, query := range desired {
+		wanted[query.ID] = true
+		query = normalizeQuery(query)
+
+		stored, ok := existing[query.ID]
+		if !ok {
+			plan.Changes = append(plan.Changes, QueryChange{Action: ActionCreate, ID: query.ID, Query: query})
+			continue
+		}
+
+		fields := changedFields(stored, query)
+		if len(fields) == 0 {
+			plan.Unchanged++
+			continue
+		}
+		plan.Changes = append(plan.Changes, QueryChange{Action: ActionUpdate, ID: query.ID, Query: query, Fields: fields})
+	}
+
+	for _, query := range current {
+		if wanted[query.ID] {
+			continue
+		}
+		if prune {
+			plan.Changes = append(plan.Changes, QueryChange{Action: ActionDelete, ID: query.ID})
+		} else {
+			plan.Unmanaged = append(plan.Unmanaged, query.ID)
+		}
+	}
+
+	// Deletions come first, in case a query moves to a new ID
+	sort.SliceStable(plan.Changes, func(i, j int) bool {
+		return plan.Changes[i].Action == ActionDelete && plan.Changes[j].Action != ActionDelete
+	})
+
+	return plan
+}
+
+// ApplyQueryPlan saves and deletes the planned queries in one transaction,
+// so a failure leaves query_configs unchanged
+func ApplyQueryPlan(db *sql.DB, plan QueryPlan) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, change := range plan.Changes {
+		var err error
+		if change.Action == ActionDelete {
+			err = config.DeleteQueryFromDB(tx, change.ID)
+		} else {
+			err = config.SaveQueryToDB(tx, change.Query)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to %s query %s: %w", change.Action, change.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit query changes: %w", err)
+	}
+	return nil
+}
+
+// normalizeQuery turns empty lists into nil, as they are stored as NULL
+func normalizeQuery(query models.QueryConfig) models.QueryConfig {
+	if len(query.RelabelConfigs) == 0 {
+		query.RelabelConfigs = nil
+	} else {
+		rules := make([]models.RelabelConfig, len(query.RelabelConfigs))
+		for i, rule := range query.RelabelConfigs {
+			if len(rule.SourceLabels) == 0 {
+				rule.SourceLabels = nil
+			}
+			rules[i] = rule
+		}
+		query.RelabelConfigs = rules
+	}
+	if len(query.PromotedLabels) == 0 {
+		query.PromotedLabels = nil
+	}
+	if query.Retention != nil && len(query.Retention.Downsample) == 0 {
+		retention := *query.Retention
+		retention.Downsample = nil
+		query.Retention = &retention
+	}
+	return query
+}
+
+// changedFields returns the YAML names of the fields that differ between stored and query
+func changedFields(stored, query models.QueryConfig) []string {
+	var fields []string
+	storedValue, queryValue := reflect.ValueOf(stored), reflect.ValueOf(query)
+	for i := 0; i < storedValue.NumField(); i++ {
+		name, _, _ := strings.Cut(storedValue.Type().Field(i).Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		if !reflect.DeepEqual(storedValue.Field(i).Interface(), queryValue.Field(i).Interface()) {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
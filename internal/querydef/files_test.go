@@ -0,0 +1,172 @@
+package querydef
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+func testQuery(id, schedule string) models.QueryConfig {
+	return models.QueryConfig{
+		ID:            id,
+		Name:          id,
+		Query:         "up",
+		Schedule:      schedule,
+		Timeout:       "30s",
+		Enabled:       true,
+		RetryCount:    3,
+		RetryInterval: "10s",
+	}
+}
+
+// actions returns the changes of plan as "action id"
+func actions(plan QueryPlan) []string {
+	var result []string
+	for _, change := range plan.Changes {
+		result = append(result, change.Action+" "+change.ID)
+	}
+	return result
+}
+
+func TestPlanQueries(t *testing.T) {
+	current := []models.QueryConfig{
+		testQuery("kept", "0 * * * * *"),
+		testQuery("changed", "0 * * * * *"),
+		testQuery("removed", "0 * * * * *"),
+	}
+	changed := testQuery("changed", "0 0 * * * *")
+	changed.Timeout = "60s"
+	desired := []models.QueryConfig{
+		testQuery("kept", "0 * * * * *"),
+		changed,
+		testQuery("added", "0 * * * * *"),
+	}
+
+	tests := []struct {
+		name      string
+		prune     bool
+		want      []string
+		unmanaged []string
+	}{
+		{
+			name:      "without prune",
+			want:      []string{"update changed", "create added"},
+			unmanaged: []string{"removed"},
+		},
+		{
+			// Deletions come first, so a query can move to a new ID
+			name:  "with prune",
+			prune: true,
+			want:  []string{"delete removed", "update changed", "create added"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := PlanQueries(desired, current, tt.prune)
+			if got := actions(plan); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changes = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(plan.Unmanaged, tt.unmanaged) {
+				t.Errorf("unmanaged = %v, want %v", plan.Unmanaged, tt.unmanaged)
+			}
+			if plan.Unchanged != 1 {
+				t.Errorf("unchanged = %d, want 1", plan.Unchanged)
+			}
+			for _, change := range plan.Changes {
+				if change.Action == ActionUpdate && !reflect.DeepEqual(change.Fields, []string{"schedule", "timeout"}) {
+					t.Errorf("changed fields = %v, want schedule and timeout", change.Fields)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanQueriesIgnoresEmptyLists(t *testing.T) {
+	stored := testQuery("q", "0 * * * * *")
+	desired := stored
+	desired.PromotedLabels = []string{}
+	desired.RelabelConfigs = []models.RelabelConfig{}
+
+	plan := PlanQueries([]models.QueryConfig{desired}, []models.QueryConfig{stored}, false)
+	if len(plan.Changes) != 0 || plan.Unchanged != 1 {
+		t.Errorf("changes = %v, want none", actions(plan))
+	}
+}
+
+func writeQueryFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadQueriesFromDir(t *testing.T) {
+	dir := t.TempDir()
+	writeQueryFile(t, dir, "a.yaml", `
+queries:
+  - id: cpu
+    name: CPU
+    query: sum(rate(node_cpu_seconds_total[5m]))
+    schedule: "0 * * * * *"
+    timeout: 60s
+`)
+	writeQueryFile(t, dir, "notes.txt", "not a query file")
+
+	queries, err := LoadQueriesFromDir(dir)
+	if err != nil {
+		t.Fatalf("LoadQueriesFromDir: %v", err)
+	}
+	if len(queries) != 1 {
+		t.Fatalf("got %d queries, want 1", len(queries))
+	}
+	// Fields left out take the query_configs defaults
+	query := queries[0]
+	if query.Timeout != "60s" || !query.Enabled || query.RetryCount != 3 || query.RetryInterval != "10s" {
+		t.Errorf("query = %+v, want defaults for the fields left out", query)
+	}
+}
+
+func TestLoadQueriesFromDirErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name:  "unknown field",
+			files: map[string]string{"a.yaml": "queries:\n  - id: q\n    name: q\n    query: up\n    schedule: \"0 * * * * *\"\n    shedule: x\n"},
+			want:  "shedule",
+		},
+		{
+			name:  "invalid query",
+			files: map[string]string{"a.yaml": "queries:\n  - id: q\n    name: q\n    query: up\n    schedule: hourly\n"},
+			want:  "query[0] q",
+		},
+		{
+			name: "duplicate id",
+			files: map[string]string{
+				"a.yaml": "queries:\n  - id: q\n    name: q\n    query: up\n    schedule: \"0 * * * * *\"\n",
+				"b.yml":  "queries:\n  - id: q\n    name: q\n    query: up\n    schedule: \"0 * * * * *\"\n",
+			},
+			want: "already defined",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeQueryFile(t, dir, name, content)
+			}
+			_, err := LoadQueriesFromDir(dir)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
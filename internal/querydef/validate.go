@@ -0,0 +1,79 @@
+package querydef
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/config"
+	"github.com/samzong/prom-etl-db/internal/models"
+	"github.com/samzong/prom-etl-db/internal/prometheus"
+	"github.com/samzong/prom-etl-db/internal/scheduler"
+)
+
+// ValidateQuery checks a query configuration before it is saved, including the
+// schedule, durations and time range that are otherwise only checked when it runs
+func ValidateQuery(query models.QueryConfig) error {
+	if query.ID == "" {
+		return fmt.Errorf("id is required")
+	}
+	if len(query.ID) > 100 {
+		return fmt.Errorf("id is longer than 100 characters")
+	}
+	if query.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if query.Query == "" {
+		return fmt.Errorf("query is required")
+	}
+	if _, err := scheduler.ParseSchedule(query.Schedule); err != nil {
+		return err
+	}
+
+	if query.Timeout != "" {
+		if timeout, err := time.ParseDuration(query.Timeout); err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", query.Timeout)
+		}
+	}
+	if query.RetryCount < 0 {
+		return fmt.Errorf("retry count must not be negative")
+	}
+	if query.RetryInterval != "" {
+		if _, err := time.ParseDuration(query.RetryInterval); err != nil {
+			return fmt.Errorf("invalid retry interval %q", query.RetryInterval)
+		}
+	}
+
+	if err := validateTimeRange(query.TimeRange); err != nil {
+		return err
+	}
+
+	return config.ValidateQueryOptions(query)
+}
+
+// validateTimeRange checks that the time expressions of a time range can be resolved
+func validateTimeRange(timeRange *models.TimeRangeConfig) error {
+	if timeRange == nil {
+		return nil
+	}
+
+	resolver := prometheus.NewRelativeTimeResolver(time.Now())
+	switch timeRange.Type {
+	case "instant":
+		if timeRange.Time != "" {
+			if _, err := resolver.ResolveTime(timeRange.Time); err != nil {
+				return fmt.Errorf("invalid time range: %w", err)
+			}
+		}
+	case "range":
+		if _, _, err := resolver.ResolveRangeTime(timeRange.Start, timeRange.End); err != nil {
+			return fmt.Errorf("invalid time range: %w", err)
+		}
+		if step, err := time.ParseDuration(timeRange.Step); err != nil || step <= 0 {
+			return fmt.Errorf("invalid time range step %q", timeRange.Step)
+		}
+	default:
+		return fmt.Errorf("time range type must be instant or range, got %q", timeRange.Type)
+	}
+
+	return nil
+}
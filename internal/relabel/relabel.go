@@ -0,0 +1,184 @@
+package relabel
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// Relabeling actions, as in Prometheus' relabel_config
+const (
+	ActionReplace   = "replace"
+	ActionKeep      = "keep"
+	ActionDrop      = "drop"
+	ActionHashMod   = "hashmod"
+	ActionLabelMap  = "labelmap"
+	ActionLabelDrop = "labeldrop"
+	ActionLabelKeep = "labelkeep"
+)
+
+// Defaults of empty RelabelConfig fields
+const (
+	defaultSeparator   = ";"
+	defaultRegex       = "(.*)"
+	defaultReplacement = "$1"
+)
+
+// Rule is a compiled relabeling rule
+type Rule struct {
+	sourceLabels []model.LabelName
+	separator    string
+	regex        *regexp.Regexp
+	modulus      uint64
+	targetLabel  string
+	replacement  string
+	action       string
+}
+
+// Compile validates configs and compiles them into rules
+func Compile(configs []models.RelabelConfig) ([]*Rule, error) {
+	rules := make([]*Rule, 0, len(configs))
+	for i, cfg := range configs {
+		rule, err := compile(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("relabel rule %d: %w", i, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// compile applies the defaults of a single rule and validates it
+func compile(cfg models.RelabelConfig) (*Rule, error) {
+	rule := &Rule{
+		separator:   cfg.Separator,
+		modulus:     cfg.Modulus,
+		targetLabel: cfg.TargetLabel,
+		replacement: defaultReplacement,
+		action:      strings.ToLower(cfg.Action),
+	}
+	if rule.separator == "" {
+		rule.separator = defaultSeparator
+	}
+	if cfg.Replacement != nil {
+		rule.replacement = *cfg.Replacement
+	}
+	if rule.action == "" {
+		rule.action = ActionReplace
+	}
+	for _, name := range cfg.SourceLabels {
+		rule.sourceLabels = append(rule.sourceLabels, model.LabelName(name))
+	}
+
+	expr := cfg.Regex
+	if expr == "" {
+		expr = defaultRegex
+	}
+	// Like Prometheus, regexes must match the whole value
+	regex, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", expr, err)
+	}
+	rule.regex = regex
+
+	switch rule.action {
+	case ActionReplace, ActionHashMod:
+		if rule.targetLabel == "" {
+			return nil, fmt.Errorf("%s requires target_label", rule.action)
+		}
+		if rule.action == ActionHashMod && rule.modulus == 0 {
+			return nil, fmt.Errorf("hashmod requires a positive modulus")
+		}
+	case ActionKeep, ActionDrop, ActionLabelMap:
+	case ActionLabelDrop, ActionLabelKeep:
+		if len(rule.sourceLabels) > 0 || rule.targetLabel != "" {
+			return nil, fmt.Errorf("%s only takes a regex", rule.action)
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q", cfg.Action)
+	}
+
+	return rule, nil
+}
+
+// Process applies rules in order to the labels of a series, including __name__.
+// It reports false when a rule dropped the series.
+func Process(metric model.Metric, rules []*Rule) bool {
+	for _, rule := range rules {
+		if !rule.apply(metric) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply applies one rule, modifying metric in place
+func (r *Rule) apply(metric model.Metric) bool {
+	values := make([]string, len(r.sourceLabels))
+	for i, name := range r.sourceLabels {
+		values[i] = string(metric[name])
+	}
+	value := strings.Join(values, r.separator)
+
+	switch r.action {
+	case ActionKeep:
+		return r.regex.MatchString(value)
+
+	case ActionDrop:
+		return !r.regex.MatchString(value)
+
+	case ActionReplace:
+		indexes := r.regex.FindStringSubmatchIndex(value)
+		if indexes == nil {
+			break
+		}
+		target := model.LabelName(r.regex.ExpandString(nil, r.targetLabel, value, indexes))
+		if !target.IsValid() {
+			break
+		}
+		result := r.regex.ExpandString(nil, r.replacement, value, indexes)
+		if len(result) == 0 {
+			delete(metric, target)
+			break
+		}
+		metric[target] = model.LabelValue(result)
+
+	case ActionHashMod:
+		sum := md5.Sum([]byte(value))
+		mod := binary.BigEndian.Uint64(sum[8:]) % r.modulus
+		metric[model.LabelName(r.targetLabel)] = model.LabelValue(strconv.FormatUint(mod, 10))
+
+	case ActionLabelMap:
+		mapped := make(model.Metric)
+		for name, labelValue := range metric {
+			if r.regex.MatchString(string(name)) {
+				mapped[model.LabelName(r.regex.ReplaceAllString(string(name), r.replacement))] = labelValue
+			}
+		}
+		for name, labelValue := range mapped {
+			metric[name] = labelValue
+		}
+
+	case ActionLabelDrop:
+		for name := range metric {
+			if r.regex.MatchString(string(name)) {
+				delete(metric, name)
+			}
+		}
+
+	case ActionLabelKeep:
+		for name := range metric {
+			if !r.regex.MatchString(string(name)) {
+				delete(metric, name)
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,176 @@
+package relabel
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []models.RelabelConfig
+		input   model.Metric
+		// want is nil when the series is dropped
+		want model.Metric
+	}{
+		{
+			name:    "replace with defaults copies the value",
+			configs: []models.RelabelConfig{{SourceLabels: []string{"cluster_name"}, TargetLabel: "cluster"}},
+			input:   model.Metric{"cluster_name": "prod"},
+			want:    model.Metric{"cluster_name": "prod", "cluster": "prod"},
+		},
+		{
+			name: "replace joins source labels with the separator",
+			configs: []models.RelabelConfig{{
+				SourceLabels: []string{"a", "b"},
+				Separator:    "/",
+				Regex:        "(.*)/(.*)",
+				TargetLabel:  "c",
+				Replacement:  strPtr("$2-$1"),
+			}},
+			input: model.Metric{"a": "x", "b": "y"},
+			want:  model.Metric{"a": "x", "b": "y", "c": "y-x"},
+		},
+		{
+			name:    "replace regex is anchored",
+			configs: []models.RelabelConfig{{SourceLabels: []string{"a"}, Regex: "fo", TargetLabel: "b"}},
+			input:   model.Metric{"a": "foo"},
+			want:    model.Metric{"a": "foo"},
+		},
+		{
+			name:    "replace with an empty result deletes the target",
+			configs: []models.RelabelConfig{{SourceLabels: []string{"a"}, TargetLabel: "pod", Replacement: strPtr("")}},
+			input:   model.Metric{"a": "x", "pod": "p-1"},
+			want:    model.Metric{"a": "x"},
+		},
+		{
+			name:    "replace expands the target label",
+			configs: []models.RelabelConfig{{SourceLabels: []string{"a"}, Regex: "(.+)=(.+)", TargetLabel: "${1}", Replacement: strPtr("$2")}},
+			input:   model.Metric{"a": "env=prod"},
+			want:    model.Metric{"a": "env=prod", "env": "prod"},
+		},
+		{
+			name:    "keep keeps matching series",
+			configs: []models.RelabelConfig{{Action: "keep", SourceLabels: []string{"env"}, Regex: "prod|staging"}},
+			input:   model.Metric{"env": "prod"},
+			want:    model.Metric{"env": "prod"},
+		},
+		{
+			name:    "keep regex is anchored",
+			configs: []models.RelabelConfig{{Action: "keep", SourceLabels: []string{"env"}, Regex: "prod"}},
+			input:   model.Metric{"env": "preprod"},
+			want:    nil,
+		},
+		{
+			name:    "drop drops matching series",
+			configs: []models.RelabelConfig{{Action: "drop", SourceLabels: []string{"env"}, Regex: "dev"}},
+			input:   model.Metric{"env": "dev"},
+			want:    nil,
+		},
+		{
+			name:    "drop matches the empty value of missing labels",
+			configs: []models.RelabelConfig{{Action: "DROP", SourceLabels: []string{"env"}, Regex: ""}},
+			input:   model.Metric{"job": "node"},
+			want:    nil,
+		},
+		{
+			name:    "drop keeps other series",
+			configs: []models.RelabelConfig{{Action: "drop", SourceLabels: []string{"env"}, Regex: "dev"}},
+			input:   model.Metric{"env": "development"},
+			want:    model.Metric{"env": "development"},
+		},
+		{
+			// Same value as Prometheus, which hashes the lower 8 bytes of the MD5 sum
+			name:    "hashmod",
+			configs: []models.RelabelConfig{{Action: "hashmod", SourceLabels: []string{"c"}, Modulus: 1000, TargetLabel: "d"}},
+			input:   model.Metric{"c": "baz"},
+			want:    model.Metric{"c": "baz", "d": "976"},
+		},
+		{
+			name:    "labelmap copies matching labels",
+			configs: []models.RelabelConfig{{Action: "labelmap", Regex: "meta_(.+)"}},
+			input:   model.Metric{"meta_zone": "a", "job": "node"},
+			want:    model.Metric{"meta_zone": "a", "zone": "a", "job": "node"},
+		},
+		{
+			name:    "labeldrop removes matching labels",
+			configs: []models.RelabelConfig{{Action: "labeldrop", Regex: "pod|container"}},
+			input:   model.Metric{"pod": "p", "container": "c", "pod_ip": "10.0.0.1"},
+			want:    model.Metric{"pod_ip": "10.0.0.1"},
+		},
+		{
+			name:    "labelkeep removes other labels",
+			configs: []models.RelabelConfig{{Action: "labelkeep", Regex: "__name__|job"}},
+			input:   model.Metric{"__name__": "up", "job": "node", "jobs": "x", "instance": "a"},
+			want:    model.Metric{"__name__": "up", "job": "node"},
+		},
+		{
+			name: "rules run in order",
+			configs: []models.RelabelConfig{
+				{SourceLabels: []string{"cluster_name"}, TargetLabel: "cluster"},
+				{Action: "labeldrop", Regex: "cluster_name"},
+				{Action: "keep", SourceLabels: []string{"cluster"}, Regex: "prod"},
+			},
+			input: model.Metric{"cluster_name": "prod"},
+			want:  model.Metric{"cluster": "prod"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := Compile(tt.configs)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+
+			metric := tt.input.Clone()
+			kept := Process(metric, rules)
+			if tt.want == nil {
+				if kept {
+					t.Errorf("series kept as %v, want dropped", metric)
+				}
+				return
+			}
+			if !kept {
+				t.Fatalf("series dropped, want %v", tt.want)
+			}
+			if !metric.Equal(tt.want) {
+				t.Errorf("labels = %v, want %v", metric, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config models.RelabelConfig
+		want   string
+	}{
+		{"unknown action", models.RelabelConfig{Action: "rename"}, "unknown action"},
+		{"invalid regex", models.RelabelConfig{Regex: "(", TargetLabel: "a"}, "invalid regex"},
+		{"replace without target", models.RelabelConfig{SourceLabels: []string{"a"}}, "requires target_label"},
+		{"hashmod without modulus", models.RelabelConfig{Action: "hashmod", TargetLabel: "a"}, "positive modulus"},
+		{"labeldrop with source labels", models.RelabelConfig{Action: "labeldrop", SourceLabels: []string{"a"}}, "only takes a regex"},
+		{"labelkeep with target", models.RelabelConfig{Action: "labelkeep", TargetLabel: "a"}, "only takes a regex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile([]models.RelabelConfig{{Action: "keep"}, tt.config})
+			if err == nil {
+				t.Fatal("Compile succeeded")
+			}
+			if !strings.Contains(err.Error(), "relabel rule 1") || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want rule 1 and %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/samzong/prom-etl-db/internal/executor"
+	"github.com/samzong/prom-etl-db/internal/logger"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// parser parses six-field cron expressions with seconds, as used by query_configs.schedule
+var parser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// ParseSchedule parses a query schedule expression
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	schedule, err := parser.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+	return schedule, nil
+}
+
+// QueryLoader loads the enabled query configurations and the errors of the
+// queries that could not be loaded, by query ID
+type QueryLoader func() ([]models.QueryConfig, map[string]error, error)
+
+// Scheduler keeps cron entries in sync with the query configurations
+type Scheduler struct {
+	cron    *cron.Cron
+	pool    *executor.Pool
+	load    QueryLoader
+	logger  *slog.Logger
+	mu      sync.RWMutex
+	entries map[string]*scheduledQuery
+}
+
+// scheduledQuery tracks the cron entry registered for a query
+type scheduledQuery struct {
+	entryID cron.EntryID
+	config  models.QueryConfig
+}
+
+// ReloadResult summarizes the changes applied by a reload
+type ReloadResult struct {
+	Added     []string          `json:"added"`
+	Updated   []string          `json:"updated"`
+	Removed   []string          `json:"removed"`
+	Unchanged int               `json:"unchanged"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+// Changed reports whether the reload modified any cron entries
+func (r *ReloadResult) Changed() bool {
+	return len(r.Added)+len(r.Updated)+len(r.Removed) > 0
+}
+
+// NewScheduler creates a new scheduler with second precision cron expressions.
+// Fired queries are submitted to pool, which enforces concurrency and overlap limits.
+func NewScheduler(pool *executor.Pool, load QueryLoader, baseLogger *slog.Logger) *Scheduler {
+	return &Scheduler{
+		cron:    cron.New(cron.WithParser(parser)),
+		pool:    pool,
+		load:    load,
+		logger:  logger.WithComponent(baseLogger, "scheduler"),
+		entries: make(map[string]*scheduledQuery),
+	}
+}
+
+// Start starts the cron scheduler
+func (s *Scheduler) Start() {
+	s.cron.Start()
+	s.logger.Info("Cron scheduler started")
+}
+
+// Stop stops the cron scheduler; the returned context is done when running jobs have submitted their work
+func (s *Scheduler) Stop() context.Context {
+	return s.cron.Stop()
+}
+
+// Queries returns the currently scheduled query configurations ordered by ID
+func (s *Scheduler) Queries() []models.QueryConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	queries := make([]models.QueryConfig, 0, len(s.entries))
+	for _, entry := range s.entries {
+		queries = append(queries, entry.config)
+	}
+	sort.Slice(queries, func(i, j int) bool { return queries[i].ID < queries[j].ID })
+	return queries
+}
+
+// Reload re-reads the query configurations and applies the differences
+func (s *Scheduler) Reload() (*ReloadResult, error) {
+	queries, invalid, err := s.load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load queries: %w", err)
+	}
+	return s.apply(queries, invalid), nil
+}
+
+// Apply adds, removes or reschedules cron entries so they match queries.
+// Running executions are never interrupted; a rescheduled query picks up its
+// new configuration on the next tick. Queries that fail validation keep their
+// previous entry, if any, and are reported in the result.
+func (s *Scheduler) Apply(queries []models.QueryConfig) *ReloadResult {
+	return s.apply(queries, nil)
+}
+
+// apply implements Apply; the queries in invalid failed to load and are handled
+// like queries failing validation
+func (s *Scheduler) apply(queries []models.QueryConfig, invalid map[string]error) *ReloadResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &ReloadResult{Errors: make(map[string]string)}
+	desired := make(map[string]models.QueryConfig, len(queries))
+
+	for id, err := range invalid {
+		result.Errors[id] = err.Error()
+		s.logger.Error("Failed to load query", "query_id", id, "error", err)
+	}
+
+	for _, query := range queries {
+		if !query.Enabled {
+			continue
+		}
+		if query.ID == "" {
+			result.Errors["<empty>"] = "query ID is required"
+			continue
+		}
+		if query.Query == "" {
+			result.Errors[query.ID] = "query is required"
+			continue
+		}
+		desired[query.ID] = query
+	}
+
+	// Remove queries that are no longer enabled
+	for id, entry := range s.entries {
+		if _, ok := desired[id]; ok {
+			continue
+		}
+		if _, invalid := result.Errors[id]; invalid {
+			continue
+		}
+		s.cron.Remove(entry.entryID)
+		delete(s.entries, id)
+		result.Removed = append(result.Removed, id)
+		s.logger.Info("Query unscheduled", "query_id", id)
+	}
+
+	// Add new queries and reschedule changed ones
+	for id, query := range desired {
+		existing, ok := s.entries[id]
+		if ok && reflect.DeepEqual(existing.config, query) {
+			result.Unchanged++
+			continue
+		}
+
+		entryID, err := s.schedule(query)
+		if err != nil {
+			result.Errors[id] = err.Error()
+			s.logger.Error("Failed to schedule query", "query_id", id, "schedule", query.Schedule, "error", err)
+			continue
+		}
+
+		if ok {
+			s.cron.Remove(existing.entryID)
+			result.Updated = append(result.Updated, id)
+			s.logger.Info("Query rescheduled",
+				"query_id", id,
+				"name", query.Name,
+				"schedule", query.Schedule,
+				"updated_at", query.UpdatedAt)
+		} else {
+			result.Added = append(result.Added, id)
+			s.logger.Info("Query scheduled successfully",
+				"query_id", id,
+				"name", query.Name,
+				"schedule", query.Schedule)
+		}
+		s.entries[id] = &scheduledQuery{entryID: entryID, config: query}
+	}
+
+	sort.Strings(result.Added)
+	sort.Strings(result.Updated)
+	sort.Strings(result.Removed)
+	if len(result.Errors) == 0 {
+		result.Errors = nil
+	}
+
+	return result
+}
+
+// RunReloadLoop reloads the configuration every interval until ctx is done
+func (s *Scheduler) RunReloadLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.ReloadAndLog("interval")
+		}
+	}
+}
+
+// ReloadAndLog reloads the configuration and logs the outcome
+func (s *Scheduler) ReloadAndLog(trigger string) {
+	result, err := s.Reload()
+	if err != nil {
+		logger.WithError(s.logger, err).Error("Query configuration reload failed", "trigger", trigger)
+		return
+	}
+
+	if result.Changed() || len(result.Errors) > 0 {
+		s.logger.Info("Query configuration reloaded",
+			"trigger", trigger,
+			"added", result.Added,
+			"updated", result.Updated,
+			"removed", result.Removed,
+			"errors", result.Errors)
+	} else {
+		s.logger.Debug("Query configuration unchanged", "trigger", trigger)
+	}
+}
+
+// schedule registers a cron entry for query
+func (s *Scheduler) schedule(query models.QueryConfig) (cron.EntryID, error) {
+	schedule, err := ParseSchedule(query.Schedule)
+	if err != nil {
+		return 0, err
+	}
+
+	job := &queryJob{
+		scheduler: s,
+		config:    query,
+		schedule:  schedule,
+		lastFire:  time.Now(),
+	}
+	return s.cron.Schedule(schedule, job), nil
+}
+
+// queryJob is the cron job executing a single query
+type queryJob struct {
+	scheduler *Scheduler
+	config    models.QueryConfig
+	schedule  cron.Schedule
+
+	mu       sync.Mutex
+	lastFire time.Time
+}
+
+// Run submits the query to the worker pool with the scheduled fire time as evaluation time
+func (j *queryJob) Run() {
+	s := j.scheduler
+	q := j.config
+	fireTime := j.fireTime(time.Now())
+
+	s.logger.Info("Submitting scheduled query",
+		"query_id", q.ID,
+		"name", q.Name,
+		"schedule", q.Schedule,
+		"fire_time", fireTime.Format(time.RFC3339))
+
+	s.pool.Submit(q, fireTime)
+}
+
+// fireTime returns the latest scheduled activation at or before now.
+// cron does not pass the activation time to jobs, so it is derived from the
+// schedule, starting at the previous activation to stay cheap.
+func (j *queryJob) fireTime(now time.Time) time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	fire := time.Time{}
+	for next := j.schedule.Next(j.lastFire); !next.IsZero() && !next.After(now); next = j.schedule.Next(next) {
+		fire = next
+	}
+	if fire.IsZero() {
+		// Fired earlier than expected (clock adjustment); fall back to the current second
+		fire = now.Truncate(time.Second)
+	}
+
+	j.lastFire = fire
+	return fire
+}
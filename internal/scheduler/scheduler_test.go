@@ -0,0 +1,52 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryJobFireTime(t *testing.T) {
+	schedule, err := parser.Parse("0 * * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(minute, second, millisecond int) time.Time {
+		return time.Date(2024, 3, 1, 10, minute, second, millisecond*int(time.Millisecond), time.UTC)
+	}
+
+	job := &queryJob{schedule: schedule, lastFire: at(0, 30, 0)}
+	steps := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{"cron fires late", at(1, 0, 2), at(1, 0, 0)},
+		{"missed activations are skipped", at(3, 10, 0), at(3, 0, 0)},
+		{"cron fires early", at(3, 59, 999), at(3, 59, 0)},
+		{"next activation after the fallback", at(4, 0, 1), at(4, 0, 0)},
+		{"clock stepped back", at(2, 30, 400), at(2, 30, 0)},
+		{"activations follow the new clock", at(2, 31, 5), at(2, 31, 0)},
+	}
+
+	// Steps run in order, as every call starts at the previous fire time
+	for _, step := range steps {
+		if got := job.fireTime(step.now); !got.Equal(step.want) {
+			t.Errorf("%s: fireTime(%s) = %s, want %s", step.name,
+				step.now.Format(time.StampMilli), got.Format(time.StampMilli), step.want.Format(time.StampMilli))
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	for _, spec := range []string{"0 0 1 * * *", "*/30 * * * * *", "@hourly"} {
+		if _, err := ParseSchedule(spec); err != nil {
+			t.Errorf("ParseSchedule(%q): %v", spec, err)
+		}
+	}
+	// Schedules have a seconds field
+	for _, spec := range []string{"0 1 * * *", "", "hourly"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded", spec)
+		}
+	}
+}
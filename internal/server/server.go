@@ -0,0 +1,328 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/config"
+	"github.com/samzong/prom-etl-db/internal/database"
+	"github.com/samzong/prom-etl-db/internal/logger"
+	"github.com/samzong/prom-etl-db/internal/models"
+	"github.com/samzong/prom-etl-db/internal/prometheus"
+	"github.com/samzong/prom-etl-db/internal/scheduler"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 10000
+)
+
+// QueryScheduler exposes the scheduled queries and reloads their configuration
+type QueryScheduler interface {
+	Queries() []models.QueryConfig
+	Reload() (*scheduler.ReloadResult, error)
+}
+
+// Server exposes health, readiness and inspection endpoints over HTTP
+type Server struct {
+	httpServer  *http.Server
+	db          *database.DB
+	promClients *prometheus.Registry
+	scheduler   QueryScheduler
+	logger      *slog.Logger
+}
+
+// response is the JSON envelope returned by all endpoints
+type response struct {
+	Status string      `json:"status"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// NewServer creates a new HTTP server listening on the given port
+func NewServer(port int, db *database.DB, promClients *prometheus.Registry, sched QueryScheduler, baseLogger *slog.Logger) *Server {
+	s := &Server{
+		db:          db,
+		promClients: promClients,
+		scheduler:   sched,
+		logger:      logger.WithComponent(baseLogger, "http-server"),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/ready", s.handleReady)
+	mux.HandleFunc("/api/v1/queries", s.handleQueries)
+	mux.HandleFunc("/api/v1/queries/", s.handleQuery)
+	mux.HandleFunc("/api/v1/stats", s.handleStats)
+	mux.HandleFunc("/api/v1/reload", s.handleReload)
+
+	s.httpServer = &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           s.logRequests(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s
+}
+
+// Start binds the listener and serves requests in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.httpServer.Addr, err)
+	}
+
+	s.logger.Info("HTTP server started", "addr", listener.Addr().String())
+
+	go func() {
+		if err := s.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.WithError(s.logger, err).Error("HTTP server stopped unexpectedly")
+		}
+	}()
+
+	return nil
+}
+
+// Shutdown gracefully stops the server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// handleHealth reports that the process is alive
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReady reports whether MySQL and Prometheus are reachable.
+// Only the default Prometheus endpoint affects readiness; other datasources are reported as prometheus:<name>.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	checks := map[string]string{
+		"mysql":      "ok",
+		"prometheus": "ok",
+	}
+	ready := true
+
+	if err := s.db.TestConnection(); err != nil {
+		checks["mysql"] = err.Error()
+		ready = false
+	}
+	for name, err := range s.promClients.Ping(ctx) {
+		key := "prometheus"
+		if name != prometheus.DefaultDatasource {
+			key = "prometheus:" + name
+		}
+		checks[key] = "ok"
+		if err != nil {
+			checks[key] = err.Error()
+			if name == prometheus.DefaultDatasource {
+				ready = false
+			}
+		}
+	}
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, response{Status: statusText(ready), Data: checks})
+}
+
+// handleQueries lists all scheduled queries
+func (s *Server) handleQueries(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeSuccess(w, s.scheduler.Queries())
+}
+
+// handleQuery serves /api/v1/queries/{id}[/executions|/maintenance|/metrics]
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/queries/"), "/"), "/")
+	if len(parts) == 0 || parts[0] == "" || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	queryID := parts[0]
+	query, err := s.findQuery(queryID)
+	if errors.Is(err, config.ErrQueryNotFound) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("query not found: %s", queryID))
+		return
+	}
+	if err != nil {
+		logger.WithError(s.logger, err).Error("Failed to load query", "query_id", queryID)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(parts) == 1 {
+		writeSuccess(w, query)
+		return
+	}
+
+	limit, err := parseLimit(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch parts[1] {
+	case "executions":
+		executions, err := s.db.GetQueryExecutions(queryID, limit)
+		if err != nil {
+			logger.WithError(s.logger, err).Error("Failed to get query executions", "query_id", queryID)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeSuccess(w, executions)
+	case "maintenance":
+		runs, err := s.db.GetMaintenanceRuns(queryID, limit)
+		if err != nil {
+			logger.WithError(s.logger, err).Error("Failed to get maintenance runs", "query_id", queryID)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeSuccess(w, runs)
+	case "metrics":
+		records, err := s.db.GetLatestMetrics(queryID, limit)
+		if err != nil {
+			logger.WithError(s.logger, err).Error("Failed to get latest metrics", "query_id", queryID)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeSuccess(w, records)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// handleStats returns database statistics
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	stats, err := s.db.GetDatabaseStats()
+	if err != nil {
+		logger.WithError(s.logger, err).Error("Failed to get database stats")
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeSuccess(w, stats)
+}
+
+// handleReload re-reads query_configs and reschedules changed queries
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	result, err := s.scheduler.Reload()
+	if err != nil {
+		logger.WithError(s.logger, err).Error("Query configuration reload failed", "trigger", "http")
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.logger.Info("Query configuration reloaded",
+		"trigger", "http",
+		"added", result.Added,
+		"updated", result.Updated,
+		"removed", result.Removed,
+		"errors", result.Errors)
+	writeSuccess(w, result)
+}
+
+// findQuery looks up a query configuration by ID in query_configs, so disabled
+// queries that are no longer scheduled still expose their history
+func (s *Server) findQuery(queryID string) (*models.QueryConfig, error) {
+	return config.LoadQueryFromDB(s.db.GetConn(), queryID)
+}
+
+// logRequests logs every request at debug level
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		s.logger.Debug("HTTP request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"remote_addr", r.RemoteAddr,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
+
+// parseLimit reads the limit query parameter
+func parseLimit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid limit: %s", value)
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return limit, nil
+}
+
+// allowMethod rejects requests with an unexpected method
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+// statusText converts a boolean outcome to the envelope status
+func statusText(ok bool) string {
+	if ok {
+		return "success"
+	}
+	return "error"
+}
+
+// writeSuccess writes a successful JSON envelope
+func writeSuccess(w http.ResponseWriter, data interface{}) {
+	writeJSON(w, http.StatusOK, response{Status: "success", Data: data})
+}
+
+// writeError writes an error JSON envelope
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, response{Status: "error", Error: message})
+}
+
+// writeJSON encodes v as the response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,198 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/samzong/prom-etl-db/internal/logger"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// clickhouseSchema creates the metrics table. ReplacingMergeTree keeps the most
+// recently collected row of every sample, so rewrites converge like an upsert.
+const clickhouseSchema = `
+	CREATE TABLE IF NOT EXISTS metrics_data (
+		query_id LowCardinality(String),
+		source LowCardinality(String) DEFAULT 'default',
+		metric_name LowCardinality(String),
+		labels Map(String, String),
+		labels_hash UInt64,
+		value Nullable(Float64),
+		string_value Nullable(String),
+		timestamp DateTime64(3),
+		result_type LowCardinality(String),
+		collected_at DateTime64(3)
+	)
+	ENGINE = ReplacingMergeTree(collected_at)
+	PARTITION BY toYYYYMM(timestamp)
+	ORDER BY (query_id, metric_name, timestamp, labels_hash)
+`
+
+// clickhouseInsert is the batch insert statement
+const clickhouseInsert = `
+	INSERT INTO metrics_data
+	(query_id, source, metric_name, labels, labels_hash, value, string_value, timestamp, result_type, collected_at)
+`
+
+// ClickHouse writes metric records to ClickHouse with native batch inserts
+type ClickHouse struct {
+	conn      driver.Conn
+	batchSize int
+	logger    *slog.Logger
+}
+
+// NewClickHouse connects to ClickHouse and creates the schema if needed
+func NewClickHouse(cfg models.ClickHouseConfig, baseLogger *slog.Logger) (*ClickHouse, error) {
+	conn, err := clickhouse.Open(&clickhouse.Options{
+		Addr: cfg.Addr,
+		Auth: clickhouse.Auth{
+			Database: cfg.Database,
+			Username: cfg.Username,
+			Password: cfg.Password,
+		},
+		Compression: &clickhouse.Compression{
+			Method: clickhouse.CompressionLZ4,
+		},
+		DialTimeout: 10 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open clickhouse: %w", err)
+	}
+
+	return newClickHouseWithConn(conn, cfg.BatchSize, baseLogger)
+}
+
+// newClickHouseWithConn creates the sink on an existing connection; tests pass a fake driver.Conn
+func newClickHouseWithConn(conn driver.Conn, batchSize int, baseLogger *slog.Logger) (*ClickHouse, error) {
+	if batchSize <= 0 {
+		batchSize = 100000
+	}
+
+	s := &ClickHouse{
+		conn:      conn,
+		batchSize: batchSize,
+		logger:    logger.WithComponent(baseLogger, "clickhouse-sink"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := conn.Ping(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to ping clickhouse: %w", err)
+	}
+
+	if err := conn.Exec(ctx, clickhouseSchema); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create clickhouse schema: %w", err)
+	}
+
+	return s, nil
+}
+
+// Name returns the sink name
+func (s *ClickHouse) Name() string {
+	return TypeClickHouse
+}
+
+// Write sends the records in native batches of at most batchSize rows
+func (s *ClickHouse) Write(ctx context.Context, execution *models.QueryExecution, records []*models.MetricRecord) error {
+	for start := 0; start < len(records); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		if err := s.sendBatch(ctx, records[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendBatch appends records to a single native insert block
+func (s *ClickHouse) sendBatch(ctx context.Context, records []*models.MetricRecord) error {
+	batch, err := s.conn.PrepareBatch(ctx, clickhouseInsert)
+	if err != nil {
+		return fmt.Errorf("failed to prepare batch: %w", err)
+	}
+
+	for _, record := range records {
+		labels := stringLabels(record.Labels)
+
+		var value *float64
+		if record.StringValue == nil {
+			value = &record.Value
+		}
+
+		err := batch.Append(
+			record.QueryID,
+			record.Source,
+			record.MetricName,
+			labels,
+			labelsHash(labels),
+			value,
+			record.StringValue,
+			record.Timestamp,
+			record.ResultType,
+			record.CollectedAt,
+		)
+		if err != nil {
+			_ = batch.Abort()
+			return fmt.Errorf("failed to append metric record: %w", err)
+		}
+	}
+
+	if err := batch.Send(); err != nil {
+		return fmt.Errorf("failed to send batch: %w", err)
+	}
+
+	s.logger.Debug("Batch sent", "rows", len(records))
+	return nil
+}
+
+// TestConnection pings ClickHouse
+func (s *ClickHouse) TestConnection(ctx context.Context) error {
+	return s.conn.Ping(ctx)
+}
+
+// Close closes the connection
+func (s *ClickHouse) Close() error {
+	return s.conn.Close()
+}
+
+// stringLabels converts record labels to strings
+func stringLabels(labels map[string]interface{}) map[string]string {
+	result := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if str, ok := v.(string); ok {
+			result[k] = str
+		} else {
+			result[k] = fmt.Sprint(v)
+		}
+	}
+	return result
+}
+
+// labelsHash returns a stable FNV-1a hash of the sorted label pairs
+func labelsHash(labels map[string]string) uint64 {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0xff})
+		h.Write([]byte(labels[name]))
+		h.Write([]byte{0xff})
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,173 @@
+package sink
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// fakeClickHouseConn records the statements and batches sent to ClickHouse.
+// Methods the sink does not use panic through the embedded nil interface.
+type fakeClickHouseConn struct {
+	driver.Conn
+	execs   []string
+	batches []*fakeBatch
+	closed  bool
+}
+
+func (c *fakeClickHouseConn) Ping(context.Context) error { return nil }
+
+func (c *fakeClickHouseConn) Exec(_ context.Context, query string, _ ...any) error {
+	c.execs = append(c.execs, query)
+	return nil
+}
+
+func (c *fakeClickHouseConn) PrepareBatch(_ context.Context, query string, _ ...driver.PrepareBatchOption) (driver.Batch, error) {
+	batch := &fakeBatch{query: query}
+	c.batches = append(c.batches, batch)
+	return batch, nil
+}
+
+func (c *fakeClickHouseConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+// fakeBatch records the appended rows and how often it was sent
+type fakeBatch struct {
+	driver.Batch
+	query   string
+	rows    [][]any
+	sends   int
+	aborted bool
+}
+
+func (b *fakeBatch) Append(v ...any) error {
+	b.rows = append(b.rows, v)
+	return nil
+}
+
+func (b *fakeBatch) Send() error {
+	b.sends++
+	return nil
+}
+
+func (b *fakeBatch) Abort() error {
+	b.aborted = true
+	return nil
+}
+
+func newTestClickHouse(t *testing.T, batchSize int) (*ClickHouse, *fakeClickHouseConn) {
+	t.Helper()
+	conn := &fakeClickHouseConn{}
+	s, err := newClickHouseWithConn(conn, batchSize, slog.Default())
+	if err != nil {
+		t.Fatalf("newClickHouseWithConn: %v", err)
+	}
+	return s, conn
+}
+
+func TestClickHouseCreatesSchema(t *testing.T) {
+	_, conn := newTestClickHouse(t, 10)
+
+	if len(conn.execs) != 1 {
+		t.Fatalf("got %d schema statements, want 1", len(conn.execs))
+	}
+	ddl := strings.Join(strings.Fields(conn.execs[0]), " ")
+	for _, want := range []string{
+		"CREATE TABLE IF NOT EXISTS metrics_data",
+		"source LowCardinality(String)",
+		"labels Map(String, String)",
+		"ENGINE = ReplacingMergeTree(collected_at)",
+		"ORDER BY (query_id, metric_name, timestamp, labels_hash)",
+	} {
+		if !strings.Contains(ddl, want) {
+			t.Errorf("schema lacks %q:\n%s", want, ddl)
+		}
+	}
+}
+
+func TestClickHouseWriteSendsBatches(t *testing.T) {
+	s, conn := newTestClickHouse(t, 2)
+
+	ts := time.UnixMilli(1700000000123)
+	str := "v1.2.3"
+	records := []*models.MetricRecord{
+		{QueryID: "q", Source: "default", MetricName: "up", Labels: map[string]interface{}{"job": "a"}, Value: 1, Timestamp: ts, ResultType: "instant"},
+		{QueryID: "q", Source: "default", MetricName: "up", Labels: map[string]interface{}{"job": "b"}, Value: 0, Timestamp: ts, ResultType: "instant"},
+		{QueryID: "q", Source: "default", MetricName: "up", Labels: map[string]interface{}{"job": "c"}, Value: 1, Timestamp: ts, ResultType: "instant"},
+		{QueryID: "q", Source: "default", MetricName: "q", Labels: map[string]interface{}{}, StringValue: &str, Timestamp: ts, ResultType: "string"},
+		{QueryID: "q", Source: "default", MetricName: "up", Labels: map[string]interface{}{"code": 200}, Value: 1, Timestamp: ts, ResultType: "instant"},
+	}
+
+	if err := s.Write(context.Background(), &models.QueryExecution{}, records); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if len(conn.batches) != 3 {
+		t.Fatalf("got %d batches, want 3", len(conn.batches))
+	}
+	for i, want := range []int{2, 2, 1} {
+		batch := conn.batches[i]
+		if batch.sends != 1 {
+			t.Errorf("batch %d sent %d times, want once", i, batch.sends)
+		}
+		if len(batch.rows) != want {
+			t.Errorf("batch %d has %d rows, want %d", i, len(batch.rows), want)
+		}
+		if batch.aborted {
+			t.Errorf("batch %d was aborted", i)
+		}
+		if batch.query != clickhouseInsert {
+			t.Errorf("batch %d prepared with %q", i, batch.query)
+		}
+	}
+
+	// Columns follow clickhouseInsert
+	row := conn.batches[0].rows[0]
+	labels := row[3].(map[string]string)
+	if labels["job"] != "a" || row[4].(uint64) != labelsHash(labels) {
+		t.Errorf("unexpected labels %v and hash %v", row[3], row[4])
+	}
+	if value := row[5].(*float64); value == nil || *value != 1 {
+		t.Errorf("value = %v, want 1", row[5])
+	}
+	if row[6].(*string) != nil {
+		t.Errorf("string_value = %v, want nil", row[6])
+	}
+
+	stringRow := conn.batches[1].rows[1]
+	if stringRow[5].(*float64) != nil || *stringRow[6].(*string) != str {
+		t.Errorf("string result stored as value %v / string_value %v", stringRow[5], stringRow[6])
+	}
+	if labels := conn.batches[2].rows[0][3].(map[string]string); labels["code"] != "200" {
+		t.Errorf("non-string label = %q, want \"200\"", labels["code"])
+	}
+}
+
+func TestClickHouseWriteWithoutRecords(t *testing.T) {
+	s, conn := newTestClickHouse(t, 2)
+	if err := s.Write(context.Background(), &models.QueryExecution{}, nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if len(conn.batches) != 0 {
+		t.Errorf("prepared %d batches for no records", len(conn.batches))
+	}
+}
+
+func TestLabelsHashIgnoresOrder(t *testing.T) {
+	a := labelsHash(map[string]string{"job": "node", "instance": "a:9100"})
+	b := labelsHash(map[string]string{"instance": "a:9100", "job": "node"})
+	if a != b {
+		t.Errorf("hash depends on map order")
+	}
+	// Separators keep name/value boundaries apart
+	if labelsHash(map[string]string{"ab": "c"}) == labelsHash(map[string]string{"a": "bc"}) {
+		t.Errorf("hash collides across name/value boundaries")
+	}
+}
@@ -0,0 +1,241 @@
+package sink
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// File formats of exported records
+const (
+	FormatParquet = "parquet"
+	FormatCSV     = "csv"
+)
+
+// Label layouts of exported records
+const (
+	// LabelsMap keeps labels in one column: a Parquet map or a JSON object in CSV
+	LabelsMap = "map"
+	// LabelsFlatten writes one label_<name> column per label name
+	LabelsFlatten = "flatten"
+)
+
+// labelColumnPrefix prefixes flattened label columns so they cannot clash with fixed columns
+const labelColumnPrefix = "label_"
+
+// Extension returns the file extension of format
+func Extension(format string) string {
+	if format == FormatCSV {
+		return ".csv"
+	}
+	return ".parquet"
+}
+
+// EncodeRecords writes records to w in the given format and label layout
+func EncodeRecords(w io.Writer, format, labelLayout string, records []*models.MetricRecord) error {
+	switch format {
+	case FormatParquet:
+		return encodeParquet(w, labelLayout, records)
+	case FormatCSV:
+		return encodeCSV(w, labelLayout, records)
+	default:
+		return fmt.Errorf("unsupported file format: %s", format)
+	}
+}
+
+// labelNames returns the sorted union of label names across records
+func labelNames(records []*models.MetricRecord) []string {
+	seen := make(map[string]bool)
+	for _, record := range records {
+		for name := range record.Labels {
+			seen[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parquetRecord is the Parquet row of the map label layout
+type parquetRecord struct {
+	QueryID     string            `parquet:"query_id"`
+	Source      string            `parquet:"source"`
+	MetricName  string            `parquet:"metric_name"`
+	Labels      map[string]string `parquet:"labels"`
+	Value       *float64          `parquet:"value,optional"`
+	StringValue *string           `parquet:"string_value,optional"`
+	Timestamp   time.Time         `parquet:"timestamp,timestamp(millisecond)"`
+	ResultType  string            `parquet:"result_type"`
+	CollectedAt time.Time         `parquet:"collected_at,timestamp(millisecond)"`
+}
+
+// encodeParquet writes a Snappy compressed Parquet file
+func encodeParquet(w io.Writer, labelLayout string, records []*models.MetricRecord) error {
+	if labelLayout == LabelsFlatten {
+		return encodeParquetFlat(w, records)
+	}
+
+	writer := parquet.NewGenericWriter[parquetRecord](w, parquet.Compression(&parquet.Snappy))
+
+	rows := make([]parquetRecord, 0, len(records))
+	for _, record := range records {
+		row := parquetRecord{
+			QueryID:     record.QueryID,
+			Source:      record.Source,
+			MetricName:  record.MetricName,
+			Labels:      stringLabels(record.Labels),
+			StringValue: record.StringValue,
+			Timestamp:   record.Timestamp,
+			ResultType:  record.ResultType,
+			CollectedAt: record.CollectedAt,
+		}
+		if record.StringValue == nil {
+			value := record.Value
+			row.Value = &value
+		}
+		rows = append(rows, row)
+	}
+
+	if _, err := writer.Write(rows); err != nil {
+		return fmt.Errorf("failed to write parquet rows: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to close parquet writer: %w", err)
+	}
+
+	return nil
+}
+
+// encodeParquetFlat writes a Parquet file with one optional column per label name;
+// the schema is built from the label names present in records
+func encodeParquetFlat(w io.Writer, records []*models.MetricRecord) error {
+	group := parquet.Group{
+		"query_id":     parquet.String(),
+		"source":       parquet.String(),
+		"metric_name":  parquet.String(),
+		"value":        parquet.Optional(parquet.Leaf(parquet.DoubleType)),
+		"string_value": parquet.Optional(parquet.String()),
+		"timestamp":    parquet.Timestamp(parquet.Millisecond),
+		"result_type":  parquet.String(),
+		"collected_at": parquet.Timestamp(parquet.Millisecond),
+	}
+
+	names := labelNames(records)
+	for _, name := range names {
+		group[labelColumnPrefix+name] = parquet.Optional(parquet.String())
+	}
+
+	schema := parquet.NewSchema("metrics_data", group)
+	writer := parquet.NewGenericWriter[map[string]interface{}](w, schema, parquet.Compression(&parquet.Snappy))
+
+	rows := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		row := map[string]interface{}{
+			"query_id":     record.QueryID,
+			"source":       record.Source,
+			"metric_name":  record.MetricName,
+			"value":        nil,
+			"string_value": nil,
+			"timestamp":    record.Timestamp.UnixMilli(),
+			"result_type":  record.ResultType,
+			"collected_at": record.CollectedAt.UnixMilli(),
+		}
+		if record.StringValue != nil {
+			row["string_value"] = *record.StringValue
+		} else {
+			row["value"] = record.Value
+		}
+
+		labels := stringLabels(record.Labels)
+		for _, name := range names {
+			if value, ok := labels[name]; ok {
+				row[labelColumnPrefix+name] = value
+			} else {
+				row[labelColumnPrefix+name] = nil
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	if _, err := writer.Write(rows); err != nil {
+		return fmt.Errorf("failed to write parquet rows: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to close parquet writer: %w", err)
+	}
+
+	return nil
+}
+
+// encodeCSV writes a CSV file with a header row
+func encodeCSV(w io.Writer, labelLayout string, records []*models.MetricRecord) error {
+	names := labelNames(records)
+
+	header := []string{"query_id", "source", "metric_name", "value", "string_value", "timestamp", "result_type", "collected_at"}
+	if labelLayout == LabelsFlatten {
+		for _, name := range names {
+			header = append(header, labelColumnPrefix+name)
+		}
+	} else {
+		header = append(header, "labels")
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return fmt.Errorf("failed to write csv header: %w", err)
+	}
+
+	for _, record := range records {
+		value, stringValue := strconv.FormatFloat(record.Value, 'g', -1, 64), ""
+		if record.StringValue != nil {
+			value, stringValue = "", *record.StringValue
+		}
+
+		row := []string{
+			record.QueryID,
+			record.Source,
+			record.MetricName,
+			value,
+			stringValue,
+			record.Timestamp.UTC().Format(time.RFC3339Nano),
+			record.ResultType,
+			record.CollectedAt.UTC().Format(time.RFC3339Nano),
+		}
+
+		labels := stringLabels(record.Labels)
+		if labelLayout == LabelsFlatten {
+			for _, name := range names {
+				row = append(row, labels[name])
+			}
+		} else {
+			labelsJSON, err := json.Marshal(labels)
+			if err != nil {
+				return fmt.Errorf("failed to marshal labels: %w", err)
+			}
+			row = append(row, string(labelsJSON))
+		}
+
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("failed to write csv row: %w", err)
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to flush csv: %w", err)
+	}
+
+	return nil
+}
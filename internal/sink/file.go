@@ -0,0 +1,152 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/logger"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// partitionDateLayout formats the date partition of exported files
+const partitionDateLayout = "2006-01-02"
+
+// runNameLayout formats the evaluation time that names the files of an execution
+const runNameLayout = "20060102T150405.000Z"
+
+// File writes the records of every execution as a Parquet or CSV file below a local directory
+type File struct {
+	dir         string
+	format      string
+	labelLayout string
+	logger      *slog.Logger
+}
+
+// NewFile creates a file sink, creating the export directory if needed
+func NewFile(cfg models.FileSinkConfig, baseLogger *slog.Logger) (*File, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	return &File{
+		dir:         cfg.Dir,
+		format:      cfg.Format,
+		labelLayout: cfg.Labels,
+		logger:      logger.WithComponent(baseLogger, "file-sink"),
+	}, nil
+}
+
+// Name returns the sink name
+func (s *File) Name() string {
+	return TypeFile
+}
+
+// Write encodes the records into the part file of the execution's evaluation time.
+// A retry or rerun of the same evaluation time replaces that file, so the samples are
+// never exported twice. Executions without records produce no file.
+func (s *File) Write(ctx context.Context, execution *models.QueryExecution, records []*models.MetricRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeRecords(&buf, s.format, s.labelLayout, records); err != nil {
+		return fmt.Errorf("failed to encode records: %w", err)
+	}
+
+	dir := filepath.Join(s.dir, filepath.FromSlash(partitionPath(execution)))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create partition directory: %w", err)
+	}
+
+	name := filepath.Join(dir, "part-"+runName(execution)+Extension(s.format))
+	if err := writeFileAtomic(name, buf.Bytes()); err != nil {
+		return err
+	}
+
+	s.logger.Debug("Export file written",
+		"query_id", execution.QueryID,
+		"file", name,
+		"records", len(records),
+		"bytes", buf.Len())
+	return nil
+}
+
+// writeFileAtomic writes data to a hidden temporary file in the same directory and
+// renames it over name, so readers see either the previous or the new file
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".part-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to set export file mode: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to close export file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to rename export file: %w", err)
+	}
+	return nil
+}
+
+// TestConnection checks that the export directory is writable
+func (s *File) TestConnection(ctx context.Context) error {
+	tmp, err := os.CreateTemp(s.dir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("export directory is not writable: %w", err)
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+// Close is a no-op; every file is closed after it is written
+func (s *File) Close() error {
+	return nil
+}
+
+// partitionPath returns the slash separated partition of an execution,
+// query_id=<id>/date=<YYYY-MM-DD>, dated by the evaluation time
+func partitionPath(execution *models.QueryExecution) string {
+	return path.Join(
+		"query_id="+url.PathEscape(execution.QueryID),
+		"date="+evaluationTime(execution).Format(partitionDateLayout),
+	)
+}
+
+// runName names the files of an execution after its evaluation time in UTC,
+// e.g. 20240301T120000.000Z; reruns of the same evaluation time share the name
+func runName(execution *models.QueryExecution) string {
+	return evaluationTime(execution).UTC().Format(runNameLayout)
+}
+
+// evaluationTime returns the evaluation time of an execution, falling back to its start time
+func evaluationTime(execution *models.QueryExecution) time.Time {
+	date := execution.StartTime
+	if execution.EvaluationTime != nil {
+		date = *execution.EvaluationTime
+	}
+	if date.IsZero() {
+		date = time.Now()
+	}
+	return date
+}
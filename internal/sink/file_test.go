@@ -0,0 +1,130 @@
+package sink
+
+import (
+	"context"
+	"encoding/csv"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// testRecords returns n instant records of query q evaluated at ts
+func testRecords(n int, ts time.Time, value float64) []*models.MetricRecord {
+	records := make([]*models.MetricRecord, n)
+	for i := range records {
+		records[i] = &models.MetricRecord{
+			QueryID:     "q",
+			Source:      "default",
+			MetricName:  "up",
+			Labels:      map[string]interface{}{"instance": string(rune('a' + i))},
+			Value:       value,
+			Timestamp:   ts,
+			ResultType:  "instant",
+			CollectedAt: ts,
+		}
+	}
+	return records
+}
+
+// exportedFiles lists the files below dir, relative to it
+func exportedFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestFileWrite(t *testing.T) {
+	evaluation := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	next := evaluation.Add(time.Minute)
+
+	type write struct {
+		executionID    int64
+		evaluationTime *time.Time
+		records        int
+	}
+	tests := []struct {
+		name   string
+		writes []write
+		// rows maps the expected parts to their rows, including the header
+		rows map[string]int
+	}{
+		{
+			name:   "part is named after the evaluation time",
+			writes: []write{{7, &evaluation, 2}},
+			rows:   map[string]int{"query_id=q/date=2024-03-01/part-20240301T120000.000Z.csv": 3},
+		},
+		{
+			// A retry gets a new execution ID but keeps the evaluation time
+			name:   "rerun replaces the part",
+			writes: []write{{1, &evaluation, 3}, {2, &evaluation, 2}},
+			rows:   map[string]int{"query_id=q/date=2024-03-01/part-20240301T120000.000Z.csv": 3},
+		},
+		{
+			name:   "next evaluation time gets its own part",
+			writes: []write{{1, &evaluation, 3}, {2, &next, 1}},
+			rows: map[string]int{
+				"query_id=q/date=2024-03-01/part-20240301T120000.000Z.csv": 4,
+				"query_id=q/date=2024-03-01/part-20240301T120100.000Z.csv": 2,
+			},
+		},
+		{
+			name:   "no records",
+			writes: []write{{1, nil, 0}},
+			rows:   map[string]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := NewFile(models.FileSinkConfig{Dir: dir, Format: FormatCSV, Labels: LabelsMap}, slog.Default())
+			if err != nil {
+				t.Fatalf("NewFile: %v", err)
+			}
+
+			for _, w := range tt.writes {
+				execution := &models.QueryExecution{ID: w.executionID, QueryID: "q", EvaluationTime: w.evaluationTime}
+				var records []*models.MetricRecord
+				if w.records > 0 {
+					records = testRecords(w.records, *w.evaluationTime, float64(w.executionID))
+				}
+				if err := s.Write(context.Background(), execution, records); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+
+			rows := make(map[string]int)
+			for _, file := range exportedFiles(t, dir) {
+				f, err := os.Open(filepath.Join(dir, filepath.FromSlash(file)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, err := csv.NewReader(f).ReadAll()
+				f.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows[file] = len(content)
+			}
+			if !reflect.DeepEqual(rows, tt.rows) {
+				t.Errorf("parts = %v, want %v", rows, tt.rows)
+			}
+		})
+	}
+}
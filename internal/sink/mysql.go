@@ -0,0 +1,38 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/samzong/prom-etl-db/internal/database"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// MySQL writes metric records to the metrics_data table of the application database
+type MySQL struct {
+	db *database.DB
+}
+
+// NewMySQL creates a MySQL sink; the connection is owned by the caller
+func NewMySQL(db *database.DB) *MySQL {
+	return &MySQL{db: db}
+}
+
+// Name returns the sink name
+func (s *MySQL) Name() string {
+	return TypeMySQL
+}
+
+// Write upserts the records into metrics_data
+func (s *MySQL) Write(ctx context.Context, execution *models.QueryExecution, records []*models.MetricRecord) error {
+	return s.db.InsertMetricRecords(records)
+}
+
+// TestConnection pings MySQL
+func (s *MySQL) TestConnection(ctx context.Context) error {
+	return s.db.TestConnection()
+}
+
+// Close is a no-op; the shared connection is closed by its owner
+func (s *MySQL) Close() error {
+	return nil
+}
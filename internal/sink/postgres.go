@@ -0,0 +1,207 @@
+package sink
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/samzong/prom-etl-db/internal/logger"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// postgresBatchSize bounds the rows per INSERT statement (9 parameters per row)
+const postgresBatchSize = 1000
+
+//go:embed postgres_schema.sql
+var postgresSchema string
+
+// Postgres writes metric records to PostgreSQL with JSONB labels,
+// optionally as a TimescaleDB hypertable
+type Postgres struct {
+	conn   *sql.DB
+	logger *slog.Logger
+}
+
+// NewPostgres connects to PostgreSQL and creates the schema if needed
+func NewPostgres(cfg models.PostgresConfig, baseLogger *slog.Logger) (*Postgres, error) {
+	conn, err := sql.Open("postgres", cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres: %w", err)
+	}
+
+	// Configure connection pool
+	conn.SetMaxOpenConns(20)
+	conn.SetMaxIdleConns(5)
+	conn.SetConnMaxLifetime(time.Hour)
+
+	s := &Postgres{
+		conn:   conn,
+		logger: logger.WithComponent(baseLogger, "postgres-sink"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to ping postgres: %w", err)
+	}
+
+	if err := s.ensureSchema(ctx, cfg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// ensureSchema creates metrics_data and, for TimescaleDB, converts it to a hypertable
+func (s *Postgres) ensureSchema(ctx context.Context, cfg models.PostgresConfig) error {
+	if _, err := s.conn.ExecContext(ctx, postgresSchema); err != nil {
+		return fmt.Errorf("failed to create postgres schema: %w", err)
+	}
+
+	if cfg.Timescale {
+		_, err := s.conn.ExecContext(ctx,
+			`SELECT create_hypertable('metrics_data', 'timestamp', chunk_time_interval => $1::interval, if_not_exists => TRUE, migrate_data => TRUE)`,
+			cfg.ChunkInterval)
+		if err != nil {
+			return fmt.Errorf("failed to create timescale hypertable: %w", err)
+		}
+		s.logger.Info("TimescaleDB hypertable ready", "chunk_interval", cfg.ChunkInterval)
+	}
+
+	return nil
+}
+
+// Name returns the sink name
+func (s *Postgres) Name() string {
+	return TypePostgres
+}
+
+// Write upserts the records in multi-row INSERT statements within one transaction
+func (s *Postgres) Write(ctx context.Context, execution *models.QueryExecution, records []*models.MetricRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	rows, err := uniqueRows(records)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(rows); start += postgresBatchSize {
+		end := start + postgresBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		if err := insertPostgresBatch(ctx, tx, rows[start:end]); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// TestConnection pings PostgreSQL
+func (s *Postgres) TestConnection(ctx context.Context) error {
+	return s.conn.PingContext(ctx)
+}
+
+// Close closes the connection pool
+func (s *Postgres) Close() error {
+	return s.conn.Close()
+}
+
+// postgresRow is a record with its labels encoded as JSON
+type postgresRow struct {
+	record *models.MetricRecord
+	labels string
+}
+
+// uniqueRows encodes labels and keeps the last record of every sample;
+// PostgreSQL rejects an upsert touching the same row twice in one statement
+func uniqueRows(records []*models.MetricRecord) ([]postgresRow, error) {
+	rows := make([]postgresRow, 0, len(records))
+	index := make(map[string]int, len(records))
+
+	for _, record := range records {
+		labelsJSON, err := json.Marshal(record.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal labels: %w", err)
+		}
+
+		row := postgresRow{record: record, labels: string(labelsJSON)}
+		key := fmt.Sprintf("%s\x00%s\x00%s\x00%d", record.QueryID, record.MetricName, row.labels, record.Timestamp.UnixMilli())
+		if i, ok := index[key]; ok {
+			rows[i] = row
+			continue
+		}
+		index[key] = len(rows)
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// insertPostgresBatch upserts rows with a single statement
+func insertPostgresBatch(ctx context.Context, tx *sql.Tx, rows []postgresRow) error {
+	var query strings.Builder
+	query.WriteString(`INSERT INTO metrics_data
+		(query_id, source, metric_name, labels, value, string_value, "timestamp", result_type, collected_at) VALUES `)
+
+	args := make([]interface{}, 0, len(rows)*9)
+	for i, row := range rows {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := len(args)
+		fmt.Fprintf(&query, "($%d, $%d, $%d, $%d::jsonb, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9)
+
+		record := row.record
+		var value interface{} = record.Value
+		if record.StringValue != nil {
+			value = nil
+		}
+		args = append(args,
+			record.QueryID,
+			record.Source,
+			record.MetricName,
+			row.labels,
+			value,
+			record.StringValue,
+			record.Timestamp,
+			record.ResultType,
+			record.CollectedAt,
+		)
+	}
+
+	query.WriteString(` ON CONFLICT (query_id, metric_name, labels_hash, "timestamp") DO UPDATE SET
+		source = EXCLUDED.source,
+		value = EXCLUDED.value,
+		string_value = EXCLUDED.string_value,
+		result_type = EXCLUDED.result_type,
+		collected_at = EXCLUDED.collected_at`)
+
+	if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+		return fmt.Errorf("failed to insert metric records: %w", err)
+	}
+
+	return nil
+}
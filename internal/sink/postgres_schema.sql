@@ -0,0 +1,19 @@
+-- PostgreSQL / TimescaleDB schema for the postgres sink
+-- Applied on startup; every statement is idempotent
+CREATE TABLE IF NOT EXISTS metrics_data (
+    query_id varchar(100) NOT NULL,
+    source varchar(100) NOT NULL DEFAULT 'default',
+    metric_name varchar(255) NOT NULL,
+    labels jsonb NOT NULL,
+    labels_hash bytea GENERATED ALWAYS AS (decode(md5(labels::text), 'hex')) STORED,
+    value double precision NULL,
+    string_value text NULL,
+    "timestamp" timestamptz(3) NOT NULL,
+    result_type varchar(16) NOT NULL,
+    collected_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (query_id, metric_name, labels_hash, "timestamp")
+);
+
+CREATE INDEX IF NOT EXISTS idx_metrics_data_query_id_timestamp ON metrics_data (query_id, "timestamp");
+
+CREATE INDEX IF NOT EXISTS idx_metrics_data_labels ON metrics_data USING gin (labels);
@@ -0,0 +1,268 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/samzong/prom-etl-db/internal/logger"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// manifestPrefix holds the manifests below the object prefix; the leading
+// underscore makes Hive-style readers skip it when scanning data files
+const manifestPrefix = "_manifests"
+
+// Manifest describes the files uploaded for one execution.
+// It is uploaded after all of them, so its presence marks the execution complete.
+type Manifest struct {
+	ExecutionID    int64          `json:"execution_id"`
+	QueryID        string         `json:"query_id"`
+	EvaluationTime *time.Time     `json:"evaluation_time,omitempty"`
+	Format         string         `json:"format"`
+	LabelLayout    string         `json:"label_layout"`
+	RowCount       int            `json:"row_count"`
+	Files          []ManifestFile `json:"files"`
+	CreatedAt      time.Time      `json:"created_at"`
+}
+
+// ManifestFile describes one uploaded data file
+type ManifestFile struct {
+	Key      string `json:"key"`
+	RowCount int    `json:"row_count"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// S3 uploads the records of every execution as Parquet or CSV files to S3-compatible object storage
+type S3 struct {
+	client *minio.Client
+	cfg    models.S3Config
+	logger *slog.Logger
+	// retryDelay is the wait before the first retry of a failed upload; it doubles with every retry
+	retryDelay time.Duration
+}
+
+// NewS3 creates an object storage sink and checks that the bucket exists
+func NewS3(cfg models.S3Config, baseLogger *slog.Logger) (*S3, error) {
+	lookup := minio.BucketLookupAuto
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure:       cfg.UseSSL,
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+
+	s := &S3{
+		client:     client,
+		cfg:        cfg,
+		logger:     logger.WithComponent(baseLogger, "s3-sink"),
+		retryDelay: time.Second,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := s.TestConnection(ctx); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Name returns the sink name
+func (s *S3) Name() string {
+	return TypeS3
+}
+
+// Write uploads the records as one or more data files followed by the manifest of the
+// execution's evaluation time. Objects are named after the query and evaluation time, so a
+// retry or rerun overwrites the files and manifest of that time and removes the files the
+// previous manifest listed that the rerun no longer writes.
+func (s *S3) Write(ctx context.Context, execution *models.QueryExecution, records []*models.MetricRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	partition := path.Join(s.cfg.Prefix, partitionPath(execution))
+	name := runName(execution)
+	manifestKey := path.Join(s.cfg.Prefix, manifestPrefix, partitionPath(execution), name+".json")
+
+	previous, err := s.readManifest(ctx, manifestKey)
+	if err != nil {
+		return err
+	}
+
+	manifest := &Manifest{
+		ExecutionID:    execution.ID,
+		QueryID:        execution.QueryID,
+		EvaluationTime: execution.EvaluationTime,
+		Format:         s.cfg.Format,
+		LabelLayout:    s.cfg.Labels,
+		RowCount:       len(records),
+	}
+
+	rowsPerFile := s.cfg.MaxRowsPerFile
+	if rowsPerFile <= 0 {
+		rowsPerFile = len(records)
+	}
+
+	for part, start := 0, 0; start < len(records); part, start = part+1, start+rowsPerFile {
+		end := start + rowsPerFile
+		if end > len(records) {
+			end = len(records)
+		}
+
+		var buf bytes.Buffer
+		if err := EncodeRecords(&buf, s.cfg.Format, s.cfg.Labels, records[start:end]); err != nil {
+			return fmt.Errorf("failed to encode records: %w", err)
+		}
+
+		sum := sha256.Sum256(buf.Bytes())
+		file := ManifestFile{
+			Key:      path.Join(partition, fmt.Sprintf("part-%s-%d%s", name, part, Extension(s.cfg.Format))),
+			RowCount: end - start,
+			Size:     int64(buf.Len()),
+			SHA256:   hex.EncodeToString(sum[:]),
+		}
+
+		if err := s.upload(ctx, file.Key, buf.Bytes(), contentType(s.cfg.Format), file.SHA256); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	manifest.CreatedAt = time.Now()
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	manifestSum := sha256.Sum256(manifestJSON)
+	if err := s.upload(ctx, manifestKey, manifestJSON, "application/json", hex.EncodeToString(manifestSum[:])); err != nil {
+		return err
+	}
+
+	if previous != nil {
+		s.removeStaleFiles(ctx, previous, manifest)
+	}
+
+	s.logger.Debug("Execution uploaded",
+		"query_id", execution.QueryID,
+		"execution_id", execution.ID,
+		"files", len(manifest.Files),
+		"records", len(records),
+		"manifest", manifestKey)
+	return nil
+}
+
+// readManifest returns the manifest stored at key, or nil when there is none
+func (s *S3) readManifest(ctx context.Context, key string) (*Manifest, error) {
+	object, err := s.client.GetObject(ctx, s.cfg.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", key, err)
+	}
+	defer object.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(object).Decode(&manifest); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read manifest %s: %w", key, err)
+	}
+	return &manifest, nil
+}
+
+// removeStaleFiles deletes the files of previous that current no longer lists.
+// Failures are only logged: the new manifest is already in place and lists the right files.
+func (s *S3) removeStaleFiles(ctx context.Context, previous, current *Manifest) {
+	keep := make(map[string]bool, len(current.Files))
+	for _, file := range current.Files {
+		keep[file.Key] = true
+	}
+
+	for _, file := range previous.Files {
+		if keep[file.Key] {
+			continue
+		}
+		if err := s.client.RemoveObject(ctx, s.cfg.Bucket, file.Key, minio.RemoveObjectOptions{}); err != nil {
+			s.logger.Warn("Failed to remove replaced file", "key", file.Key, "error", err)
+		}
+	}
+}
+
+// upload puts an object, retrying failed uploads with exponential backoff.
+// Objects larger than the part size are sent as multipart uploads.
+func (s *S3) upload(ctx context.Context, key string, data []byte, contentType, checksum string) error {
+	opts := minio.PutObjectOptions{
+		ContentType:  contentType,
+		PartSize:     uint64(s.cfg.PartSize),
+		UserMetadata: map[string]string{"sha256": checksum},
+	}
+
+	delay := s.retryDelay
+	var lastErr error
+	for attempt := 0; attempt <= s.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			s.logger.Warn("Retrying upload", "key", key, "attempt", attempt, "error", lastErr)
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			delay *= 2
+		}
+
+		_, err := s.client.PutObject(ctx, s.cfg.Bucket, key, bytes.NewReader(data), int64(len(data)), opts)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+	}
+
+	return fmt.Errorf("failed to upload %s after %d attempts: %w", key, s.cfg.MaxRetries+1, lastErr)
+}
+
+// TestConnection checks that the bucket exists and is accessible
+func (s *S3) TestConnection(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.cfg.Bucket)
+	if err != nil {
+		return fmt.Errorf("failed to check bucket: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("bucket %s does not exist", s.cfg.Bucket)
+	}
+	return nil
+}
+
+// Close is a no-op; the client holds no open connections of its own
+func (s *S3) Close() error {
+	return nil
+}
+
+// contentType returns the MIME type of format
+func contentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv"
+	}
+	return "application/vnd.apache.parquet"
+}
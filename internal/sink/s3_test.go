@@ -0,0 +1,381 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+const testBucket = "metrics"
+
+// fakeS3 is an in-memory S3 endpoint implementing the calls made by the sink:
+// bucket checks, single and multipart uploads, downloads and deletes
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// uploads holds the parts of the multipart uploads in progress by upload ID
+	uploads map[string]map[int][]byte
+	// multipart counts the completed multipart uploads by key
+	multipart map[string]int
+	// puts counts the upload attempts by key, failed ones included
+	puts map[string]int
+	// failures is the number of upload attempts to fail with 503 by key
+	failures map[string]int
+	nextID   int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		objects:   make(map[string][]byte),
+		uploads:   make(map[string]map[int][]byte),
+		multipart: make(map[string]int),
+		puts:      make(map[string]int),
+		failures:  make(map[string]int),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != testBucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	query := r.URL.Query()
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.puts[key]++
+		if f.fail(key, w) {
+			return
+		}
+		f.nextID++
+		uploadID := strconv.Itoa(f.nextID)
+		f.uploads[uploadID] = make(map[int][]byte)
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`,
+			bucket, key, uploadID)
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		body := readS3Body(r)
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, number))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		numbers := make([]int, 0, len(parts))
+		for number := range parts {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		var object []byte
+		for _, number := range numbers {
+			object = append(object, parts[number]...)
+		}
+		f.objects[key] = object
+		f.multipart[key] = len(parts)
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>"complete"</ETag></CompleteMultipartUploadResult>`,
+			bucket, key)
+
+	case r.Method == http.MethodPut:
+		f.puts[key]++
+		body := readS3Body(r)
+		if f.fail(key, w) {
+			return
+		}
+		f.objects[key] = body
+		w.Header().Set("ETag", `"object"`)
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		object, ok := f.objects[key]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"object"`)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(object)
+		}
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// fail answers with 503 while key has failures left; callers hold f.mu
+func (f *fakeS3) fail(key string, w http.ResponseWriter) bool {
+	if f.failures[key] == 0 {
+		return false
+	}
+	f.failures[key]--
+	writeS3Error(w, http.StatusServiceUnavailable, "ServiceUnavailable")
+	return true
+}
+
+// keys returns the stored object keys in order
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// object returns a stored object
+func (f *fakeS3) object(key string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[key]
+}
+
+// readS3Body returns the payload of an upload, decoding the aws-chunked
+// encoding the client uses for signed uploads over plain HTTP
+func readS3Body(r *http.Request) []byte {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body, _ := io.ReadAll(r.Body)
+		return body
+	}
+
+	var body []byte
+	reader := bufio.NewReader(r.Body)
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return body
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size == 0 {
+			return body
+		}
+		chunk := make([]byte, size+2) // data and CRLF
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return body
+		}
+		body = append(body, chunk[:size]...)
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: code})
+}
+
+// newTestS3 returns a sink uploading to a fake S3 endpoint. Retries of the
+// client library are disabled, so failed attempts reach the sink's own retries.
+func newTestS3(t *testing.T, cfg models.S3Config) (*S3, *fakeS3) {
+	t.Helper()
+
+	maxRetry := minio.MaxRetry
+	minio.MaxRetry = 1
+	t.Cleanup(func() { minio.MaxRetry = maxRetry })
+
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	cfg.Endpoint = strings.TrimPrefix(server.URL, "http://")
+	cfg.Bucket = testBucket
+	cfg.Region = "us-east-1"
+	cfg.AccessKey = "test"
+	cfg.SecretKey = "test-secret"
+	cfg.PathStyle = true
+	if cfg.Format == "" {
+		cfg.Format = FormatCSV
+	}
+	if cfg.Labels == "" {
+		cfg.Labels = LabelsMap
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = 5 << 20
+	}
+
+	s, err := NewS3(cfg, slog.Default())
+	if err != nil {
+		t.Fatalf("NewS3: %v", err)
+	}
+	s.retryDelay = time.Millisecond
+	return s, fake
+}
+
+// readTestManifest decodes the manifest stored at key
+func readTestManifest(t *testing.T, fake *fakeS3, key string) Manifest {
+	t.Helper()
+	data := fake.object(key)
+	if data == nil {
+		t.Fatalf("manifest %s missing; objects: %v", key, fake.keys())
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	return manifest
+}
+
+func TestS3WriteUploadsFilesAndManifest(t *testing.T) {
+	s, fake := newTestS3(t, models.S3Config{Prefix: "etl", MaxRowsPerFile: 2})
+
+	evaluation := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	execution := &models.QueryExecution{ID: 1234, QueryID: "q", EvaluationTime: &evaluation}
+	if err := s.Write(context.Background(), execution, testRecords(5, evaluation, 1)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	manifest := readTestManifest(t, fake, "etl/_manifests/query_id=q/date=2024-03-01/20240301T120000.000Z.json")
+	if manifest.ExecutionID != 1234 || manifest.QueryID != "q" || manifest.RowCount != 5 {
+		t.Errorf("manifest = %+v", manifest)
+	}
+	if manifest.EvaluationTime == nil || !manifest.EvaluationTime.Equal(evaluation) {
+		t.Errorf("evaluation time = %v, want %v", manifest.EvaluationTime, evaluation)
+	}
+	if manifest.Format != FormatCSV || manifest.LabelLayout != LabelsMap {
+		t.Errorf("format = %s/%s", manifest.Format, manifest.LabelLayout)
+	}
+
+	if len(manifest.Files) != 3 {
+		t.Fatalf("manifest lists %d files, want 3", len(manifest.Files))
+	}
+	for i, want := range []int{2, 2, 1} {
+		file := manifest.Files[i]
+		wantKey := fmt.Sprintf("etl/query_id=q/date=2024-03-01/part-20240301T120000.000Z-%d.csv", i)
+		if file.Key != wantKey {
+			t.Errorf("file %d key = %s, want %s", i, file.Key, wantKey)
+		}
+		if file.RowCount != want {
+			t.Errorf("file %d row count = %d, want %d", i, file.RowCount, want)
+		}
+
+		data := fake.object(file.Key)
+		sum := sha256.Sum256(data)
+		if file.SHA256 != hex.EncodeToString(sum[:]) || file.Size != int64(len(data)) {
+			t.Errorf("file %d checksum or size does not match the uploaded object", i)
+		}
+		// CSV files have a header row
+		if rows := bytes.Count(data, []byte("\n")) - 1; rows != want {
+			t.Errorf("file %d has %d rows, want %d", i, rows, want)
+		}
+	}
+}
+
+func TestS3WriteRerunReplacesManifestAndFiles(t *testing.T) {
+	s, fake := newTestS3(t, models.S3Config{MaxRowsPerFile: 2})
+
+	evaluation := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	first := &models.QueryExecution{ID: 1, QueryID: "q", EvaluationTime: &evaluation}
+	if err := s.Write(context.Background(), first, testRecords(5, evaluation, 1)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// A retry or backfill rerun of the same evaluation time gets a new execution ID
+	rerun := &models.QueryExecution{ID: 2, QueryID: "q", EvaluationTime: &evaluation}
+	if err := s.Write(context.Background(), rerun, testRecords(2, evaluation, 7)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	want := []string{
+		"_manifests/query_id=q/date=2024-03-01/20240301T120000.000Z.json",
+		"query_id=q/date=2024-03-01/part-20240301T120000.000Z-0.csv",
+	}
+	if keys := fake.keys(); strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("objects = %v, want %v", keys, want)
+	}
+
+	manifest := readTestManifest(t, fake, want[0])
+	if manifest.ExecutionID != 2 || manifest.RowCount != 2 || len(manifest.Files) != 1 {
+		t.Errorf("manifest = %+v, want the rerun's", manifest)
+	}
+}
+
+func TestS3UploadRetriesServerErrors(t *testing.T) {
+	s, fake := newTestS3(t, models.S3Config{MaxRetries: 3})
+
+	fake.failures["retry.json"] = 2
+	if err := s.upload(context.Background(), "retry.json", []byte(`{}`), "application/json", ""); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if fake.puts["retry.json"] != 3 {
+		t.Errorf("got %d attempts, want 3", fake.puts["retry.json"])
+	}
+	if string(fake.object("retry.json")) != `{}` {
+		t.Errorf("object not stored after retries")
+	}
+
+	fake.failures["broken.json"] = 10
+	err := s.upload(context.Background(), "broken.json", []byte(`{}`), "application/json", "")
+	if err == nil || !strings.Contains(err.Error(), "after 4 attempts") {
+		t.Errorf("err = %v, want failure after 4 attempts", err)
+	}
+}
+
+func TestS3UploadUsesMultipartAbovePartSize(t *testing.T) {
+	s, fake := newTestS3(t, models.S3Config{PartSize: 5 << 20})
+
+	data := make([]byte, 11<<20)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	if err := s.upload(context.Background(), "big.parquet", data, "application/vnd.apache.parquet", ""); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+
+	if parts := fake.multipart["big.parquet"]; parts != 3 {
+		t.Errorf("uploaded in %d parts, want 3", parts)
+	}
+	if !bytes.Equal(fake.object("big.parquet"), data) {
+		t.Errorf("assembled object differs from the upload")
+	}
+
+	if err := s.upload(context.Background(), "small.json", []byte(`{}`), "application/json", ""); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if _, ok := fake.multipart["small.json"]; ok {
+		t.Errorf("small object uploaded as multipart")
+	}
+}
@@ -0,0 +1,127 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/samzong/prom-etl-db/internal/database"
+	"github.com/samzong/prom-etl-db/internal/models"
+)
+
+// Sink types selectable via SINKS
+const (
+	TypeMySQL      = "mysql"
+	TypePostgres   = "postgres"
+	TypeClickHouse = "clickhouse"
+	TypeFile       = "file"
+	TypeS3         = "s3"
+)
+
+// Sink stores the metric records produced by query executions.
+// Execution history stays in MySQL next to query_configs.
+type Sink interface {
+	// Name identifies the sink in logs and errors
+	Name() string
+	// Write stores the records of one execution; writing the same samples again must not duplicate them
+	Write(ctx context.Context, execution *models.QueryExecution, records []*models.MetricRecord) error
+	// TestConnection checks that the sink is reachable
+	TestConnection(ctx context.Context) error
+	// Close releases the sink's resources
+	Close() error
+}
+
+// New creates the sinks listed in cfg.Types; db backs the mysql sink
+func New(cfg models.SinkConfig, db *database.DB, baseLogger *slog.Logger) (Sink, error) {
+	if len(cfg.Types) == 0 {
+		return nil, fmt.Errorf("no sink configured")
+	}
+
+	sinks := make([]Sink, 0, len(cfg.Types))
+	for _, sinkType := range cfg.Types {
+		s, err := newSink(sinkType, cfg, db, baseLogger)
+		if err != nil {
+			for _, created := range sinks {
+				_ = created.Close()
+			}
+			return nil, fmt.Errorf("failed to create %s sink: %w", sinkType, err)
+		}
+		sinks = append(sinks, s)
+	}
+
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return NewMulti(sinks...), nil
+}
+
+// newSink creates a single sink by type
+func newSink(sinkType string, cfg models.SinkConfig, db *database.DB, baseLogger *slog.Logger) (Sink, error) {
+	switch sinkType {
+	case TypeMySQL:
+		return NewMySQL(db), nil
+	case TypePostgres:
+		return NewPostgres(cfg.Postgres, baseLogger)
+	case TypeClickHouse:
+		return NewClickHouse(cfg.ClickHouse, baseLogger)
+	case TypeFile:
+		return NewFile(cfg.File, baseLogger)
+	case TypeS3:
+		return NewS3(cfg.S3, baseLogger)
+	default:
+		return nil, fmt.Errorf("unknown sink type: %s", sinkType)
+	}
+}
+
+// Multi writes every execution to several sinks
+type Multi struct {
+	sinks []Sink
+}
+
+// NewMulti creates a sink writing to all of sinks in order
+func NewMulti(sinks ...Sink) *Multi {
+	return &Multi{sinks: sinks}
+}
+
+// Name returns the names of the underlying sinks
+func (m *Multi) Name() string {
+	names := make([]string, len(m.sinks))
+	for i, s := range m.sinks {
+		names[i] = s.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// Write writes to every sink, even when an earlier one fails
+func (m *Multi) Write(ctx context.Context, execution *models.QueryExecution, records []*models.MetricRecord) error {
+	var errs []error
+	for _, s := range m.sinks {
+		if err := s.Write(ctx, execution, records); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// TestConnection tests every sink
+func (m *Multi) TestConnection(ctx context.Context) error {
+	for _, s := range m.sinks {
+		if err := s.TestConnection(ctx); err != nil {
+			return fmt.Errorf("%s: %w", s.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Close closes every sink
+func (m *Multi) Close() error {
+	var errs []error
+	for _, s := range m.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -1,46 +1,52 @@
 -- Prometheus to MySQL ETL Database Schema
--- Clean database schema without sample data
--- Create database with utf8mb4 charset
-CREATE DATABASE IF NOT EXISTS `prometheus_data` DEFAULT CHARACTER
-SET
-  utf8mb4 DEFAULT COLLATE utf8mb4_unicode_ci;
-
-USE `prometheus_data`;
-
-SET
-  NAMES utf8mb4;
-
-SET
-  FOREIGN_KEY_CHECKS = 0;
+-- Reference of the schema after the latest migration in internal/database/migrations.
+-- Do not apply it: create and upgrade databases with `prom-etl-db migrate up`,
+-- which also records the applied migrations in schema_migrations.
 
 -- Metrics data table
 -- Stores all Prometheus query results
+-- A sample is identified by query_id, metric_name, labels_hash and timestamp;
+-- labels_hash is derived from the normalized labels JSON
+-- String results are stored in string_value with a NULL value
+-- source is the datasource the sample was queried from
+-- Indexed label_<name> columns are added by the service for promoted labels
+-- The table is partitioned by timestamp; with PARTITION_INTERVAL set the service
+-- splits pmax into daily or monthly partitions and drops expired ones
 CREATE TABLE
   `metrics_data` (
     `id` bigint NOT NULL AUTO_INCREMENT,
     `query_id` varchar(100) NOT NULL,
+    `source` varchar(100) NOT NULL DEFAULT 'default',
     `metric_name` varchar(255) NOT NULL,
     `labels` json NOT NULL,
-    `value` double NOT NULL,
+    `labels_hash` binary(16) GENERATED ALWAYS AS (unhex(md5(cast(`labels` as char)))) STORED NOT NULL,
+    `value` double NULL,
+    `string_value` text NULL,
     `timestamp` timestamp(3) NOT NULL,
-    `result_type` enum ('instant', 'range', 'scalar') NOT NULL,
+    `result_type` enum ('instant', 'range', 'scalar', 'string') NOT NULL,
     `collected_at` timestamp DEFAULT CURRENT_TIMESTAMP,
-    PRIMARY KEY (`id`),
+    PRIMARY KEY (`id`, `timestamp`),
+    UNIQUE KEY `uk_series_timestamp` (`query_id`, `metric_name`, `labels_hash`, `timestamp`),
     KEY `idx_query_id_timestamp` (`query_id`, `timestamp`),
+    KEY `idx_source` (`source`),
     KEY `idx_metric_name` (`metric_name`),
     KEY `idx_timestamp` (`timestamp`),
     KEY `idx_result_type` (`result_type`),
     KEY `idx_collected_at` (`collected_at`)
-  ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
+  ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci
+PARTITION BY
+  RANGE (FLOOR(UNIX_TIMESTAMP(`timestamp`))) (PARTITION `pmax` VALUES LESS THAN MAXVALUE);
 
 -- Query execution records
 -- Tracks execution history and performance
+-- partial: some datasources of a fanned out query failed; the others were stored
 CREATE TABLE
   `query_executions` (
     `id` bigint NOT NULL AUTO_INCREMENT,
     `query_id` varchar(100) NOT NULL,
     `query_name` varchar(255) NOT NULL,
-    `status` enum ('running', 'success', 'failed', 'timeout') NOT NULL,
+    `status` enum ('running', 'success', 'partial', 'failed', 'timeout') NOT NULL,
+    `evaluation_time` timestamp(3) NULL,
     `start_time` timestamp(3) NOT NULL,
     `end_time` timestamp(3) NULL,
     `duration_ms` int NULL,
@@ -50,12 +56,70 @@ CREATE TABLE
     PRIMARY KEY (`id`),
     KEY `idx_query_id` (`query_id`),
     KEY `idx_status` (`status`),
+    KEY `idx_query_id_evaluation_time` (`query_id`, `evaluation_time`),
     KEY `idx_start_time` (`start_time`),
     KEY `idx_created_at` (`created_at`)
   ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
 
+-- Downsampled metrics
+-- Hourly and daily min/max/avg/count rollups of numeric samples, written by the
+-- maintenance job before expired raw samples are removed; bucket is the start
+-- of the hour or day in UTC
+CREATE TABLE
+  `metrics_data_hourly` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `query_id` varchar(100) NOT NULL,
+    `source` varchar(100) NOT NULL DEFAULT 'default',
+    `metric_name` varchar(255) NOT NULL,
+    `labels` json NOT NULL,
+    `labels_hash` binary(16) GENERATED ALWAYS AS (unhex(md5(cast(`labels` as char)))) STORED NOT NULL,
+    `bucket` timestamp NOT NULL,
+    `min_value` double NULL,
+    `max_value` double NULL,
+    `avg_value` double NULL,
+    `sample_count` bigint NOT NULL,
+    `created_at` timestamp DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uk_series_bucket` (`query_id`, `metric_name`, `labels_hash`, `bucket`),
+    KEY `idx_query_id_bucket` (`query_id`, `bucket`)
+  ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
+
+CREATE TABLE
+  `metrics_data_daily` LIKE `metrics_data_hourly`;
+
+-- Time up to which the samples of each query were rolled up
+CREATE TABLE
+  `metrics_rollup_state` (
+    `query_id` varchar(100) NOT NULL,
+    `rolled_up_until` timestamp NOT NULL,
+    `updated_at` timestamp DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`query_id`)
+  ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
+
+-- Maintenance runs
+-- One row per query and run of the retention job; raw samples before cutoff were removed
+CREATE TABLE
+  `maintenance_runs` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `query_id` varchar(100) NOT NULL,
+    `status` enum ('running', 'success', 'failed') NOT NULL,
+    `start_time` timestamp(3) NOT NULL,
+    `end_time` timestamp(3) NULL,
+    `duration_ms` int NULL,
+    `cutoff` timestamp(3) NULL,
+    `rollup_rows` bigint DEFAULT 0,
+    `rows_deleted` bigint DEFAULT 0,
+    `rollups_deleted` bigint DEFAULT 0,
+    `error_message` text NULL,
+    `created_at` timestamp DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    KEY `idx_query_id_start_time` (`query_id`, `start_time`),
+    KEY `idx_status` (`status`)
+  ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
+
 -- Query configurations
 -- Stores query configuration information
+-- datasource is one datasource name, a comma separated list to fan out, or * for all datasources
 CREATE TABLE
   `query_configs` (
     `id` bigint NOT NULL AUTO_INCREMENT,
@@ -63,6 +127,7 @@ CREATE TABLE
     `name` varchar(255) NOT NULL,
     `description` text NULL,
     `query` text NOT NULL,
+    `datasource` varchar(1024) NULL,
     `schedule` varchar(100) NOT NULL,
     `timeout` varchar(20) DEFAULT '30s',
     `enabled` tinyint (1) DEFAULT 1,
@@ -73,6 +138,9 @@ CREATE TABLE
     `time_range_start` varchar(50) NULL,
     `time_range_end` varchar(50) NULL,
     `time_range_step` varchar(20) NULL,
+    `relabel_configs` json NULL,
+    `promoted_labels` json NULL,
+    `retention` json NULL,
     `created_at` timestamp DEFAULT CURRENT_TIMESTAMP,
     `updated_at` timestamp DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     PRIMARY KEY (`id`),
@@ -82,35 +150,29 @@ CREATE TABLE
     KEY `idx_created_at` (`created_at`)
   ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;
 
--- Insert initial query configuration
-INSERT INTO
-  query_configs (
-    query_id,
-    name,
-    description,
-    query,
-    schedule,
-    timeout,
-    enabled,
-    retry_count,
-    retry_interval,
-    time_range_type,
-    time_range_time
-  )
-VALUES
-  (
-    'gpu_utilization_daily',
-    'GPU每日利用率统计',
-    '每天凌晨1点统计昨天完整24小时的GPU利用率数据',
-    'sum(sum_over_time(max without(exported_namespace, exported_pod, modelName, prometheus, cluster, insight, mode) (kpanda_gpu_pod_utilization != bool 999999)[24h:1m])) by (cluster_name, node, UUID) * 60 / 3600',
-    '0 0 1 * * *',
-    '120s',
-    1,
-    3,
-    '60s',
-    'instant',
-    'yesterday_end'
-  );
-
-SET
-  FOREIGN_KEY_CHECKS = 1;
\ No newline at end of file
+-- Prometheus datasources
+-- Queries select one by name in query_configs.datasource;
+-- queries without one use the PROMETHEUS_* endpoint, recorded as source 'default'
+CREATE TABLE
+  `datasources` (
+    `id` bigint NOT NULL AUTO_INCREMENT,
+    `name` varchar(100) NOT NULL,
+    `url` varchar(1024) NOT NULL,
+    `timeout` varchar(20) NULL,
+    `auth_type` enum ('none', 'basic', 'bearer') DEFAULT 'none',
+    `username` varchar(255) NULL,
+    `password` varchar(1024) NULL,
+    `password_file` varchar(1024) NULL,
+    `token` text NULL,
+    `token_file` varchar(1024) NULL,
+    `tls_ca_file` varchar(1024) NULL,
+    `tls_cert_file` varchar(1024) NULL,
+    `tls_key_file` varchar(1024) NULL,
+    `tls_server_name` varchar(255) NULL,
+    `tls_insecure_skip_verify` tinyint (1) DEFAULT 0,
+    `headers` json NULL,
+    `created_at` timestamp DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` timestamp DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uk_name` (`name`)
+  ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_unicode_ci;